@@ -36,9 +36,7 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		dbUrl, _ := cmd.Flags().GetString("dbUrl")
-
-		return runCleanup(dbUrl)
+		return runCleanup(postgresConfig(cmd))
 	},
 }
 
@@ -46,10 +44,8 @@ func init() {
 	postgresCmd.AddCommand(cleanupCmd)
 }
 
-func runCleanup(dbUrl string) error {
-	backend, err := postgres.NewBackend(&postgres.Config{
-		Url: dbUrl,
-	})
+func runCleanup(config *postgres.Config) error {
+	backend, err := postgres.NewBackend(config)
 
 	if err != nil {
 		return err
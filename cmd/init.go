@@ -38,9 +38,7 @@ Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		dbUrl, _ := cmd.Flags().GetString("dbUrl")
-
-		err := runInit(dbUrl)
+		err := runInit(postgresConfig(cmd))
 		if err != nil {
 			log.Print(err)
 			os.Exit(1)
@@ -52,10 +50,8 @@ func init() {
 	postgresCmd.AddCommand(initCmd)
 }
 
-func runInit(dbUrl string) error {
-	backend, err := postgres.NewBackend(&postgres.Config{
-		Url: dbUrl,
-	})
+func runInit(config *postgres.Config) error {
+	backend, err := postgres.NewBackend(config)
 
 	if err != nil {
 		return err
@@ -0,0 +1,106 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+func init() {
+	RootCmd.AddCommand(passwordsCmd)
+	passwordsCmd.AddCommand(passwordsReportCmd)
+
+	passwordsReportCmd.Flags().String("config", "config.json", "configuration file for the backends in json format")
+}
+
+var passwordsCmd = &cobra.Command{
+	Use:   "passwords",
+	Short: "Inspect the password hashes of the backends",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var passwordsReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Count the password hashes of every backend by scheme and cost",
+	Long: `Count the password hashes of every backend storing them by scheme and cost.
+Hashes marked as outdated are rehashed on the next bind if the backend supports it,
+the target scheme and cost are given by --password-scheme and --password-cost.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		configFile, _ := cmd.Flags().GetString("config")
+
+		f, err := os.Open(configFile)
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		backends, err := newLoader().Load(f)
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+
+		err = passwordsReport(context.Background(), backends)
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func passwordsReport(ctx context.Context, backends []pkg.Backend) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BACKEND\tSCHEME\tCOST\tCOUNT\tOUTDATED")
+
+	for _, backend := range backends {
+		store, ok := pkg.FindPasswordStore(backend)
+		if !ok {
+			continue
+		}
+
+		hashes, err := store.PasswordHashes(ctx)
+		if err != nil {
+			return fmt.Errorf("%s: %v", backend.Name(), err)
+		}
+
+		for _, count := range util.CountPasswordHashes(hashes) {
+			cost := "-"
+			if count.Cost != 0 {
+				cost = strconv.Itoa(count.Cost)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%t\n", backend.Name(), count.Scheme, cost, count.Count, count.Outdated)
+		}
+	}
+
+	return w.Flush()
+}
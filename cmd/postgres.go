@@ -34,6 +34,26 @@ func init() {
 	RootCmd.AddCommand(postgresCmd)
 	postgresCmd.AddCommand(addCmd)
 	addCmd.AddCommand(addUserCmd)
+
+	flags := postgresCmd.PersistentFlags()
+	flags.String("dbUrl", "", "the url of the database")
+	flags.String("table", "", "the table of the users (default users)")
+	flags.String("login-column", "", "the column of the user names (default name)")
+	flags.String("password-column", "", "the column of the password hashes (default password)")
+}
+
+// postgresConfig creates the backend configuration from the flags of the
+// postgres commands.
+func postgresConfig(cmd *cobra.Command) *postgres.Config {
+	flags := cmd.Flags()
+
+	config := &postgres.Config{}
+	config.Url, _ = flags.GetString("dbUrl")
+	config.Table, _ = flags.GetString("table")
+	config.LoginColumn, _ = flags.GetString("login-column")
+	config.PasswordColumn, _ = flags.GetString("password-column")
+
+	return config
 }
 
 var postgresCmd = &cobra.Command{
@@ -74,7 +94,6 @@ var addUserCmd = &cobra.Command{
 		}
 
 		user := args[0]
-		dbUrl, _ := cmd.Flags().GetString("dbUrl")
 
 		fmt.Print("Password: ")
 		password, err := gopass.GetPasswd()
@@ -83,14 +102,12 @@ var addUserCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		postgresAddUser(dbUrl, user, password)
+		postgresAddUser(postgresConfig(cmd), user, password)
 	},
 }
 
-func postgresAddUser(dbUrl string, user string, password []byte) {
-	backend, err := postgres.NewBackend(&postgres.Config{
-		Url: dbUrl,
-	})
+func postgresAddUser(config *postgres.Config, user string, password []byte) {
+	backend, err := postgres.NewBackend(config)
 
 	if err != nil {
 		log.Print(err)
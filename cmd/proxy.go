@@ -23,15 +23,35 @@ package cmd
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
 	"crypto/tls"
 	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/breaker"
+	"github.com/gopenguin/ldap-proxy/pkg/cache"
 	"github.com/gopenguin/ldap-proxy/pkg/config"
+	"github.com/gopenguin/ldap-proxy/pkg/file"
+	"github.com/gopenguin/ldap-proxy/pkg/htpasswd"
+	"github.com/gopenguin/ldap-proxy/pkg/ldif"
 	"github.com/gopenguin/ldap-proxy/pkg/log"
 	"github.com/gopenguin/ldap-proxy/pkg/memory"
+	"github.com/gopenguin/ldap-proxy/pkg/oauth"
+	"github.com/gopenguin/ldap-proxy/pkg/policy"
 	"github.com/gopenguin/ldap-proxy/pkg/postgres"
+	"github.com/gopenguin/ldap-proxy/pkg/radius"
+	"github.com/gopenguin/ldap-proxy/pkg/referral"
+	"github.com/gopenguin/ldap-proxy/pkg/rest"
+	"github.com/gopenguin/ldap-proxy/pkg/rewrite"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"github.com/gopenguin/ldap-proxy/pkg/script"
+	"github.com/gopenguin/ldap-proxy/pkg/totp"
+	"github.com/gopenguin/ldap-proxy/pkg/transform"
+	"github.com/gopenguin/ldap-proxy/pkg/upstream"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"net/http"
@@ -40,12 +60,14 @@ import (
 type proxyConfig struct {
 	Port   int
 	Config string
+	Schema string
 
 	ServerCert string
 	ServerKey  string
 
 	Prometheus     bool
 	PrometheusAddr string
+	AdminTokenFile string
 }
 
 // proxyCmd represents the proxy subcommand.
@@ -70,12 +92,14 @@ func proxyCmd() *cobra.Command {
 
 	proxyCmd.Flags().IntVarP(&c.Port, "port", "p", 10636, "port to listen on for secure ldap communication")
 	proxyCmd.Flags().StringVar(&c.Config, "config", "config.json", "configuration file for the backends in json format")
+	proxyCmd.Flags().StringVar(&c.Schema, "schema", "", "json file with additional attribute types")
 
 	proxyCmd.Flags().StringVar(&c.ServerCert, "server-cert", "server.pem", "the server certificate")
 	proxyCmd.Flags().StringVar(&c.ServerKey, "server-key", "server-key.pem", "the servers private key")
 
 	proxyCmd.Flags().BoolVar(&c.Prometheus, "prometheus", false, "enable prometheus metrics")
 	proxyCmd.Flags().StringVar(&c.PrometheusAddr, "prometheus-addr", ":8080", "port to serve the prometheus metrics on")
+	proxyCmd.Flags().StringVar(&c.AdminTokenFile, "admin-token-file", "", "file with the bearer token enabling the cache invalidation on the prometheus port")
 
 	return proxyCmd
 }
@@ -86,6 +110,7 @@ func init() {
 
 func runProxyFromConfigFile(c *proxyConfig) {
 	initPrometheus(c)
+	loadSchema(c)
 
 	log.Printf("Loading Config from %s", c.Config)
 	f, err := os.Open(c.Config)
@@ -94,10 +119,7 @@ func runProxyFromConfigFile(c *proxyConfig) {
 		os.Exit(1)
 	}
 
-	loader := config.NewLoader()
-
-	loader.AddFactory(memory.NewFactory())
-	loader.AddFactory(postgres.NewFactory())
+	loader := newLoader()
 
 	reader := bufio.NewReader(f)
 	backends, err := loader.Load(reader)
@@ -108,11 +130,79 @@ func runProxyFromConfigFile(c *proxyConfig) {
 
 	tlsConfig := loadTlsConfig(c)
 
+	go closeOnSignal(backends)
+
 	proxy := pkg.NewLdapProxy()
 	proxy.AddBackend(backends...)
 	proxy.ListenAndServeTLS("tcp", fmt.Sprintf(":%d", c.Port), tlsConfig)
 }
 
+// closeOnSignal closes the connections of the backends and exits on SIGINT or
+// SIGTERM.
+func closeOnSignal(backends []pkg.Backend) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	sig := <-signals
+	log.Printf("Received %s, closing the backends", sig)
+
+	for _, backend := range backends {
+		pkg.CloseBackend(backend)
+	}
+
+	os.Exit(0)
+}
+
+// newLoader creates the config loader with all backends and wrappers.
+func newLoader() *config.Loader {
+	loader := config.NewLoader()
+
+	loader.AddFactory(memory.NewFactory())
+	loader.AddFactory(oauth.NewFactory())
+	loader.AddFactory(file.NewFactory())
+	loader.AddFactory(htpasswd.NewFactory())
+	loader.AddFactory(ldif.NewFactory())
+	loader.AddFactory(postgres.NewFactory())
+	loader.AddFactory(postgres.NewSqlFactory())
+	loader.AddFactory(radius.NewFactory())
+	loader.AddFactory(referral.NewFactory())
+	loader.AddFactory(rest.NewFactory())
+	loader.AddFactory(script.NewFactory())
+	loader.AddFactory(upstream.NewFactory())
+
+	// the wrappers are applied from the inside out: cached binds are answered
+	// while the breaker is open, the totp codes are checked on every bind and the
+	// dns are rewritten last
+	loader.AddWrapper(breaker.NewWrapperFactory())
+	loader.AddWrapper(cache.NewWrapperFactory())
+	loader.AddWrapper(transform.NewWrapperFactory())
+	loader.AddWrapper(totp.NewWrapperFactory())
+	loader.AddWrapper(rewrite.NewWrapperFactory())
+	loader.AddWrapper(policy.NewWrapperFactory())
+
+	return loader
+}
+
+func loadSchema(c *proxyConfig) {
+	if c.Schema == "" {
+		return
+	}
+
+	log.Printf("Loading schema from %s", c.Schema)
+	f, err := os.Open(c.Schema)
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	err = schema.Default.Load(f)
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+}
+
 func loadTlsConfig(c *proxyConfig) *tls.Config {
 	cer, err := tls.LoadX509KeyPair(c.ServerCert, c.ServerKey)
 	if err != nil {
@@ -135,6 +225,18 @@ func initPrometheus(c *proxyConfig) {
 	}
 
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/health", breaker.Handler())
+
+	// the invalidation changes the state of the proxy, it needs a token
+	if c.AdminTokenFile != "" {
+		token, err := ioutil.ReadFile(c.AdminTokenFile)
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+
+		http.Handle("/cache/invalidate", cache.Handler(strings.TrimSpace(string(token))))
+	}
 
 	log.Print("Starting prometheus server on ", c.PrometheusAddr)
 	go http.ListenAndServe(c.PrometheusAddr, nil)
@@ -25,19 +25,40 @@ import (
 	"os"
 
 	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
 	"github.com/spf13/cobra"
 )
 
+type passwordConfig struct {
+	Scheme    string
+	Cost      int
+	Cleartext bool
+}
+
+var passwords = &passwordConfig{}
+
 // RootCmd represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "ldap-proxy",
 	Short: "The command line interface of the ldap proxy",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if passwords.Cleartext {
+			log.Print("Cleartext passwords are allowed, don't use this in production")
+			util.AllowCleartextPasswords()
+		}
+
+		return util.SetDefaultPasswordScheme(passwords.Scheme, passwords.Cost)
+	},
 }
 
 func init() {
 	cobra.OnInitialize(log.Reinit)
 
 	RootCmd.PersistentFlags().BoolVar(&log.DebugEnabled, "debug", log.DebugEnabled, "enable debug logging")
+
+	RootCmd.PersistentFlags().StringVar(&passwords.Scheme, "password-scheme", "bcrypt", "the scheme of new password hashes")
+	RootCmd.PersistentFlags().IntVar(&passwords.Cost, "password-cost", 0, "the cost of new password hashes, 0 selects the default of the scheme")
+	RootCmd.PersistentFlags().BoolVar(&passwords.Cleartext, "allow-cleartext-passwords", false, "accept {CLEARTEXT} passwords for development")
 }
 
 // Execute adds all child commands to the root command sets flags appropriately.
@@ -0,0 +1,114 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg/totp"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+func init() {
+	RootCmd.AddCommand(totpCmd)
+	totpCmd.AddCommand(totpEnrollCmd)
+
+	totpEnrollCmd.Flags().String("config", "config.json", "configuration file for the backends in json format")
+	totpEnrollCmd.Flags().String("backend", "", "the name of the backend with the totp configuration")
+}
+
+var totpCmd = &cobra.Command{
+	Use:   "totp",
+	Short: "Manage the totp secrets of users",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var totpEnrollCmd = &cobra.Command{
+	Use:   "enroll [name]",
+	Short: "Create a new totp secret for a user and print the provisioning uri",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			cmd.Help()
+			return
+		}
+
+		configFile, _ := cmd.Flags().GetString("config")
+		backend, _ := cmd.Flags().GetString("backend")
+
+		totpConfig, err := loadTotpConfig(configFile, backend)
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+
+		uri, secret, err := totp.Enroll(context.Background(), totpConfig, args[0])
+		if err == totp.ErrReadOnly {
+			fmt.Printf("The secret source is read only, store the secret %s for the user.\n", secret)
+		} else if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+
+		fmt.Println(uri)
+	},
+}
+
+// loadTotpConfig reads the totp configuration of the backend with the given
+// name. The name may be omitted if only one backend uses totp.
+func loadTotpConfig(configFile string, backend string) (*totp.Config, error) {
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var backends []struct {
+		Name string           `json:"name"`
+		Totp *json.RawMessage `json:"totp"`
+	}
+	err = json.NewDecoder(f).Decode(&backends)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *json.RawMessage
+	for _, b := range backends {
+		if b.Totp == nil || (backend != "" && b.Name != backend) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("multiple backends use totp, select one with --backend")
+		}
+		found = b.Totp
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no backend with totp configuration found")
+	}
+
+	totpConfig := &totp.Config{}
+	err = json.Unmarshal(*found, totpConfig)
+	return totpConfig, err
+}
@@ -21,9 +21,24 @@
 package examples
 
 import (
+	"github.com/gopenguin/ldap-proxy/pkg/breaker"
+	"github.com/gopenguin/ldap-proxy/pkg/cache"
 	"github.com/gopenguin/ldap-proxy/pkg/config"
+	"github.com/gopenguin/ldap-proxy/pkg/file"
+	"github.com/gopenguin/ldap-proxy/pkg/htpasswd"
+	"github.com/gopenguin/ldap-proxy/pkg/ldif"
 	"github.com/gopenguin/ldap-proxy/pkg/memory"
+	"github.com/gopenguin/ldap-proxy/pkg/oauth"
+	"github.com/gopenguin/ldap-proxy/pkg/policy"
 	"github.com/gopenguin/ldap-proxy/pkg/postgres"
+	"github.com/gopenguin/ldap-proxy/pkg/radius"
+	"github.com/gopenguin/ldap-proxy/pkg/referral"
+	"github.com/gopenguin/ldap-proxy/pkg/rest"
+	"github.com/gopenguin/ldap-proxy/pkg/rewrite"
+	"github.com/gopenguin/ldap-proxy/pkg/script"
+	"github.com/gopenguin/ldap-proxy/pkg/totp"
+	"github.com/gopenguin/ldap-proxy/pkg/transform"
+	"github.com/gopenguin/ldap-proxy/pkg/upstream"
 	"os"
 	"path/filepath"
 	"testing"
@@ -35,7 +50,24 @@ func TestExamplesLoadable(t *testing.T) {
 
 	loader := config.NewLoader()
 	loader.AddFactory(memory.NewFactory())
+	loader.AddFactory(oauth.NewFactory())
+	loader.AddFactory(file.NewFactory())
+	loader.AddFactory(htpasswd.NewFactory())
+	loader.AddFactory(ldif.NewFactory())
 	loader.AddFactory(postgres.NewFactory())
+	loader.AddFactory(postgres.NewSqlFactory())
+	loader.AddFactory(radius.NewFactory())
+	loader.AddFactory(referral.NewFactory())
+	loader.AddFactory(rest.NewFactory())
+	loader.AddFactory(script.NewFactory())
+	loader.AddFactory(upstream.NewFactory())
+
+	loader.AddWrapper(breaker.NewWrapperFactory())
+	loader.AddWrapper(cache.NewWrapperFactory())
+	loader.AddWrapper(transform.NewWrapperFactory())
+	loader.AddWrapper(totp.NewWrapperFactory())
+	loader.AddWrapper(rewrite.NewWrapperFactory())
+	loader.AddWrapper(policy.NewWrapperFactory())
 
 	for _, match := range matches {
 		t.Log(match)
@@ -33,8 +33,34 @@ type User struct {
 	Attributes map[string][]string // Additional information about the user
 }
 
+// Copy returns a deep copy of the user. Backends returning cached users must
+// copy them as wrapping backends and the proxy modify the results.
+func (user *User) Copy() *User {
+	copied := &User{
+		DN:         user.DN,
+		Attributes: make(map[string][]string, len(user.Attributes)),
+	}
+	for attribute, values := range user.Attributes {
+		copied.Attributes[attribute] = append([]string(nil), values...)
+	}
+
+	return copied
+}
+
 var (
 	ErrInvalidConfigType = errors.New("ldap-proxy: invalid configuration object type")
+
+	// ErrSearchRejected is returned by GetUsers if the search isn't allowed,
+	// the client receives unwilling to perform.
+	ErrSearchRejected = errors.New("ldap-proxy: search rejected")
+
+	// ErrUnavailable is returned by GetUsers if the backend can't be reached,
+	// the client receives unavailable.
+	ErrUnavailable = errors.New("ldap-proxy: backend unavailable")
+
+	// ErrUnknownUser is returned by AuthenticateErr if the backend has no user
+	// with the username. It is a rejection, not a failure of the backend.
+	ErrUnknownUser = errors.New("ldap-proxy: unknown user")
 )
 
 type BackendFactory interface {
@@ -43,12 +69,105 @@ type BackendFactory interface {
 	New(config interface{}) (backend Backend, err error)
 }
 
+// A WrapperFactory adds functionality to any backend. A backend is wrapped if
+// its configuration contains an object with the name of the wrapper.
+type WrapperFactory interface {
+	Name() string
+	NewConfig() interface{}
+	Wrap(backend Backend, config interface{}) (wrapped Backend, err error)
+}
+
 type Backend interface {
 	Name() (name string)
 	Authenticate(ctx context.Context, username string, password string) bool
 	GetUsers(ctx context.Context, f ldap.Filter) ([]*User, error)
 }
 
+// An ErrorAuthenticator is a backend which tells rejected credentials apart
+// from failures like an unreachable server. AuthenticateErr returns an error
+// only if the credentials couldn't be checked or ErrUnknownUser if the backend
+// knows that the user doesn't exist.
+type ErrorAuthenticator interface {
+	AuthenticateErr(ctx context.Context, username string, password string) (bool, error)
+}
+
+// A Referrer is a backend which refers the client to another server for a part
+// of the directory instead of answering the request itself.
+type Referrer interface {
+	// Referral returns the ldap urls for the given dn or nil if the dn isn't
+	// part of the referred naming context.
+	Referral(dn string) (urls []string)
+}
+
+// A PasswordStore is a backend storing the password hashes of its users.
+type PasswordStore interface {
+	// PasswordHashes returns the stored hashes of all users
+	PasswordHashes(ctx context.Context) ([]string, error)
+}
+
+// A Wrapper is a backend adding functionality to another backend.
+type Wrapper interface {
+	Unwrap() Backend
+}
+
+// FindPasswordStore returns the password store of the backend or of the
+// backends wrapped by it.
+func FindPasswordStore(backend Backend) (PasswordStore, bool) {
+	for backend != nil {
+		if store, ok := backend.(PasswordStore); ok {
+			return store, true
+		}
+
+		wrapper, ok := backend.(Wrapper)
+		if !ok {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+
+	return nil, false
+}
+
+// FindReferrer returns the referrer of the backend or of the backends wrapped
+// by it.
+func FindReferrer(backend Backend) (Referrer, bool) {
+	for backend != nil {
+		if referrer, ok := backend.(Referrer); ok {
+			return referrer, true
+		}
+
+		wrapper, ok := backend.(Wrapper)
+		if !ok {
+			break
+		}
+		backend = wrapper.Unwrap()
+	}
+
+	return nil, false
+}
+
+// A Closer is a backend holding connections, Close releases them when the
+// proxy shuts down.
+type Closer interface {
+	Close()
+}
+
+// CloseBackend closes the backend and the wrapped backends implementing
+// Closer.
+func CloseBackend(backend Backend) {
+	for backend != nil {
+		if closer, ok := backend.(Closer); ok {
+			closer.Close()
+		}
+
+		wrapper, ok := backend.(Wrapper)
+		if !ok {
+			return
+		}
+		backend = wrapper.Unwrap()
+	}
+}
+
 type Config struct {
 	Name        string `json:"name"`
 	DNAttribute string `json:"dnAttribute"`
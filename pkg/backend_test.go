@@ -23,6 +23,9 @@ package pkg
 import (
 	"context"
 	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
 )
 
 type testBackend struct {
@@ -32,6 +35,7 @@ type testBackend struct {
 	result bool
 
 	user []*User
+	err  error
 }
 
 func (backend *testBackend) Authenticate(ctx context.Context, username string, password string) bool {
@@ -46,5 +50,82 @@ func (backend *testBackend) Name() (name string) {
 }
 
 func (backend *testBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*User, error) {
-	return backend.user, nil
+	return backend.user, backend.err
+}
+
+type testReferrer struct {
+	testBackend
+
+	namingContext string
+}
+
+func (backend *testReferrer) Referral(dn string) []string {
+	if !strings.HasSuffix(dn, backend.namingContext) {
+		return nil
+	}
+
+	return []string{"ldap://legacy/" + dn}
+}
+
+type testPasswordStore struct {
+	testBackend
+}
+
+func (backend *testPasswordStore) PasswordHashes(ctx context.Context) ([]string, error) {
+	return []string{"{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA=="}, nil
+}
+
+type testCloser struct {
+	testBackend
+
+	closed int
+}
+
+func (backend *testCloser) Close() {
+	backend.closed++
+}
+
+type testWrapper struct {
+	Backend
+}
+
+func (wrapper *testWrapper) Unwrap() Backend {
+	return wrapper.Backend
+}
+
+func TestFindPasswordStore(t *testing.T) {
+	Convey("Given a wrapped password store", t, func() {
+		store := &testPasswordStore{}
+		backend := &testWrapper{Backend: &testWrapper{Backend: store}}
+
+		Convey("Then the store is found", func() {
+			found, ok := FindPasswordStore(backend)
+			So(ok, ShouldBeTrue)
+			So(found, ShouldEqual, store)
+		})
+	})
+
+	Convey("Given a wrapped backend without passwords", t, func() {
+		backend := &testWrapper{Backend: &testBackend{}}
+
+		Convey("Then no store is found", func() {
+			_, ok := FindPasswordStore(backend)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCloseBackend(t *testing.T) {
+	Convey("Given a wrapped backend with connections", t, func() {
+		closer := &testCloser{}
+		backend := &testWrapper{Backend: &testWrapper{Backend: closer}}
+
+		Convey("When the backend is closed", func() {
+			CloseBackend(backend)
+
+			Convey("Then the wrapped backend is closed once", func() {
+				So(closer.closed, ShouldEqual, 1)
+			})
+		})
+	})
 }
@@ -0,0 +1,274 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package breaker
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samuel/go-ldap/ldap"
+	"sync"
+	"time"
+)
+
+// the states of a breaker
+const (
+	closed   = 0
+	halfOpen = 1
+	open     = 2
+)
+
+var stateNames = map[int]string{
+	closed:   "closed",
+	halfOpen: "half-open",
+	open:     "open",
+}
+
+var (
+	breakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "breaker",
+		Name:      "state",
+		Help:      "The state of the circuit breaker (0 closed, 1 half-open, 2 open)",
+	}, []string{"backend"})
+
+	rejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "breaker",
+		Name:      "rejected_total",
+		Help:      "The total number of requests rejected by an open circuit breaker",
+	}, []string{"backend", "action"})
+)
+
+func init() {
+	prometheus.MustRegister(breakerState)
+	prometheus.MustRegister(rejectedTotal)
+}
+
+type wrapperFactory struct{}
+
+func NewWrapperFactory() pkg.WrapperFactory {
+	return &wrapperFactory{}
+}
+
+func (wrapperFactory) Name() string {
+	return "breaker"
+}
+
+func (wrapperFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (wrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	breakerConfig, ok := config.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	breaker := NewBackend(backend, breakerConfig)
+	register(breaker)
+
+	return breaker, nil
+}
+
+type Config struct {
+	Failures     int           `json:"failures"`
+	Timeout      util.Duration `json:"timeout"`
+	ResetTimeout util.Duration `json:"resetTimeout"`
+}
+
+func (config *Config) failures() int {
+	if config.Failures <= 0 {
+		return 5
+	}
+
+	return config.Failures
+}
+
+// Backend is a circuit breaker. It opens after a number of consecutive
+// failures, i. e. search errors, bind errors of delegates implementing
+// pkg.ErrorAuthenticator and requests exceeding the timeout. While open binds
+// fail and searches return pkg.ErrUnavailable without calling the delegate. After
+// the reset timeout a single request probes the delegate (half-open) and
+// closes the breaker on success.
+type Backend struct {
+	delegate pkg.Backend
+	config   *Config
+	now      func() time.Time
+
+	mutex    sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Wrapper = &Backend{}
+var _ pkg.ErrorAuthenticator = &Backend{}
+
+func NewBackend(delegate pkg.Backend, config *Config) *Backend {
+	backend := &Backend{
+		delegate: delegate,
+		config:   config,
+		now:      time.Now,
+	}
+	breakerState.With(prometheus.Labels{"backend": backend.Name()}).Set(closed)
+
+	return backend
+}
+
+func (backend *Backend) Name() string {
+	return backend.delegate.Name()
+}
+
+func (backend *Backend) Unwrap() pkg.Backend {
+	return backend.delegate
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	ok, _ := backend.AuthenticateErr(ctx, username, password)
+	return ok
+}
+
+func (backend *Backend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	if !backend.allow() {
+		backend.reject("auth")
+		return false, pkg.ErrUnavailable
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, backend.config.Timeout.Or(10*time.Second))
+	defer cancel()
+
+	var ok bool
+	var err error
+	if authenticator, isErrorAuthenticator := backend.delegate.(pkg.ErrorAuthenticator); isErrorAuthenticator {
+		ok, err = authenticator.AuthenticateErr(callCtx, username, password)
+		if err != nil && err != pkg.ErrUnknownUser {
+			log.Debugf("breaker: bind of %s at %s failed: %v", username, backend.Name(), err)
+		}
+	} else {
+		ok = backend.delegate.Authenticate(callCtx, username, password)
+	}
+
+	// a rejected bind is a success of the backend, only errors and timeouts count
+	backend.done(ctx, (err == nil || err == pkg.ErrUnknownUser) && callCtx.Err() == nil)
+
+	return ok, err
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	if !backend.allow() {
+		backend.reject("search")
+		return nil, pkg.ErrUnavailable
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, backend.config.Timeout.Or(10*time.Second))
+	defer cancel()
+
+	users, err := backend.delegate.GetUsers(callCtx, f)
+	backend.done(ctx, err == nil && callCtx.Err() == nil)
+
+	return users, err
+}
+
+// State returns the name of the current state
+func (backend *Backend) State() string {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	return stateNames[backend.state]
+}
+
+// allow reports weather the delegate may be called. In half-open state only
+// one probe is allowed at a time.
+func (backend *Backend) allow() bool {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	switch backend.state {
+	case open:
+		if backend.now().Sub(backend.openedAt) < backend.config.ResetTimeout.Or(30*time.Second) {
+			return false
+		}
+		backend.setState(halfOpen)
+		fallthrough
+	case halfOpen:
+		if backend.probing {
+			return false
+		}
+		backend.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// done records the result of a call. Calls aborted by the client aren't
+// counted.
+func (backend *Backend) done(ctx context.Context, success bool) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	probe := backend.state == halfOpen
+	if probe {
+		backend.probing = false
+	}
+
+	if !success && ctx.Err() != nil {
+		return
+	}
+
+	switch {
+	case success:
+		backend.failures = 0
+		if probe {
+			backend.setState(closed)
+		}
+	case probe:
+		backend.openedAt = backend.now()
+		backend.setState(open)
+	default:
+		backend.failures++
+		if backend.state == closed && backend.failures >= backend.config.failures() {
+			backend.openedAt = backend.now()
+			backend.setState(open)
+		}
+	}
+}
+
+// setState changes the state, the mutex must be held
+func (backend *Backend) setState(state int) {
+	if backend.state == state {
+		return
+	}
+
+	log.Printf("breaker: %s is %s", backend.Name(), stateNames[state])
+
+	backend.state = state
+	backend.failures = 0
+	breakerState.With(prometheus.Labels{"backend": backend.Name()}).Set(float64(state))
+}
+
+func (backend *Backend) reject(action string) {
+	log.Debugf("breaker: %s is open, skipping %s", backend.Name(), action)
+	rejectedTotal.With(prometheus.Labels{"backend": backend.Name(), "action": action}).Inc()
+}
@@ -0,0 +1,234 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package breaker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type flakyBackend struct {
+	name  string
+	down  bool
+	slow  bool
+	calls int
+}
+
+// errorBackend reports bind errors while it is down
+type errorBackend struct {
+	flakyBackend
+}
+
+func (backend *errorBackend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	backend.calls++
+	if backend.down {
+		return false, errors.New("connection refused")
+	}
+	if username != "alice" {
+		return false, pkg.ErrUnknownUser
+	}
+	return password == "test123", nil
+}
+
+func (backend *flakyBackend) Name() string {
+	return backend.name
+}
+
+func (backend *flakyBackend) Authenticate(ctx context.Context, username string, password string) bool {
+	backend.calls++
+	if backend.slow {
+		<-ctx.Done()
+		return false
+	}
+	return password == "test123"
+}
+
+func (backend *flakyBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.calls++
+	if backend.down {
+		return nil, errors.New("connection refused")
+	}
+	return []*pkg.User{{DN: "alice"}}, nil
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given a breaker", t, func() {
+		delegate := &flakyBackend{name: "flaky"}
+		now := time.Now()
+
+		backend := NewBackend(delegate, &Config{
+			Failures: 2,
+			Timeout:  util.Duration{Duration: 10 * time.Millisecond},
+		})
+		backend.now = func() time.Time { return now }
+
+		ctx := context.Background()
+
+		Convey("Then wrong passwords don't open the breaker", func() {
+			for i := 0; i < 3; i++ {
+				So(backend.Authenticate(ctx, "alice", "wrong"), ShouldBeFalse)
+			}
+
+			So(backend.State(), ShouldEqual, "closed")
+		})
+
+		Convey("When the searches fail", func() {
+			delegate.down = true
+			for i := 0; i < 2; i++ {
+				_, err := backend.GetUsers(ctx, nil)
+				So(err, ShouldNotBeNil)
+			}
+
+			Convey("Then the breaker opens and fails fast", func() {
+				So(backend.State(), ShouldEqual, "open")
+
+				users, err := backend.GetUsers(ctx, nil)
+				So(err, ShouldEqual, pkg.ErrUnavailable)
+				So(users, ShouldBeEmpty)
+				So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeFalse)
+				So(delegate.calls, ShouldEqual, 2)
+			})
+
+			Convey("When the reset timeout passed and the backend is up again", func() {
+				now = now.Add(time.Minute)
+				delegate.down = false
+
+				Convey("Then a probe closes the breaker", func() {
+					So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeTrue)
+					So(backend.State(), ShouldEqual, "closed")
+				})
+			})
+
+			Convey("When the reset timeout passed and the backend is still down", func() {
+				now = now.Add(time.Minute)
+
+				Convey("Then a failed probe opens the breaker again", func() {
+					_, err := backend.GetUsers(ctx, nil)
+					So(err, ShouldNotBeNil)
+					So(backend.State(), ShouldEqual, "open")
+				})
+			})
+		})
+
+		Convey("When the binds time out", func() {
+			delegate.slow = true
+			backend.Authenticate(ctx, "alice", "test123")
+			backend.Authenticate(ctx, "alice", "test123")
+
+			Convey("Then the breaker opens", func() {
+				So(backend.State(), ShouldEqual, "open")
+			})
+		})
+
+		Convey("When the binds fail fast with errors", func() {
+			delegate := &errorBackend{flakyBackend{name: "errors", down: true}}
+			backend := NewBackend(delegate, &Config{Failures: 2})
+
+			for i := 0; i < 2; i++ {
+				ok, err := backend.AuthenticateErr(ctx, "alice", "test123")
+				So(ok, ShouldBeFalse)
+				So(err, ShouldNotBeNil)
+			}
+
+			Convey("Then the breaker opens", func() {
+				So(backend.State(), ShouldEqual, "open")
+
+				ok, err := backend.AuthenticateErr(ctx, "alice", "test123")
+				So(ok, ShouldBeFalse)
+				So(err, ShouldEqual, pkg.ErrUnavailable)
+				So(delegate.calls, ShouldEqual, 2)
+			})
+		})
+
+		Convey("When wrong passwords and unknown users are rejected without errors", func() {
+			delegate := &errorBackend{flakyBackend{name: "errors"}}
+			backend := NewBackend(delegate, &Config{Failures: 2})
+
+			for i := 0; i < 3; i++ {
+				So(backend.Authenticate(ctx, "alice", "wrong"), ShouldBeFalse)
+				So(backend.Authenticate(ctx, "bob", "test123"), ShouldBeFalse)
+			}
+
+			Convey("Then the breaker stays closed", func() {
+				So(backend.State(), ShouldEqual, "closed")
+			})
+		})
+
+		Convey("When the client cancels the binds", func() {
+			delegate.slow = true
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			backend.Authenticate(cancelled, "alice", "test123")
+			backend.Authenticate(cancelled, "alice", "test123")
+
+			Convey("Then the breaker stays closed", func() {
+				So(backend.State(), ShouldEqual, "closed")
+			})
+		})
+	})
+}
+
+func TestHandler(t *testing.T) {
+	Convey("Given a registered breaker", t, func() {
+		delegate := &flakyBackend{name: "health", down: true}
+		backend, err := NewWrapperFactory().Wrap(delegate, &Config{Failures: 1})
+		So(err, ShouldBeNil)
+
+		health := func() (int, map[string]interface{}) {
+			rec := httptest.NewRecorder()
+			Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			body := map[string]interface{}{}
+			So(json.Unmarshal(rec.Body.Bytes(), &body), ShouldBeNil)
+			return rec.Code, body
+		}
+
+		Convey("Then the state is closed", func() {
+			_, body := health()
+
+			So(body["backends"].(map[string]interface{})["health"], ShouldEqual, "closed")
+		})
+
+		Convey("When the breaker opens", func() {
+			backend.GetUsers(context.Background(), nil)
+
+			Convey("Then the state is open", func() {
+				code, body := health()
+
+				So(body["backends"].(map[string]interface{})["health"], ShouldEqual, "open")
+				So(body["status"], ShouldNotEqual, "ok")
+				if body["status"] == "down" {
+					So(code, ShouldEqual, http.StatusServiceUnavailable)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package breaker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]*Backend{}
+)
+
+func register(backend *Backend) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry[backend.Name()] = backend
+}
+
+type health struct {
+	Status   string            `json:"status"`
+	Backends map[string]string `json:"backends"`
+}
+
+// Handler serves the state of all breakers. The status is degraded if a
+// breaker isn't closed and down with the status 503 if all breakers are
+// open.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := health{
+			Status:   "ok",
+			Backends: map[string]string{},
+		}
+
+		registryMutex.Lock()
+		openBreakers := 0
+		for name, backend := range registry {
+			state := backend.State()
+			result.Backends[name] = state
+
+			if state != stateNames[closed] {
+				result.Status = "degraded"
+			}
+			if state == stateNames[open] {
+				openBreakers++
+			}
+		}
+		if len(registry) > 0 && openBreakers == len(registry) {
+			result.Status = "down"
+		}
+		registryMutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if result.Status == "down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(&result)
+	})
+}
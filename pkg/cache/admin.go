@@ -0,0 +1,85 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sync"
+)
+
+var (
+	registryMutex sync.Mutex
+	registry      = map[string]*Backend{}
+)
+
+func register(backend *Backend) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry[backend.Name()] = backend
+}
+
+// Invalidate removes the cached entries of the user from the cache of the
+// backend. An empty backend name selects all caches, an empty username all
+// entries. It reports whether a cache was found.
+func Invalidate(backendName string, username string) bool {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	found := false
+	for name, backend := range registry {
+		if backendName == "" || backendName == name {
+			backend.Invalidate(username)
+			found = true
+		}
+	}
+
+	return found
+}
+
+// Handler serves the invalidation for the admin interface. It accepts POST
+// requests with the optional parameters backend and user which carry the token
+// as bearer token in the Authorization header.
+func Handler(token string) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !Invalidate(r.FormValue("backend"), r.FormValue("user")) {
+			http.Error(w, "no cache found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,312 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samuel/go-ldap/ldap"
+	"sync"
+	"time"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "The total number of cache lookups",
+	}, []string{"backend", "cache", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal)
+}
+
+type wrapperFactory struct{}
+
+func NewWrapperFactory() pkg.WrapperFactory {
+	return &wrapperFactory{}
+}
+
+func (wrapperFactory) Name() string {
+	return "cache"
+}
+
+func (wrapperFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (wrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	cacheConfig, ok := config.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	cache := NewBackend(backend, cacheConfig)
+	register(cache)
+
+	return cache, nil
+}
+
+type Config struct {
+	BindTtl     util.Duration `json:"bindTtl"`
+	NegativeTtl util.Duration `json:"negativeTtl"`
+	SearchTtl   util.Duration `json:"searchTtl"`
+	MaxEntries  int           `json:"maxEntries"`
+}
+
+func (config *Config) maxEntries() int {
+	if config.MaxEntries <= 0 {
+		return 10000
+	}
+
+	return config.MaxEntries
+}
+
+// Backend caches the results of the delegate. Successful binds are cached
+// with a salted hash of the password, the usernames the delegate reports as
+// unknown (see pkg.ErrUnknownUser) in a separate negative cache. Wrong
+// passwords aren't cached. Searches are cached by base, scope and normalized
+// filter.
+type Backend struct {
+	delegate pkg.Backend
+	config   *Config
+	now      func() time.Time
+
+	mutex    sync.Mutex
+	binds    map[string]*bindEntry
+	unknown  map[string]time.Time
+	searches map[string]*searchEntry
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Wrapper = &Backend{}
+
+type bindEntry struct {
+	salt    []byte
+	hash    []byte
+	expires time.Time
+}
+
+type searchEntry struct {
+	users   []*pkg.User
+	expires time.Time
+}
+
+func NewBackend(delegate pkg.Backend, config *Config) *Backend {
+	return &Backend{
+		delegate: delegate,
+		config:   config,
+		now:      time.Now,
+		binds:    map[string]*bindEntry{},
+		unknown:  map[string]time.Time{},
+		searches: map[string]*searchEntry{},
+	}
+}
+
+func (backend *Backend) Name() string {
+	return backend.delegate.Name()
+}
+
+func (backend *Backend) Unwrap() pkg.Backend {
+	return backend.delegate
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	key := dn.Normalize(username)
+	now := backend.now()
+
+	backend.mutex.Lock()
+	if entry := backend.binds[key]; entry != nil && entry.matches(password, now) {
+		backend.mutex.Unlock()
+		backend.count("bind", "hit")
+		return true
+	}
+	if expires, ok := backend.unknown[key]; ok && now.Before(expires) {
+		backend.mutex.Unlock()
+		backend.count("negative", "hit")
+		return false
+	}
+	backend.mutex.Unlock()
+
+	backend.count("bind", "miss")
+
+	ok, err := backend.authenticate(ctx, username, password)
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	switch {
+	case ok:
+		backend.evict(len(backend.binds))
+		backend.binds[key] = newBindEntry(password, now.Add(backend.config.BindTtl.Or(time.Minute)))
+		delete(backend.unknown, key)
+	case err == pkg.ErrUnknownUser:
+		backend.evict(len(backend.unknown))
+		backend.unknown[key] = now.Add(backend.config.NegativeTtl.Or(time.Minute))
+	}
+
+	return ok
+}
+
+// authenticate asks the delegate, only delegates implementing
+// pkg.ErrorAuthenticator tell unknown users apart from wrong passwords
+func (backend *Backend) authenticate(ctx context.Context, username string, password string) (bool, error) {
+	if authenticator, ok := backend.delegate.(pkg.ErrorAuthenticator); ok {
+		return authenticator.AuthenticateErr(ctx, username, password)
+	}
+
+	return backend.delegate.Authenticate(ctx, username, password), nil
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	key := fmt.Sprintf("%s\x00%d\x00%s", dn.Normalize(pkg.SearchBase(ctx)), pkg.SearchScope(ctx), normalizeFilter(f))
+	now := backend.now()
+
+	backend.mutex.Lock()
+	entry := backend.searches[key]
+	backend.mutex.Unlock()
+
+	if entry != nil && now.Before(entry.expires) {
+		backend.count("search", "hit")
+		return copyUsers(entry.users), nil
+	}
+	backend.count("search", "miss")
+
+	users, err := backend.delegate.GetUsers(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	backend.mutex.Lock()
+	backend.evict(len(backend.searches))
+	backend.searches[key] = &searchEntry{
+		users:   copyUsers(users),
+		expires: now.Add(backend.config.SearchTtl.Or(30 * time.Second)),
+	}
+	backend.mutex.Unlock()
+
+	return users, nil
+}
+
+// Invalidate removes the cached binds of the user and all cached searches, as
+// the searches returning the user aren't known. An empty username removes all
+// cached binds.
+func (backend *Backend) Invalidate(username string) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.searches = map[string]*searchEntry{}
+
+	if username != "" {
+		delete(backend.binds, dn.Normalize(username))
+		delete(backend.unknown, dn.Normalize(username))
+		return
+	}
+
+	backend.binds = map[string]*bindEntry{}
+	backend.unknown = map[string]time.Time{}
+}
+
+// evict removes the expired entries if a cache reached the maximum size. The
+// caches are cleared if this isn't enough. The mutex must be held.
+func (backend *Backend) evict(size int) {
+	if size < backend.config.maxEntries() {
+		return
+	}
+
+	now := backend.now()
+	for key, entry := range backend.binds {
+		if !now.Before(entry.expires) {
+			delete(backend.binds, key)
+		}
+	}
+	for key, expires := range backend.unknown {
+		if !now.Before(expires) {
+			delete(backend.unknown, key)
+		}
+	}
+	for key, entry := range backend.searches {
+		if !now.Before(entry.expires) {
+			delete(backend.searches, key)
+		}
+	}
+
+	if len(backend.binds) >= backend.config.maxEntries() || len(backend.unknown) >= backend.config.maxEntries() || len(backend.searches) >= backend.config.maxEntries() {
+		log.Printf("cache: %s is full, clearing it", backend.Name())
+		backend.binds = map[string]*bindEntry{}
+		backend.unknown = map[string]time.Time{}
+		backend.searches = map[string]*searchEntry{}
+	}
+}
+
+func (backend *Backend) count(cache string, result string) {
+	requestsTotal.With(prometheus.Labels{"backend": backend.Name(), "cache": cache, "result": result}).Inc()
+}
+
+func newBindEntry(password string, expires time.Time) *bindEntry {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	return &bindEntry{
+		salt:    salt,
+		hash:    hashPassword(salt, password),
+		expires: expires,
+	}
+}
+
+func (entry *bindEntry) matches(password string, now time.Time) bool {
+	return now.Before(entry.expires) && subtle.ConstantTimeCompare(entry.hash, hashPassword(entry.salt, password)) == 1
+}
+
+func hashPassword(salt []byte, password string) []byte {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(password))
+
+	return hash.Sum(nil)
+}
+
+// copyUsers copies the users as other wrappers modify the returned users
+func copyUsers(users []*pkg.User) []*pkg.User {
+	result := make([]*pkg.User, len(users))
+	for i, user := range users {
+		attributes := make(map[string][]string, len(user.Attributes))
+		for name, values := range user.Attributes {
+			attributes[name] = append([]string{}, values...)
+		}
+
+		result[i] = &pkg.User{
+			DN:         user.DN,
+			Attributes: attributes,
+		}
+	}
+
+	return result
+}
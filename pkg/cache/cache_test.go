@@ -0,0 +1,204 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type countingBackend struct {
+	binds    int
+	searches int
+}
+
+func (countingBackend) Name() string {
+	return "counting"
+}
+
+func (backend *countingBackend) Authenticate(ctx context.Context, username string, password string) bool {
+	ok, _ := backend.AuthenticateErr(ctx, username, password)
+	return ok
+}
+
+func (backend *countingBackend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	backend.binds++
+	if username != "alice" {
+		return false, pkg.ErrUnknownUser
+	}
+	return password == "test123", nil
+}
+
+func (backend *countingBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.searches++
+	return []*pkg.User{{DN: "alice", Attributes: map[string][]string{"uid": {"alice"}}}}, nil
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given a cached backend", t, func() {
+		delegate := &countingBackend{}
+		now := time.Now()
+
+		backend := NewBackend(delegate, &Config{})
+		backend.now = func() time.Time { return now }
+
+		ctx := context.Background()
+
+		Convey("Then successful binds are cached", func() {
+			So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeTrue)
+			So(delegate.binds, ShouldEqual, 1)
+
+			Convey("But not for other passwords", func() {
+				So(backend.Authenticate(ctx, "alice", "wrong"), ShouldBeFalse)
+				So(delegate.binds, ShouldEqual, 2)
+			})
+
+			Convey("Until they expire", func() {
+				now = now.Add(2 * time.Minute)
+
+				So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeTrue)
+				So(delegate.binds, ShouldEqual, 2)
+			})
+
+			Convey("Until they are invalidated", func() {
+				backend.Invalidate("alice")
+
+				So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeTrue)
+				So(delegate.binds, ShouldEqual, 2)
+			})
+		})
+
+		Convey("Then unknown users are cached", func() {
+			So(backend.Authenticate(ctx, "bob", "test123"), ShouldBeFalse)
+			So(backend.Authenticate(ctx, "bob", "other"), ShouldBeFalse)
+			So(delegate.binds, ShouldEqual, 1)
+
+			Convey("Until they are invalidated", func() {
+				backend.Invalidate("bob")
+
+				So(backend.Authenticate(ctx, "bob", "test123"), ShouldBeFalse)
+				So(delegate.binds, ShouldEqual, 2)
+			})
+		})
+
+		Convey("Then wrong passwords aren't cached", func() {
+			So(backend.Authenticate(ctx, "alice", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(ctx, "alice", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeTrue)
+			So(delegate.binds, ShouldEqual, 3)
+		})
+
+		Convey("Then searches are cached by normalized filter", func() {
+			f1, _ := ldap.ParseFilter("(&(uid=alice)(objectClass=person))")
+			f2, _ := ldap.ParseFilter("(&(objectclass=person)(uid=alice))")
+
+			users, err := backend.GetUsers(ctx, f1)
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+
+			users[0].DN = "modified"
+
+			users, err = backend.GetUsers(ctx, f2)
+			So(err, ShouldBeNil)
+			So(users[0].DN, ShouldEqual, "alice")
+			So(delegate.searches, ShouldEqual, 1)
+
+			Convey("Until they expire", func() {
+				now = now.Add(time.Minute)
+
+				backend.GetUsers(ctx, f1)
+				So(delegate.searches, ShouldEqual, 2)
+			})
+
+			Convey("Until a user is invalidated", func() {
+				backend.Invalidate("alice")
+
+				backend.GetUsers(ctx, f1)
+				So(delegate.searches, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestHandler(t *testing.T) {
+	Convey("Given a registered cache", t, func() {
+		delegate := &countingBackend{}
+		backend, err := NewWrapperFactory().Wrap(delegate, &Config{})
+		So(err, ShouldBeNil)
+
+		backend.Authenticate(context.Background(), "alice", "test123")
+
+		Convey("When the cache is invalidated", func() {
+			rec := httptest.NewRecorder()
+			Handler("secret").ServeHTTP(rec, request(http.MethodPost, "/cache/invalidate?backend=counting", "secret"))
+
+			Convey("Then the next bind is delegated", func() {
+				So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+				backend.Authenticate(context.Background(), "alice", "test123")
+				So(delegate.binds, ShouldEqual, 2)
+			})
+		})
+
+		Convey("Then unknown backends are reported", func() {
+			rec := httptest.NewRecorder()
+			Handler("secret").ServeHTTP(rec, request(http.MethodPost, "/cache/invalidate?backend=unknown", "secret"))
+
+			So(rec.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("Then requests without the token are unauthorized", func() {
+			for _, token := range []string{"", "wrong"} {
+				rec := httptest.NewRecorder()
+				Handler("secret").ServeHTTP(rec, request(http.MethodPost, "/cache/invalidate", token))
+
+				So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+			}
+
+			rec := httptest.NewRecorder()
+			Handler("").ServeHTTP(rec, request(http.MethodPost, "/cache/invalidate", ""))
+			So(rec.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Then only POST is allowed", func() {
+			rec := httptest.NewRecorder()
+			Handler("secret").ServeHTTP(rec, request(http.MethodGet, "/cache/invalidate", "secret"))
+
+			So(rec.Code, ShouldEqual, http.StatusMethodNotAllowed)
+		})
+	})
+}
+
+func request(method string, target string, token string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return r
+}
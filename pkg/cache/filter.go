@@ -0,0 +1,73 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"fmt"
+	"github.com/samuel/go-ldap/ldap"
+	"sort"
+	"strings"
+)
+
+// normalizeFilter returns a canonical string of the filter. Attribute names
+// are lower cased and the elements of and and or filters are sorted, so
+// equivalent filters share a cache entry.
+func normalizeFilter(f ldap.Filter) string {
+	switch f := f.(type) {
+	case nil:
+		return ""
+	case *ldap.AND:
+		return "(&" + normalizeFilters(f.Filters) + ")"
+	case *ldap.OR:
+		return "(|" + normalizeFilters(f.Filters) + ")"
+	case *ldap.NOT:
+		return "(!" + normalizeFilter(f.Filter) + ")"
+	case *ldap.EqualityMatch:
+		return fmt.Sprintf("(%s=%q)", strings.ToLower(f.Attribute), f.Value)
+	case *ldap.ApproxMatch:
+		return fmt.Sprintf("(%s~=%q)", strings.ToLower(f.Attribute), f.Value)
+	case *ldap.GreaterOrEqual:
+		return fmt.Sprintf("(%s>=%q)", strings.ToLower(f.Attribute), f.Value)
+	case *ldap.LessOrEqual:
+		return fmt.Sprintf("(%s<=%q)", strings.ToLower(f.Attribute), f.Value)
+	case *ldap.Substrings:
+		return fmt.Sprintf("(%s=%q*%q*%q)", strings.ToLower(f.Attribute), f.Initial, f.Any, f.Final)
+	case *ldap.Present:
+		return fmt.Sprintf("(%s=*)", strings.ToLower(f.Attribute))
+	default:
+		return fmt.Sprintf("(%T%v)", f, f)
+	}
+}
+
+func normalizeFilters(filters []ldap.Filter) string {
+	normalized := make([]string, 0, len(filters))
+	seen := map[string]bool{}
+	for _, f := range filters {
+		n := normalizeFilter(f)
+		if !seen[n] {
+			seen[n] = true
+			normalized = append(normalized, n)
+		}
+	}
+	sort.Strings(normalized)
+
+	return strings.Join(normalized, "")
+}
@@ -0,0 +1,52 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cache
+
+import (
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestNormalizeFilter(t *testing.T) {
+	Convey("Given equivalent filters", t, func() {
+		a, _ := ldap.ParseFilter("(&(uid=alice)(objectClass=person)(uid=alice))")
+		b, _ := ldap.ParseFilter("(&(objectclass=person)(UID=alice))")
+
+		Convey("Then they are normalized to the same string", func() {
+			So(normalizeFilter(a), ShouldEqual, normalizeFilter(b))
+			So(normalizeFilter(a), ShouldEqual, `(&(objectclass="person")(uid="alice"))`)
+		})
+	})
+
+	Convey("Given different filters", t, func() {
+		a, _ := ldap.ParseFilter("(|(uid=alice)(uid=bob))")
+		b, _ := ldap.ParseFilter("(&(uid=alice)(uid=bob))")
+		c, _ := ldap.ParseFilter("(uid=Alice)")
+		d, _ := ldap.ParseFilter("(uid=alice)")
+
+		Convey("Then they are normalized to different strings", func() {
+			So(normalizeFilter(a), ShouldNotEqual, normalizeFilter(b))
+			So(normalizeFilter(c), ShouldNotEqual, normalizeFilter(d))
+			So(normalizeFilter(nil), ShouldBeEmpty)
+		})
+	})
+}
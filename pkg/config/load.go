@@ -22,16 +22,32 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/gopenguin/ldap-proxy/pkg"
 	"github.com/gopenguin/ldap-proxy/pkg/log"
-	"github.com/gopenguin/ldap-proxy/pkg/stripper"
+	"github.com/gopenguin/ldap-proxy/pkg/rewrite"
 	"io"
 )
 
 type Loader struct {
 	factories map[string]pkg.BackendFactory
+	wrappers  []pkg.WrapperFactory
 }
 
+// legacyConfig is the configuration of the former stripper which rewrites the
+// dns with a single rule
+type legacyConfig struct {
+	BaseDn           *string `json:"baseDn"`
+	PeopleRdn        *string `json:"peopleRdn"`
+	UserRdnAttribute *string `json:"userRdnAttribute"`
+
+	Rewrite *json.RawMessage `json:"rewrite"`
+}
+
+var (
+	errRewriteAndStripper = errors.New("config: the rewrite rules can't be combined with baseDn, peopleRdn and userRdnAttribute")
+)
+
 type typedConfig struct {
 	Kind string `json:"kind"`
 }
@@ -50,6 +66,14 @@ func (loader *Loader) AddFactory(factory pkg.BackendFactory) {
 	loader.factories[factory.Name()] = factory
 }
 
+// AddWrapper registers a wrapper. The wrappers are applied in the order of
+// registration, the first one is the innermost.
+func (loader *Loader) AddWrapper(wrapper pkg.WrapperFactory) {
+	log.Printf("Adding backend wrapper %s", wrapper.Name())
+
+	loader.wrappers = append(loader.wrappers, wrapper)
+}
+
 func (loader *Loader) Load(reader io.Reader) (backends []pkg.Backend, err error) {
 	var rawConfigs []*json.RawMessage
 
@@ -90,22 +114,78 @@ func (loader *Loader) instantiateBackend(data json.RawMessage) (backend pkg.Back
 		return nil, err
 	}
 
-	stripperConfig := &stripper.Config{}
-	json.Unmarshal(data, stripperConfig)
-	if stripperConfig.BaseDn != nil || stripperConfig.PeopleRdn != nil || stripperConfig.UserRdnAttribute != nil {
-		if stripperConfig.BaseDn == nil || stripperConfig.PeopleRdn == nil || stripperConfig.UserRdnAttribute == nil {
-			stripperConfig = nil
+	log.Printf("Instantiated %s backend '%s'", factory.Name(), backend.Name())
+
+	legacyConfig := &legacyConfig{}
+	json.Unmarshal(data, legacyConfig)
+	if legacyConfig.BaseDn != nil || legacyConfig.PeopleRdn != nil || legacyConfig.UserRdnAttribute != nil {
+		if legacyConfig.BaseDn == nil || legacyConfig.PeopleRdn == nil || legacyConfig.UserRdnAttribute == nil {
+			legacyConfig = nil
 			log.Printf("Incomplete stripper config found in backend '%s' IGNORED", backend.Name())
 		}
 	} else {
-		stripperConfig = nil
+		legacyConfig = nil
 	}
 
-	log.Printf("Instantiated %s backend '%s'", factory.Name(), backend.Name())
+	if legacyConfig != nil && legacyConfig.Rewrite != nil {
+		return nil, errRewriteAndStripper
+	}
+
+	return loader.wrap(backend, data, legacyConfig)
+}
 
-	if stripperConfig != nil {
-		backend = stripper.NewBackend(backend, stripperConfig)
-		log.Printf("Wrapping backend '%s' with stripper ('%s', '%s', '%s')", backend.Name(), *stripperConfig.UserRdnAttribute, *stripperConfig.PeopleRdn, *stripperConfig.BaseDn)
+// wrap applies the configured wrappers. The stripper is applied in place of
+// the rewrite wrapper or last if no rewrite wrapper is registered.
+func (loader *Loader) wrap(backend pkg.Backend, data json.RawMessage, stripper *legacyConfig) (pkg.Backend, error) {
+	var sections map[string]json.RawMessage
+	err := json.Unmarshal(data, &sections)
+	if err != nil {
+		return nil, err
 	}
+
+	for _, wrapper := range loader.wrappers {
+		if wrapper.Name() == "rewrite" && stripper != nil {
+			backend, err = stripper.wrap(backend)
+			if err != nil {
+				return nil, err
+			}
+
+			stripper = nil
+			continue
+		}
+
+		section, ok := sections[wrapper.Name()]
+		if !ok {
+			continue
+		}
+
+		wrapperConfig := wrapper.NewConfig()
+		err = json.Unmarshal(section, wrapperConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		backend, err = wrapper.Wrap(backend, wrapperConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Printf("Wrapping backend '%s' with %s", backend.Name(), wrapper.Name())
+	}
+
+	if stripper != nil {
+		return stripper.wrap(backend)
+	}
+
+	return backend, nil
+}
+
+func (config *legacyConfig) wrap(backend pkg.Backend) (pkg.Backend, error) {
+	backend, err := rewrite.NewBackend(backend, rewrite.LegacyConfig(*config.BaseDn, *config.PeopleRdn, *config.UserRdnAttribute))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Wrapping backend '%s' with stripper ('%s', '%s', '%s')", backend.Name(), *config.UserRdnAttribute, *config.PeopleRdn, *config.BaseDn)
 	return backend, nil
 }
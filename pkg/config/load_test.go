@@ -25,6 +25,7 @@ import (
 	"context"
 	"errors"
 	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/rewrite"
 	"github.com/samuel/go-ldap/ldap"
 	. "github.com/smartystreets/goconvey/convey"
 	"io"
@@ -69,6 +70,27 @@ func (testBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, er
 	return []*pkg.User{}, nil
 }
 
+type testWrapperFactory struct{}
+
+func (testWrapperFactory) Name() (name string) {
+	return "testWrapper"
+}
+
+func (testWrapperFactory) NewConfig() (config interface{}) {
+	return &testConfig{}
+}
+
+func (testWrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	if config.(*testConfig).TestValue == "fail" {
+		return nil, errors.New("config: test error")
+	}
+	return &testWrapper{Backend: backend}, nil
+}
+
+type testWrapper struct {
+	pkg.Backend
+}
+
 func TestNewLoader(t *testing.T) {
 	Convey("Given a loader", t, func() {
 		loader := NewLoader()
@@ -115,6 +137,29 @@ func TestLoader_Load(t *testing.T) {
 			})
 		})
 
+		Convey("When there is a wrapper config", func() {
+			loader.AddWrapper(&testWrapperFactory{})
+
+			backends, err := loader.Load(toReader(`[{"kind": "test", "value": "testValue", "testWrapper": {"value": "wrapped"}}, {"kind": "test"}]`))
+
+			Convey("Then only the configured backend is wrapped", func() {
+				So(err, ShouldBeNil)
+				So(backends, ShouldHaveLength, 2)
+				So(backends[0], ShouldHaveSameTypeAs, &testWrapper{})
+				So(backends[1], ShouldHaveSameTypeAs, &testBackend{})
+			})
+		})
+
+		Convey("When the wrapper config is invalid", func() {
+			loader.AddWrapper(&testWrapperFactory{})
+
+			_, err := loader.Load(toReader(`[{"kind": "test", "testWrapper": {"value": "fail"}}]`))
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
 		Convey("When there is a partial stripper config", func() {
 			backends, err := loader.Load(toReader(`[{"kind": "test", "value": "testValue", "baseDn": "dc=example,dc=com"}]`))
 
@@ -134,6 +179,28 @@ func TestLoader_Load(t *testing.T) {
 				So(backends[0], ShouldNotHaveSameTypeAs, &testBackend{})
 			})
 		})
+
+		Convey("When there is a stripper config and wrappers around the rewrite wrapper", func() {
+			loader.AddWrapper(rewrite.NewWrapperFactory())
+			loader.AddWrapper(&testWrapperFactory{})
+
+			backends, err := loader.Load(toReader(`[{"kind": "test", "baseDn": "dc=example,dc=com", "peopleRdn": "ou=People", "userRdnAttribute": "uid", "testWrapper": {}}]`))
+
+			Convey("Then the stripper is applied in place of the rewrite wrapper", func() {
+				So(err, ShouldBeNil)
+				So(backends, ShouldHaveLength, 1)
+				So(backends[0], ShouldHaveSameTypeAs, &testWrapper{})
+				So(backends[0].(*testWrapper).Backend, ShouldHaveSameTypeAs, &rewrite.Backend{})
+			})
+		})
+
+		Convey("When there is a stripper config and rewrite rules", func() {
+			_, err := loader.Load(toReader(`[{"kind": "test", "baseDn": "dc=example,dc=com", "peopleRdn": "ou=People", "userRdnAttribute": "uid", "rewrite": {}}]`))
+
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldEqual, errRewriteAndStripper)
+			})
+		})
 	})
 }
 
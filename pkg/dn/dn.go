@@ -0,0 +1,369 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package dn parses, formats and compares distinguished names as described in
+// RFC 4514.
+package dn
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"sort"
+	"strings"
+)
+
+var (
+	errInvalidType  = errors.New("dn: invalid attribute type")
+	errMissingValue = errors.New("dn: attribute type without value")
+	errInvalidHex   = errors.New("dn: invalid hex value")
+	errInvalidEnd   = errors.New("dn: unexpected end of value")
+)
+
+// An AttributeTypeAndValue is a single assertion of a rdn like uid=alice. The
+// value is unescaped.
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// A RDN consists of one or more attribute values, e. g. cn=Alice+sn=Smith.
+type RDN []AttributeTypeAndValue
+
+// A DN is the sequence of rdns starting with the leaf. The empty dn is the
+// root of the directory.
+type DN []RDN
+
+// Parse reads the string representation of a dn. Spaces around the separators
+// are ignored.
+func Parse(s string) (DN, error) {
+	dn := DN{}
+	if strings.TrimSpace(s) == "" {
+		return dn, nil
+	}
+
+	p := &parser{s: s}
+	for {
+		rdn, err := p.rdn()
+		if err != nil {
+			return nil, fmt.Errorf("%v in %q", err, s)
+		}
+		dn = append(dn, rdn)
+
+		if p.eof() {
+			return dn, nil
+		}
+		p.pos++ // the comma
+	}
+}
+
+// String formats the dn with the types as given and escaped values.
+func (dn DN) String() string {
+	rdns := make([]string, len(dn))
+	for i, rdn := range dn {
+		rdns[i] = rdn.String()
+	}
+
+	return strings.Join(rdns, ",")
+}
+
+func (rdn RDN) String() string {
+	values := make([]string, len(rdn))
+	for i, ava := range rdn {
+		values[i] = ava.Type + "=" + EscapeValue(ava.Value)
+	}
+
+	return strings.Join(values, "+")
+}
+
+// Normalize returns a copy of the dn with lower case attribute names and the
+// values normalized by the matching rule of their attribute type. The values
+// of multi-valued rdns are sorted.
+func (dn DN) Normalize() DN {
+	normalized := make(DN, len(dn))
+	for i, rdn := range dn {
+		normalized[i] = make(RDN, len(rdn))
+		for j, ava := range rdn {
+			attributeType := NormalizeType(ava.Type)
+			normalized[i][j] = AttributeTypeAndValue{
+				Type:  attributeType,
+				Value: schema.Default.Normalize(attributeType, ava.Value),
+			}
+		}
+
+		sort.Slice(normalized[i], func(a, b int) bool {
+			x, y := normalized[i][a], normalized[i][b]
+			return x.Type < y.Type || x.Type == y.Type && x.Value < y.Value
+		})
+	}
+
+	return normalized
+}
+
+// Equal compares the normalized dns.
+func (dn DN) Equal(other DN) bool {
+	return len(dn) == len(other) && dn.Normalize().String() == other.Normalize().String()
+}
+
+// IsWithin reports whether the dn equals the base or is located below it.
+func (dn DN) IsWithin(base DN) bool {
+	if len(base) > len(dn) {
+		return false
+	}
+
+	return dn[len(dn)-len(base):].Equal(base)
+}
+
+// Parent returns the dn without its first rdn. The root has no parent.
+func (dn DN) Parent() DN {
+	if len(dn) == 0 {
+		return nil
+	}
+
+	return dn[1:]
+}
+
+// Normalize returns the normalized string representation of the dn. Strings
+// which aren't valid dns are returned unchanged.
+func Normalize(s string) string {
+	dn, err := Parse(s)
+	if err != nil {
+		return s
+	}
+
+	return dn.Normalize().String()
+}
+
+// Equal reports whether both strings denote the same dn. Strings which aren't
+// valid dns must be equal.
+func Equal(a, b string) bool {
+	return Normalize(a) == Normalize(b)
+}
+
+// IsWithin reports whether the dn equals the base dn or is located below it.
+// Empty or invalid dns are never within another one.
+func IsWithin(s, base string) bool {
+	dn, err := Parse(s)
+	if err != nil || len(dn) == 0 {
+		return false
+	}
+
+	baseDn, err := Parse(base)
+	if err != nil || len(baseDn) == 0 {
+		return false
+	}
+
+	return dn.IsWithin(baseDn)
+}
+
+// EscapeValue escapes the special characters of a value so it can be used
+// inside a dn.
+func EscapeValue(value string) string {
+	var escaped strings.Builder
+	for i, r := range value {
+		switch {
+		case r == 0:
+			escaped.WriteString(`\00`)
+			continue
+		case strings.ContainsRune(",+\"\\<>;=", r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+
+	return escaped.String()
+}
+
+// UnescapeValue removes the escaping of a value including hex pairs.
+func UnescapeValue(value string) (string, error) {
+	p := &parser{s: value}
+
+	unescaped, err := p.stringValue()
+	if err == nil && !p.eof() {
+		unescaped, err = "", fmt.Errorf("%v: %q", errInvalidEnd, value)
+	}
+
+	return unescaped, err
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) eof() bool {
+	return p.pos == len(p.s)
+}
+
+func (p *parser) skipSpaces() {
+	for !p.eof() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *parser) rdn() (RDN, error) {
+	rdn := RDN{}
+	for {
+		ava, err := p.attributeTypeAndValue()
+		if err != nil {
+			return nil, err
+		}
+		rdn = append(rdn, ava)
+
+		if p.eof() || p.s[p.pos] == ',' {
+			return rdn, nil
+		}
+		p.pos++ // the plus
+	}
+}
+
+func (p *parser) attributeTypeAndValue() (ava AttributeTypeAndValue, err error) {
+	p.skipSpaces()
+
+	start := p.pos
+	for !p.eof() && isTypeChar(p.s[p.pos]) {
+		p.pos++
+	}
+	ava.Type = p.s[start:p.pos]
+	if !isType(ava.Type) {
+		return ava, fmt.Errorf("%v %q", errInvalidType, ava.Type)
+	}
+
+	p.skipSpaces()
+	if p.eof() || p.s[p.pos] != '=' {
+		return ava, fmt.Errorf("%v: %s", errMissingValue, ava.Type)
+	}
+	p.pos++
+	p.skipSpaces()
+
+	if !p.eof() && p.s[p.pos] == '#' {
+		ava.Value, err = p.hexValue()
+	} else {
+		ava.Value, err = p.stringValue()
+	}
+
+	return ava, err
+}
+
+// stringValue reads the value up to the next unescaped separator. Unescaped
+// trailing spaces are removed.
+func (p *parser) stringValue() (string, error) {
+	value := []byte{}
+	end := 0
+
+	for ; !p.eof(); p.pos++ {
+		c := p.s[p.pos]
+		switch {
+		case c == ',' || c == '+':
+			return string(value[:end]), nil
+		case c == '\\':
+			b, err := p.escaped()
+			if err != nil {
+				return "", err
+			}
+			value = append(value, b)
+			end = len(value)
+		default:
+			value = append(value, c)
+			if c != ' ' {
+				end = len(value)
+			}
+		}
+	}
+
+	return string(value[:end]), nil
+}
+
+// escaped reads an escaped character or hex pair after the backslash
+func (p *parser) escaped() (byte, error) {
+	p.pos++
+	if p.eof() {
+		return 0, errInvalidEnd
+	}
+
+	if p.pos+1 < len(p.s) && isHex(p.s[p.pos]) && isHex(p.s[p.pos+1]) {
+		b, _ := hex.DecodeString(p.s[p.pos : p.pos+2])
+		p.pos++
+		return b[0], nil
+	}
+
+	return p.s[p.pos], nil
+}
+
+// hexValue reads the hex encoded ber value after the number sign. Ber encoded
+// strings are decoded, other values are kept as bytes.
+func (p *parser) hexValue() (string, error) {
+	p.pos++ // the number sign
+
+	start := p.pos
+	for !p.eof() && isHex(p.s[p.pos]) {
+		p.pos++
+	}
+	encoded := p.s[start:p.pos]
+
+	p.skipSpaces()
+	if !p.eof() && p.s[p.pos] != ',' && p.s[p.pos] != '+' {
+		return "", errInvalidHex
+	}
+
+	ber, err := hex.DecodeString(encoded)
+	if err != nil || len(ber) == 0 {
+		return "", errInvalidHex
+	}
+
+	// octet, utf8, printable, t61, ia5 and bmp strings with a short length
+	switch ber[0] {
+	case 0x04, 0x0c, 0x13, 0x14, 0x16, 0x1e:
+		if len(ber) >= 2 && int(ber[1]) == len(ber)-2 && ber[1] < 0x80 {
+			return string(ber[2:]), nil
+		}
+	}
+
+	return string(ber), nil
+}
+
+func isTypeChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.'
+}
+
+// isType checks for a descriptor like cn or a numeric oid like 2.5.4.3
+func isType(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	if '0' <= s[0] && s[0] <= '9' {
+		for _, number := range strings.Split(s, ".") {
+			if number == "" || strings.Trim(number, "0123456789") != "" {
+				return false
+			}
+		}
+		return true
+	}
+
+	return !strings.Contains(s, ".") || strings.HasPrefix(strings.ToLower(s), "oid.") && isType(s[4:])
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
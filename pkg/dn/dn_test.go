@@ -0,0 +1,118 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dn
+
+import (
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	Convey("Dns are parsed into rdns", t, func() {
+		dn, err := Parse("uid=alice,ou=People,dc=example,dc=com")
+		So(err, ShouldBeNil)
+		So(dn, ShouldHaveLength, 4)
+		So(dn[0], ShouldResemble, RDN{{Type: "uid", Value: "alice"}})
+		So(dn.Parent().String(), ShouldEqual, "ou=People,dc=example,dc=com")
+	})
+
+	Convey("Multi-valued rdns are supported", t, func() {
+		dn, err := Parse("cn=Alice+sn=Smith, dc=example")
+		So(err, ShouldBeNil)
+		So(dn[0], ShouldResemble, RDN{{Type: "cn", Value: "Alice"}, {Type: "sn", Value: "Smith"}})
+	})
+
+	Convey("Escaped characters and hex pairs are unescaped", t, func() {
+		dn, err := Parse(`cn=Smith\, Bob\2b\C3\A4,cn=\#1\ ,cn=#0c03616263, o = a b  `)
+		So(err, ShouldBeNil)
+		So(dn[0][0].Value, ShouldEqual, "Smith, Bob+ä")
+		So(dn[1][0].Value, ShouldEqual, "#1 ")
+		So(dn[2][0].Value, ShouldEqual, "abc")
+		So(dn[3][0].Value, ShouldEqual, "a b")
+		So(dn.String(), ShouldEqual, `cn=Smith\, Bob\+ä,cn=\#1\ ,cn=abc,o=a b`)
+	})
+
+	Convey("The empty string is the root", t, func() {
+		dn, err := Parse(" ")
+		So(err, ShouldBeNil)
+		So(dn, ShouldBeEmpty)
+		So(dn.Parent(), ShouldBeNil)
+	})
+
+	Convey("Invalid dns are rejected", t, func() {
+		for _, invalid := range []string{"alice", "=alice", "uid=alice,", "1.a=x", "cn=#zz", "cn=#0c01 x", `cn=a\`} {
+			_, err := Parse(invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestNormalize(t *testing.T) {
+	Convey("Types and values are normalized by their matching rule", t, func() {
+		So(Normalize("UID=Alice, ou=People,dc=x"), ShouldEqual, "uid=alice,ou=people,dc=x")
+		So(Normalize("2.5.4.3=Alice  Smith ,OID.0.9.2342.19200300.100.1.25=X"), ShouldEqual, "cn=alice smith,dc=x")
+		So(Normalize(`uidNumber=0042+telephoneNumber=\+1 555-0100`), ShouldEqual, `telephonenumber=\+15550100+uidnumber=42`)
+		So(Normalize("not a dn"), ShouldEqual, "not a dn")
+	})
+
+	Convey("Dn valued attributes are compared like dns", t, func() {
+		So(schema.Default.Equal("member", "UID=Alice, ou=People,dc=x", "uid=alice,ou=people,dc=x"), ShouldBeTrue)
+		So(schema.Default.Equal("member", "uid=alice,dc=x", "uid=alice,dc=y"), ShouldBeFalse)
+		So(schema.Default.Equal("member", "Staff", "staff"), ShouldBeTrue)
+	})
+
+	Convey("Dns are compared by their normalized form", t, func() {
+		So(Equal("UID=Alice, ou=People,dc=x", "uid=alice,ou=people,dc=x"), ShouldBeTrue)
+		So(Equal("cn=a+sn=b,dc=x", "sn=B+cn=A,dc=x"), ShouldBeTrue)
+		So(Equal(`cn=a\2c b,dc=x`, `cn=a\, b,dc=x`), ShouldBeTrue)
+		So(Equal("cn=a,dc=x", "cn=a,dc=y"), ShouldBeFalse)
+		So(Equal("alice", "Alice"), ShouldBeFalse)
+	})
+}
+
+func TestIsWithin(t *testing.T) {
+	Convey("Dns are compared case insensitive and ignore spaces between rdns", t, func() {
+		So(IsWithin("uid=Alice, ou=People, DC=example,dc=org", "dc=example,dc=org"), ShouldBeTrue)
+		So(IsWithin("dc=example,dc=org", "dc=example,dc=org"), ShouldBeTrue)
+		So(IsWithin("uid=alice,dc=otherexample,dc=org", "dc=example,dc=org"), ShouldBeFalse)
+		So(IsWithin(`uid=a\,dc=example,dc=org`, "dc=example,dc=org"), ShouldBeFalse)
+		So(IsWithin("dc=org", "dc=example,dc=org"), ShouldBeFalse)
+		So(IsWithin("", "dc=example,dc=org"), ShouldBeFalse)
+	})
+}
+
+func TestEscapeValue(t *testing.T) {
+	Convey("Dn values are escaped", t, func() {
+		So(EscapeValue("Doe, John"), ShouldEqual, `Doe\, John`)
+		So(EscapeValue("#a+b "), ShouldEqual, `\#a\+b\ `)
+		So(EscapeValue("a\x00b"), ShouldEqual, `a\00b`)
+	})
+
+	Convey("Dn values are unescaped", t, func() {
+		value, err := UnescapeValue(`Doe\, John\2c`)
+		So(err, ShouldBeNil)
+		So(value, ShouldEqual, "Doe, John,")
+
+		_, err = UnescapeValue("a,b")
+		So(err, ShouldNotBeNil)
+	})
+}
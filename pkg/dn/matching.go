@@ -0,0 +1,51 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package dn
+
+import (
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"strings"
+)
+
+func init() {
+	schema.Default.AddMatchingRule(schema.MatchingRule{
+		Name:      "distinguishedNameMatch",
+		Normalize: distinguishedNameMatch,
+	})
+}
+
+// NormalizeType returns the lower case name of the attribute type, oids and
+// aliases are replaced by the name defined in the schema.
+func NormalizeType(attributeType string) string {
+	return schema.Default.Key(attributeType)
+}
+
+// distinguishedNameMatch compares dns by their normalized form. Backends often
+// use plain names like group names instead of dns, these are compared case
+// insensitive.
+func distinguishedNameMatch(value string) (string, bool) {
+	dn, err := Parse(value)
+	if err != nil {
+		return strings.ToLower(strings.Join(strings.Fields(value), " ")), true
+	}
+
+	return dn.Normalize().String(), true
+}
@@ -0,0 +1,254 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package file
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	errNoFile = errors.New("file: no file configured")
+	errNoName = errors.New("file: user without name")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "file"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	File           string `json:"file"`
+	GroupAttribute string `json:"groupAttribute"`
+}
+
+func (config *Config) groupAttribute() string {
+	if config.GroupAttribute == "" {
+		return "memberOf"
+	}
+
+	return config.GroupAttribute
+}
+
+// The content of a users file
+type usersFile struct {
+	Users []fileUser `json:"users" yaml:"users"`
+}
+
+type fileUser struct {
+	Name       string                 `json:"name" yaml:"name"`
+	Password   string                 `json:"password" yaml:"password"`
+	Attributes map[string]util.Values `json:"attributes" yaml:"attributes"`
+	Groups     []string               `json:"groups" yaml:"groups"`
+}
+
+type entry struct {
+	password string
+	user     *pkg.User
+}
+
+// The users of a single version of the file
+type directory struct {
+	entries []*entry
+	byName  map[string]*entry
+}
+
+// Backend serves the users of a separate YAML or JSON file. The file is read
+// again as soon as it changes. An invalid file is rejected and the last valid
+// users are served.
+type Backend struct {
+	config    *Config
+	watcher   *util.FileWatcher
+	directory atomic.Value
+}
+
+var _ pkg.Backend = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if config.File == "" {
+		return nil, errNoFile
+	}
+
+	backend := &Backend{
+		config:  config,
+		watcher: util.NewFileWatcher(config.File),
+	}
+
+	_, err := backend.watcher.Changed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	e, ok := backend.current().byName[username]
+	if !ok {
+		return false
+	}
+
+	return util.VerifyPasswordCtx(ctx, e.password, password)
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+
+	for _, e := range backend.current().entries {
+		if f == nil || util.MatchFilter(f, e.user.Attributes) {
+			// copy the user as wrapping backends modify the result
+			users = append(users, e.user.Copy())
+		}
+	}
+
+	return users, nil
+}
+
+// current reloads the file if it changed and returns the users
+func (backend *Backend) current() *directory {
+	changed, err := backend.watcher.Changed()
+	if err != nil {
+		log.Printf("file: %v", err)
+	} else if changed {
+		err = backend.load()
+		if err != nil {
+			log.Printf("file: keeping previous users: %v", err)
+		}
+	}
+
+	return backend.directory.Load().(*directory)
+}
+
+func (backend *Backend) load() error {
+	data, err := ioutil.ReadFile(backend.config.File)
+	if err != nil {
+		return err
+	}
+
+	content := usersFile{}
+	if strings.ToLower(filepath.Ext(backend.config.File)) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&content)
+	} else {
+		err = yaml.UnmarshalStrict(data, &content)
+	}
+	if err != nil {
+		return err
+	}
+
+	dir, err := backend.newDirectory(content.Users)
+	if err != nil {
+		return err
+	}
+
+	backend.directory.Store(dir)
+
+	log.Debugf("file: loaded %d users from %s", len(dir.entries), backend.config.File)
+
+	return nil
+}
+
+func (backend *Backend) newDirectory(users []fileUser) (*directory, error) {
+	dir := &directory{
+		byName: make(map[string]*entry),
+	}
+
+	for _, u := range users {
+		if u.Name == "" {
+			return nil, errNoName
+		}
+		if _, ok := dir.byName[u.Name]; ok {
+			return nil, fmt.Errorf("file: duplicate user %s", u.Name)
+		}
+
+		attributes := map[string][]string{
+			"cn":  {u.Name},
+			"uid": {u.Name},
+		}
+		for attribute, values := range u.Attributes {
+			attributes[attribute] = values
+		}
+		if len(u.Groups) > 0 {
+			attributes[backend.config.groupAttribute()] = u.Groups
+		}
+
+		e := &entry{
+			password: u.Password,
+			user: &pkg.User{
+				DN:         u.Name,
+				Attributes: attributes,
+			},
+		}
+
+		dir.entries = append(dir.entries, e)
+		dir.byName[u.Name] = e
+	}
+
+	return dir, nil
+}
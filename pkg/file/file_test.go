@@ -0,0 +1,177 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package file
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const usersYaml = `
+users:
+  - name: alice
+    password: $2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny
+    attributes:
+      mail:
+        - alice@example.com
+        - a.smith@example.com
+      displayName: Alice Smith
+      uidNumber: 1000
+    groups:
+      - admins
+      - developers
+  - name: bob
+    password: $2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny
+`
+
+const usersJson = `{
+  "users": [
+    {
+      "name": "carol",
+      "password": "$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny",
+      "attributes": {"mail": "carol@example.com"},
+      "groups": ["developers"]
+    }
+  ]
+}`
+
+func TestBackend(t *testing.T) {
+	dir, cleanup := util.TmpDir(t)
+	defer cleanup()
+
+	write := func(file string, content string, modTime time.Time) {
+		So(ioutil.WriteFile(file, []byte(content), 0600), ShouldBeNil)
+		So(os.Chtimes(file, modTime, modTime), ShouldBeNil)
+	}
+
+	Convey("Given a yaml users file", t, func() {
+		file := filepath.Join(dir, "users.yaml")
+		write(file, usersYaml, time.Now().Add(-time.Hour))
+
+		backend, err := NewBackend(&Config{File: file})
+		So(err, ShouldBeNil)
+
+		Convey("Then the users are able to authenticate", func() {
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "alice", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "dave", "test123"), ShouldBeFalse)
+		})
+
+		Convey("Then the users carry their attributes and groups", func() {
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 2)
+			So(users[0].DN, ShouldEqual, "alice")
+			So(users[0].Attributes["uid"], ShouldResemble, []string{"alice"})
+			So(users[0].Attributes["mail"], ShouldResemble, []string{"alice@example.com", "a.smith@example.com"})
+			So(users[0].Attributes["displayName"], ShouldResemble, []string{"Alice Smith"})
+			So(users[0].Attributes["uidNumber"], ShouldResemble, []string{"1000"})
+			So(users[0].Attributes["memberOf"], ShouldResemble, []string{"admins", "developers"})
+		})
+
+		Convey("Then the users are filtered by any attribute", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.AND{
+				Filters: []ldap.Filter{
+					&ldap.EqualityMatch{Attribute: "memberOf", Value: []byte("admins")},
+					&ldap.EqualityMatch{Attribute: "mail", Value: []byte("a.smith@example.com")},
+				},
+			})
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "alice")
+		})
+
+		Convey("Then modifying the result doesn't change the users", func() {
+			f := &ldap.EqualityMatch{Attribute: "mail", Value: []byte("a.smith@example.com")}
+			users, err := backend.GetUsers(context.Background(), f)
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			users[0].Attributes["memberOf"][0] = "root"
+
+			users, err = backend.GetUsers(context.Background(), f)
+			So(err, ShouldBeNil)
+			So(users[0].Attributes["memberOf"], ShouldResemble, []string{"admins", "developers"})
+		})
+
+		Convey("When the file changes", func() {
+			write(file, "users:\n  - name: dave\n    password: $2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny\n", time.Now())
+
+			Convey("Then the new users are served", func() {
+				So(backend.Authenticate(context.Background(), "dave", "test123"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the file becomes malformed", func() {
+			write(file, "users:\n  - name: dave\n    passwort: typo\n", time.Now())
+
+			Convey("Then the last good users are served", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "dave", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the file contains duplicate users", func() {
+			write(file, "users:\n  - name: alice\n  - name: alice\n", time.Now())
+
+			Convey("Then the last good users are served", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given a json users file", t, func() {
+		file := filepath.Join(dir, "users.json")
+		write(file, usersJson, time.Now())
+
+		backend, err := NewBackend(&Config{File: file, GroupAttribute: "groups"})
+		So(err, ShouldBeNil)
+
+		Convey("Then the users are served", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "groups", Value: []byte("developers")})
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "carol")
+			So(users[0].Attributes["mail"], ShouldResemble, []string{"carol@example.com"})
+			So(backend.Authenticate(context.Background(), "carol", "test123"), ShouldBeTrue)
+		})
+	})
+
+	Convey("Given a malformed users file", t, func() {
+		file := filepath.Join(dir, "invalid.json")
+		write(file, `{"users": [{"name": "carol", "attributes": {"mail": {"a": "b"}}}]}`, time.Now())
+
+		Convey("Then the backend can't be created", func() {
+			_, err := NewBackend(&Config{File: file})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package htpasswd
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	hashes := map[string]string{
+		"bcrypt": "$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny",
+		"sha1":   "{SHA}cojt0Pw//L6ToM8G41aOKFIWh7w=",
+		"apr1":   "$apr1$abcdefgh$tbBaQr8bvJpOyzoypIHIO0",
+		"md5":    "$1$abcdefgh$FuRpVTqE/Onxax.jDI2aR/",
+		"crypt":  "abRcsZmlrrKFA",
+	}
+
+	for scheme, hash := range hashes {
+		Convey("Given a "+scheme+" hash", t, func() {
+			Convey("Then the correct password is accepted", func() {
+				So(verifyPassword(context.Background(), hash, "test123"), ShouldBeTrue)
+			})
+
+			Convey("Then a wrong password is rejected", func() {
+				So(verifyPassword(context.Background(), hash, "test124"), ShouldBeFalse)
+			})
+		})
+	}
+
+	Convey("Given an unknown hash", t, func() {
+		Convey("Then the password is rejected", func() {
+			So(verifyPassword(context.Background(), "test123", "test123"), ShouldBeFalse)
+		})
+	})
+}
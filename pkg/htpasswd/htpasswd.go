@@ -0,0 +1,223 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package htpasswd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	errNoFile = errors.New("htpasswd: no file configured")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "htpasswd"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	File string `json:"file"`
+}
+
+type user struct {
+	name string
+	hash string
+}
+
+// Backend authenticates against an apache htpasswd file. The file is read
+// again as soon as its modification time or size changes.
+type Backend struct {
+	config  *Config
+	watcher *util.FileWatcher
+
+	mutex sync.RWMutex
+	users []user
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.PasswordStore = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if config.File == "" {
+		return nil, errNoFile
+	}
+
+	backend := &Backend{
+		config:  config,
+		watcher: util.NewFileWatcher(config.File),
+	}
+
+	_, err := backend.watcher.Changed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	for _, u := range backend.currentUsers() {
+		if u.name == username {
+			return verifyPassword(ctx, u.hash, password)
+		}
+	}
+
+	return false
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+
+	for _, u := range backend.currentUsers() {
+		attributes := map[string][]string{
+			"cn":  {u.name},
+			"uid": {u.name},
+		}
+
+		if f == nil || util.MatchFilter(f, attributes) {
+			users = append(users, &pkg.User{
+				DN:         u.name,
+				Attributes: attributes,
+			})
+		}
+	}
+
+	return users, nil
+}
+
+func (backend *Backend) PasswordHashes(ctx context.Context) ([]string, error) {
+	hashes := []string{}
+	for _, u := range backend.currentUsers() {
+		hashes = append(hashes, u.hash)
+	}
+
+	return hashes, nil
+}
+
+// currentUsers reloads the file if it changed and returns the users. If the
+// file can't be read, the previous users are kept.
+func (backend *Backend) currentUsers() []user {
+	changed, err := backend.watcher.Changed()
+	if err != nil {
+		log.Printf("htpasswd: %v", err)
+	} else if changed {
+		err = backend.load()
+		if err != nil {
+			log.Printf("htpasswd: keeping previous users: %v", err)
+		}
+	}
+
+	backend.mutex.RLock()
+	defer backend.mutex.RUnlock()
+
+	return backend.users
+}
+
+func (backend *Backend) load() error {
+	file, err := os.Open(backend.config.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users, err := parse(file)
+	if err != nil {
+		return err
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.users = users
+
+	log.Debugf("htpasswd: loaded %d users from %s", len(users), backend.config.File)
+
+	return nil
+}
+
+func parse(file *os.File) ([]user, error) {
+	users := []user{}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("htpasswd: invalid entry in line %d", lineNumber)
+		}
+
+		users = append(users, user{
+			name: line[:i],
+			hash: line[i+1:],
+		})
+	}
+
+	return users, scanner.Err()
+}
@@ -0,0 +1,122 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package htpasswd
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackend(t *testing.T) {
+	dir, cleanup := util.TmpDir(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, ".htpasswd")
+
+	write := func(content string, modTime time.Time) {
+		So(ioutil.WriteFile(file, []byte(content), 0600), ShouldBeNil)
+		So(os.Chtimes(file, modTime, modTime), ShouldBeNil)
+	}
+
+	Convey("Given a htpasswd file", t, func() {
+		write("# users\nalice:{SHA}cojt0Pw//L6ToM8G41aOKFIWh7w=\n\nbob:abRcsZmlrrKFA\n", time.Now().Add(-time.Hour))
+
+		backend, err := NewBackend(&Config{File: file})
+		So(err, ShouldBeNil)
+
+		Convey("Then the users are able to authenticate", func() {
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "alice", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "carol", "test123"), ShouldBeFalse)
+		})
+
+		Convey("Then the users are listed", func() {
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 2)
+			So(users[0].DN, ShouldEqual, "alice")
+			So(users[0].Attributes["cn"], ShouldResemble, []string{"alice"})
+			So(users[0].Attributes["uid"], ShouldResemble, []string{"alice"})
+		})
+
+		Convey("Then the users are filtered", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("bob")})
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "bob")
+		})
+
+		Convey("When the file changes", func() {
+			write("alice:{SHA}cojt0Pw//L6ToM8G41aOKFIWh7w=\ncarol:$apr1$abcdefgh$tbBaQr8bvJpOyzoypIHIO0\n", time.Now())
+
+			Convey("Then the new users are used", func() {
+				So(backend.Authenticate(context.Background(), "carol", "test123"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the file becomes invalid", func() {
+			write("alice\n", time.Now())
+
+			Convey("Then the previous users are kept", func() {
+				So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the file becomes invalid and is fixed later", func() {
+			valid := "carol:$apr1$abcdefgh$tbBaQr8bvJpOyzoypIHIO0\n"
+			modTime := time.Now()
+
+			write(strings.Repeat("x", len(valid)-1)+"\n", modTime)
+			So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeTrue)
+
+			Convey("Then the invalid file isn't read again until it changes", func() {
+				write(valid, modTime)
+				So(backend.Authenticate(context.Background(), "carol", "test123"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeTrue)
+
+				write(valid, modTime.Add(time.Second))
+				So(backend.Authenticate(context.Background(), "carol", "test123"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeFalse)
+			})
+		})
+	})
+
+	Convey("Given an invalid htpasswd file", t, func() {
+		write("alice\n", time.Now())
+
+		Convey("Then the backend can't be created", func() {
+			_, err := NewBackend(&Config{File: file})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
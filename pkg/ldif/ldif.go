@@ -0,0 +1,307 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ldif
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"os"
+	"sync/atomic"
+)
+
+var (
+	errNoFile = errors.New("ldif: no file configured")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "ldif"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	File string `json:"file"`
+}
+
+type entry struct {
+	dn         string
+	attributes map[string][]string
+	children   []*entry
+}
+
+// The directory information tree of a single version of the file
+type tree struct {
+	roots []*entry
+	byDn  map[string]*entry
+}
+
+// Backend serves a directory tree read from a LDIF file. The file is read
+// again as soon as it changes, an invalid file is ignored.
+type Backend struct {
+	config  *Config
+	watcher *util.FileWatcher
+	tree    atomic.Value
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.PasswordStore = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if config.File == "" {
+		return nil, errNoFile
+	}
+
+	backend := &Backend{
+		config:  config,
+		watcher: util.NewFileWatcher(config.File),
+	}
+
+	_, err := backend.watcher.Changed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	e, ok := backend.current().byDn[dn.Normalize(username)]
+	if !ok {
+		return false
+	}
+
+	for _, stored := range lookup(e.attributes, "userPassword") {
+		if verifyPassword(ctx, stored, password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (backend *Backend) PasswordHashes(ctx context.Context) ([]string, error) {
+	hashes := []string{}
+	for _, e := range backend.current().byDn {
+		hashes = append(hashes, lookup(e.attributes, "userPassword")...)
+	}
+
+	return hashes, nil
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+
+	for _, e := range backend.current().search(pkg.SearchBase(ctx), pkg.SearchScope(ctx)) {
+		if f != nil && !util.MatchFilter(f, e.attributes) {
+			continue
+		}
+
+		// copy the entry as wrapping backends modify the result
+		user := (&pkg.User{DN: e.dn, Attributes: e.attributes}).Copy()
+		for attribute := range user.Attributes {
+			if schema.Default.Key(attribute) == "userpassword" {
+				delete(user.Attributes, attribute)
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// current reloads the file if it changed and returns the tree
+func (backend *Backend) current() *tree {
+	changed, err := backend.watcher.Changed()
+	if err != nil {
+		log.Printf("ldif: %v", err)
+	} else if changed {
+		err = backend.load()
+		if err != nil {
+			log.Printf("ldif: keeping previous tree: %v", err)
+		}
+	}
+
+	return backend.tree.Load().(*tree)
+}
+
+func (backend *Backend) load() error {
+	file, err := os.Open(backend.config.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := parse(file)
+	if err != nil {
+		return err
+	}
+
+	t, err := newTree(records)
+	if err != nil {
+		return err
+	}
+
+	backend.tree.Store(t)
+
+	log.Debugf("ldif: loaded %d entries from %s", len(t.byDn), backend.config.File)
+
+	return nil
+}
+
+func newTree(records []*record) (*tree, error) {
+	t := &tree{
+		byDn: make(map[string]*entry),
+	}
+
+	dns := make([]dn.DN, len(records))
+	for i, r := range records {
+		entryDn, err := dn.Parse(r.dn)
+		if err != nil {
+			return nil, fmt.Errorf("ldif: %v", err)
+		}
+
+		dns[i] = entryDn.Normalize()
+		key := dns[i].String()
+		if _, ok := t.byDn[key]; ok {
+			return nil, fmt.Errorf("ldif: duplicate entry %s", r.dn)
+		}
+
+		t.byDn[key] = &entry{
+			dn:         r.dn,
+			attributes: r.attributes,
+		}
+	}
+
+	// link the entries in the order of the file
+	for i, r := range records {
+		e := t.byDn[dns[i].String()]
+
+		parent, ok := t.byDn[dns[i].Parent().String()]
+		switch {
+		case ok && len(dns[i]) > 1:
+			parent.children = append(parent.children, e)
+		case t.hasAncestor(dns[i]):
+			return nil, fmt.Errorf("ldif: parent of entry %s missing", r.dn)
+		default:
+			t.roots = append(t.roots, e)
+		}
+	}
+
+	return t, nil
+}
+
+func (t *tree) hasAncestor(entryDn dn.DN) bool {
+	for parent := entryDn.Parent(); len(parent) > 0; parent = parent.Parent() {
+		if _, ok := t.byDn[parent.String()]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// search returns the entries inside the scope of the base dn. The empty base
+// dn is the parent of the root entries.
+func (t *tree) search(baseDn string, scope ldap.Scope) []*entry {
+	var base *entry
+	children := t.roots
+
+	if baseDn != "" {
+		var ok bool
+		base, ok = t.byDn[dn.Normalize(baseDn)]
+		if !ok {
+			return nil
+		}
+		children = base.children
+	}
+
+	switch scope {
+	case ldap.ScopeBaseObject:
+		if base == nil {
+			return nil
+		}
+		return []*entry{base}
+	case ldap.ScopeSingleLevel:
+		return children
+	case ldap.ScopeChildren:
+		return descendants(nil, children)
+	default:
+		if base == nil {
+			return descendants(nil, children)
+		}
+		return descendants(nil, []*entry{base})
+	}
+}
+
+func descendants(result []*entry, entries []*entry) []*entry {
+	for _, e := range entries {
+		result = append(result, e)
+		result = descendants(result, e.children)
+	}
+
+	return result
+}
+
+func lookup(attributes map[string][]string, name string) []string {
+	return schema.Default.Values(attributes, name)
+}
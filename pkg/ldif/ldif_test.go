@@ -0,0 +1,170 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ldif
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const directory = `dn: dc=example,dc=org
+objectClass: domain
+dc: example
+
+dn: ou=People,dc=example,dc=org
+objectClass: organizationalUnit
+ou: People
+
+dn: uid=alice,ou=People,dc=example,dc=org
+objectClass: inetOrgPerson
+uid: alice
+cn: Alice Smith
+userPassword: {SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==
+
+dn: uid=bob,ou=People,dc=example,dc=org
+objectClass: inetOrgPerson
+uid: bob
+cn: Bob Jones
+userPassword: {CRYPT}$apr1$abcdefgh$tbBaQr8bvJpOyzoypIHIO0
+
+dn: ou=Services,dc=example,dc=org
+objectClass: organizationalUnit
+ou: Services
+
+dn: cn=gitlab,ou=Services,dc=example,dc=org
+objectClass: applicationProcess
+cn: gitlab
+userPassword: {BCRYPT}$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny
+
+dn: cn=admins,dc=example,dc=org
+objectClass: groupOfNames
+cn: admins
+member: uid=alice,ou=People,dc=example,dc=org
+`
+
+func TestBackend(t *testing.T) {
+	dir, cleanup := util.TmpDir(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "directory.ldif")
+
+	write := func(content string, modTime time.Time) {
+		So(ioutil.WriteFile(file, []byte(content), 0600), ShouldBeNil)
+		So(os.Chtimes(file, modTime, modTime), ShouldBeNil)
+	}
+
+	Convey("Given a LDIF directory", t, func() {
+		write(directory, time.Now().Add(-time.Hour))
+
+		backend, err := NewBackend(&Config{File: file})
+		So(err, ShouldBeNil)
+
+		Convey("Then the entries are able to authenticate", func() {
+			So(backend.Authenticate(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "UID=alice, ou=people,dc=example,dc=org", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "uid=bob,ou=People,dc=example,dc=org", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "cn=gitlab,ou=Services,dc=example,dc=org", "test123"), ShouldBeTrue)
+
+			So(backend.Authenticate(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "ou=People,dc=example,dc=org", "test123"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "uid=carol,ou=People,dc=example,dc=org", "test123"), ShouldBeFalse)
+		})
+
+		Convey("Then the tree is searched with the scope", func() {
+			dns := func(entries []*entry) []string {
+				result := []string{}
+				for _, e := range entries {
+					result = append(result, e.dn)
+				}
+				return result
+			}
+
+			t := backend.current()
+
+			So(dns(t.search("ou=People,dc=example,dc=org", ldap.ScopeBaseObject)), ShouldResemble, []string{
+				"ou=People,dc=example,dc=org",
+			})
+			So(dns(t.search("ou=people,dc=example,dc=org", ldap.ScopeSingleLevel)), ShouldResemble, []string{
+				"uid=alice,ou=People,dc=example,dc=org",
+				"uid=bob,ou=People,dc=example,dc=org",
+			})
+			So(dns(t.search("dc=example,dc=org", ldap.ScopeSingleLevel)), ShouldResemble, []string{
+				"ou=People,dc=example,dc=org",
+				"ou=Services,dc=example,dc=org",
+				"cn=admins,dc=example,dc=org",
+			})
+			So(dns(t.search("ou=Services,dc=example,dc=org", ldap.ScopeWholeSubtree)), ShouldResemble, []string{
+				"ou=Services,dc=example,dc=org",
+				"cn=gitlab,ou=Services,dc=example,dc=org",
+			})
+			So(t.search("", ldap.ScopeWholeSubtree), ShouldHaveLength, 7)
+			So(t.search("dc=other,dc=org", ldap.ScopeWholeSubtree), ShouldBeEmpty)
+		})
+
+		Convey("Then the entries are filtered", func() {
+			f, err := ldap.ParseFilter("(&(objectClass=inetOrgPerson)(cn=*smith))")
+			So(err, ShouldBeNil)
+
+			users, err := backend.GetUsers(context.Background(), f)
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "uid=alice,ou=People,dc=example,dc=org")
+			So(users[0].Attributes["cn"], ShouldResemble, []string{"Alice Smith"})
+			So(users[0].Attributes, ShouldNotContainKey, "userPassword")
+		})
+
+		Convey("Then modifying the result doesn't change the tree", func() {
+			f := &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")}
+			users, err := backend.GetUsers(context.Background(), f)
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			users[0].Attributes["cn"][0] = "Mallory"
+
+			users, err = backend.GetUsers(context.Background(), f)
+			So(err, ShouldBeNil)
+			So(users[0].Attributes["cn"], ShouldResemble, []string{"Alice Smith"})
+		})
+
+		Convey("When the file changes", func() {
+			write(directory+"\ndn: uid=carol,ou=People,dc=example,dc=org\nuid: carol\nuserPassword: {SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==\n", time.Now())
+
+			Convey("Then the tree is refreshed", func() {
+				So(backend.Authenticate(context.Background(), "uid=carol,ou=People,dc=example,dc=org", "test123"), ShouldBeTrue)
+			})
+		})
+
+		Convey("When an entry without parent is added", func() {
+			write(directory+"\ndn: uid=carol,ou=Missing,dc=example,dc=org\nuid: carol\n", time.Now())
+
+			Convey("Then the previous tree is kept", func() {
+				So(backend.current().byDn, ShouldHaveLength, 7)
+			})
+		})
+	})
+}
@@ -0,0 +1,159 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"io"
+	"strings"
+)
+
+// A record is a single entry of a LDIF file.
+type record struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// parse reads the entries of a LDIF file (RFC 2849). Change records other
+// than add are not supported.
+func parse(reader io.Reader) ([]*record, error) {
+	lines, err := unfold(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*record{}
+	var current *record
+
+	for _, line := range lines {
+		if line.text == "" {
+			current = nil
+			continue
+		}
+
+		name, value, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil {
+			switch strings.ToLower(name) {
+			case "version":
+				continue
+			case "dn":
+				current = &record{
+					dn:         value,
+					attributes: map[string][]string{},
+				}
+				records = append(records, current)
+				continue
+			default:
+				return nil, fmt.Errorf("ldif: line %d: expected dn but got %s", line.number, name)
+			}
+		}
+
+		if strings.ToLower(name) == "changetype" {
+			if strings.ToLower(value) != "add" {
+				return nil, fmt.Errorf("ldif: line %d: unsupported changetype %s", line.number, value)
+			}
+			continue
+		}
+
+		addValue(current.attributes, name, value)
+	}
+
+	return records, nil
+}
+
+type line struct {
+	number int
+	text   string
+}
+
+// unfold joins continued lines and removes comments
+func unfold(reader io.Reader) ([]line, error) {
+	lines := []line{}
+	comment := false
+
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+
+		switch {
+		case strings.HasPrefix(text, " "):
+			if comment {
+				continue
+			}
+			if len(lines) == 0 || lines[len(lines)-1].text == "" {
+				return nil, fmt.Errorf("ldif: line %d: unexpected continuation", number)
+			}
+			lines[len(lines)-1].text += text[1:]
+		case strings.HasPrefix(text, "#"):
+			comment = true
+		default:
+			comment = false
+			lines = append(lines, line{number: number, text: text})
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+func parseLine(l line) (name string, value string, err error) {
+	i := strings.IndexByte(l.text, ':')
+	if i <= 0 {
+		return "", "", fmt.Errorf("ldif: line %d: missing attribute name", l.number)
+	}
+
+	name, value = l.text[:i], l.text[i+1:]
+
+	switch {
+	case strings.HasPrefix(value, ":"):
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return "", "", fmt.Errorf("ldif: line %d: %v", l.number, err)
+		}
+		value = string(decoded)
+	case strings.HasPrefix(value, "<"):
+		return "", "", fmt.Errorf("ldif: line %d: urls are not supported", l.number)
+	default:
+		value = strings.TrimLeft(value, " ")
+	}
+
+	return name, value, nil
+}
+
+// addValue appends the value to the attribute using the first spelling of the
+// attribute name, aliases of the schema are the same attribute.
+func addValue(attributes map[string][]string, name string, value string) {
+	key := schema.Default.Key(name)
+	for attribute := range attributes {
+		if schema.Default.Key(attribute) == key {
+			attributes[attribute] = append(attributes[attribute], value)
+			return
+		}
+	}
+
+	attributes[name] = []string{value}
+}
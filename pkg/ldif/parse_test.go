@@ -0,0 +1,78 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ldif
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	Convey("Given a LDIF file with folded, encoded and commented lines", t, func() {
+		records, err := parse(strings.NewReader(`version: 1
+
+# the base entry
+#  with a continued comment
+dn: dc=example,dc=org
+objectClass: top
+objectClass: domain
+dc: example
+
+dn: cn=Alice,dc=example,dc=org
+changetype: add
+cn: Alice
+description: a long
+  description
+CN: Smith
+sn:: U21pdGggw6Q=
+`))
+
+		Convey("Then the records are parsed", func() {
+			So(err, ShouldBeNil)
+			So(records, ShouldHaveLength, 2)
+
+			So(records[0].dn, ShouldEqual, "dc=example,dc=org")
+			So(records[0].attributes["objectClass"], ShouldResemble, []string{"top", "domain"})
+
+			So(records[1].dn, ShouldEqual, "cn=Alice,dc=example,dc=org")
+			So(records[1].attributes["cn"], ShouldResemble, []string{"Alice", "Smith"})
+			So(records[1].attributes["description"], ShouldResemble, []string{"a long description"})
+			So(records[1].attributes["sn"], ShouldResemble, []string{"Smith ä"})
+		})
+	})
+
+	Convey("Given a record without dn", t, func() {
+		_, err := parse(strings.NewReader("cn: Alice\n"))
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a modify record", t, func() {
+		_, err := parse(strings.NewReader("dn: cn=Alice,dc=example,dc=org\nchangetype: modify\n"))
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ldif
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"strings"
+)
+
+// verifyPassword checks the password against a userPassword value. The scheme
+// like {CRYPT}, {BCRYPT}, {SSHA} or {SSHA512} is detected from the value.
+// Values without a scheme are clear text and never match.
+func verifyPassword(ctx context.Context, stored string, password string) bool {
+	if !strings.HasPrefix(stored, "{") {
+		return false
+	}
+
+	return util.VerifyPasswordCtx(ctx, stored, password)
+}
@@ -44,8 +44,8 @@ func (l *logBackend) logCtx(name string, ctx ldap.Context, start time.Time) {
 	duration := time.Since(start)
 
 	sess := ctx.(*session)
-	if getDn(sess.context) != "" {
-		log.Print(getId(sess.context), " ", rightPad(10, name), " ", getDn(sess.context), " ", duration)
+	if BoundDn(sess.context) != "" {
+		log.Print(getId(sess.context), " ", rightPad(10, name), " ", BoundDn(sess.context), " ", duration)
 	} else {
 		log.Print(getId(sess.context), " ", rightPad(10, name), " ", duration)
 	}
@@ -55,8 +55,8 @@ func (l *logBackend) logCtxAuth(name string, ctx ldap.Context, authenticated boo
 	duration := time.Since(start)
 
 	sess := ctx.(*session)
-	if getDn(sess.context) != "" {
-		log.Print(getId(sess.context), " ", rightPad(10, name), " ", getDn(sess.context), " ", authenticated, " ", duration)
+	if BoundDn(sess.context) != "" {
+		log.Print(getId(sess.context), " ", rightPad(10, name), " ", BoundDn(sess.context), " ", authenticated, " ", duration)
 	} else {
 		log.Print(getId(sess.context), " ", rightPad(10, name), " ", authenticated, " ", duration)
 	}
@@ -66,8 +66,8 @@ func (l *logBackend) logCtxFilter(name string, ctx ldap.Context, filter ldap.Fil
 	duration := time.Since(start)
 
 	sess := ctx.(*session)
-	if getDn(sess.context) != "" {
-		log.Print(getId(sess.context), " ", rightPad(10, name), " ", getDn(sess.context), " ", filter, " ", duration)
+	if BoundDn(sess.context) != "" {
+		log.Print(getId(sess.context), " ", rightPad(10, name), " ", BoundDn(sess.context), " ", filter, " ", duration)
 	} else {
 		log.Print(getId(sess.context), " ", rightPad(10, name), " ", filter, " ", duration)
 	}
@@ -23,6 +23,7 @@ package memory
 import (
 	"context"
 	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
 	"github.com/gopenguin/ldap-proxy/pkg/util"
 	"github.com/samuel/go-ldap/ldap"
 )
@@ -73,9 +74,9 @@ func NewBackend(config *Config) (bknd *backend) {
 		users:  make(map[string]User),
 	}
 
-	// prepare for lookup
+	// prepare for lookup, names which are dns are compared like dns
 	for _, user := range config.Users {
-		bknd.users[user.Name] = user
+		bknd.users[dn.Normalize(user.Name)] = user
 	}
 
 	return
@@ -86,7 +87,7 @@ func (backend *backend) Name() (name string) {
 }
 
 func (backend *backend) Authenticate(ctx context.Context, username string, password string) (successful bool) {
-	user, ok := backend.users[username]
+	user, ok := backend.users[dn.Normalize(username)]
 	if !ok {
 		return false
 	}
@@ -94,18 +95,29 @@ func (backend *backend) Authenticate(ctx context.Context, username string, passw
 	return util.VerifyPasswordCtx(ctx, user.Password, password)
 }
 
+func (backend *backend) PasswordHashes(ctx context.Context) ([]string, error) {
+	hashes := []string{}
+	for _, user := range backend.config.Users {
+		hashes = append(hashes, user.Password)
+	}
+
+	return hashes, nil
+}
+
 func (backend *backend) GetUsers(ctx context.Context, f ldap.Filter) (users []*pkg.User, err error) {
 	users = []*pkg.User{}
 
 	if backend.config.ListUsers {
 		for _, user := range backend.config.Users {
-			if f == nil || backend.filterUser(user, f) {
+			attributes := map[string][]string{
+				"cn": {user.Name},
+			}
+
+			if f == nil || util.MatchFilter(f, attributes) {
 				users = append(users,
 					&pkg.User{
-						DN: user.Name,
-						Attributes: map[string][]string{
-							"cn": {user.Name},
-						},
+						DN:         user.Name,
+						Attributes: attributes,
 					})
 			}
 		}
@@ -113,51 +125,3 @@ func (backend *backend) GetUsers(ctx context.Context, f ldap.Filter) (users []*p
 
 	return
 }
-
-func (backend *backend) filterUser(user User, f ldap.Filter) bool {
-	switch f.(type) {
-	case *ldap.AND:
-		a := f.(*ldap.AND)
-		res := true
-
-		for _, filter := range a.Filters {
-			res = res && backend.filterUser(user, filter)
-		}
-		return res
-
-	case *ldap.OR:
-		a := f.(*ldap.OR)
-		res := false
-
-		for _, filter := range a.Filters {
-			res = res || backend.filterUser(user, filter)
-		}
-		return res
-
-	case *ldap.EqualityMatch:
-		e := f.(*ldap.EqualityMatch)
-
-		switch e.Attribute {
-		case "cn":
-			return string(e.Value) == user.Name
-		}
-
-	case *ldap.ApproxMatch:
-		a := f.(*ldap.ApproxMatch)
-
-		switch a.Attribute {
-		case "cn":
-			return string(a.Value) == user.Name
-		}
-
-	case *ldap.Present:
-		p := f.(*ldap.Present)
-
-		switch p.Attribute {
-		case "cn":
-			return true
-		}
-	}
-
-	return false
-}
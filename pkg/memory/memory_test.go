@@ -23,6 +23,7 @@ package memory
 import (
 	"context"
 	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/samuel/go-ldap/ldap"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -45,9 +46,18 @@ func TestBackend_Authenticate(t *testing.T) {
 		backend := NewBackend(&Config{
 			Users: []User{
 				{Name: "user1", Password: "$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny"},
+				{Name: "uid=alice,ou=People,dc=x", Password: "$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny"},
 			},
 		})
 
+		Convey("When a user authenticates with a differently formatted dn", func() {
+			result := backend.Authenticate(context.Background(), "UID=Alice, ou=People,dc=x", "test123")
+
+			Convey("Then the dns are compared like dns", func() {
+				So(result, ShouldBeTrue)
+			})
+		})
+
 		Convey("When user1 authenticates", func() {
 			result := backend.Authenticate(context.Background(), "user1", "test123")
 
@@ -89,6 +99,28 @@ func TestBackend_GetUsers(t *testing.T) {
 			})
 		})
 
+		Convey("Given the users are filtered", func() {
+			backend.config.ListUsers = true
+
+			Convey("When the filter names the attribute differently", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "commonName", Value: []byte("USER1")})
+
+				Convey("Then the attribute is matched by the schema", func() {
+					So(err, ShouldBeNil)
+					So(users, ShouldHaveLength, 1)
+				})
+			})
+
+			Convey("When the filter doesn't match", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "cn", Value: []byte("user2")})
+
+				Convey("Then no users are returned", func() {
+					So(err, ShouldBeNil)
+					So(users, ShouldHaveLength, 0)
+				})
+			})
+		})
+
 		Convey("Given the config is set to not list users", func() {
 			backend.config.ListUsers = false
 
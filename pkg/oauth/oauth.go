@@ -0,0 +1,382 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"golang.org/x/oauth2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	errNoIssuer    = errors.New("oauth2: no issuer configured")
+	errNoIdToken   = errors.New("oauth2: token response without id token")
+	errNoDnClaim   = errors.New("oauth2: no claim mapped to the dn attribute")
+	errNotVerified = errors.New("oauth2: id token verification unavailable")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "oauth2"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	Issuer       string            `json:"issuer"`
+	TokenUrl     string            `json:"tokenUrl"`
+	JwksUrl      string            `json:"jwksUrl"`
+	UserinfoUrl  string            `json:"userinfoUrl"`
+	ClientId     string            `json:"clientId"`
+	ClientSecret string            `json:"clientSecret"`
+	Scopes       []string          `json:"scopes"`
+	Claims       map[string]string `json:"claims"`
+	TLS          util.TLSConfig    `json:"tls"`
+	Timeout      util.Duration     `json:"timeout"`
+
+	// how long authenticated users are listed after their last bind
+	UserTtl util.Duration `json:"userTtl"`
+}
+
+// Backend authenticates users with the resource owner password credentials
+// grant of an OAuth2/OIDC identity provider. The endpoints which aren't
+// configured are discovered from the issuer on first use.
+//
+// The identity provider doesn't allow to list users, so GetUsers returns the
+// users which authenticated within the user ttl with their mapped claims.
+type Backend struct {
+	config *Config
+	client *http.Client
+
+	// the context for requests outside of a bind e. g. fetching the keys
+	clientContext context.Context
+
+	mutex       sync.Mutex
+	oauth2      *oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	userinfoUrl string
+	users       map[string]*listedUser
+	now         func() time.Time
+}
+
+// listedUser is an authenticated user which is listed until it expires
+type listedUser struct {
+	user    *pkg.User
+	expires time.Time
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.ErrorAuthenticator = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if config.Issuer == "" {
+		return nil, errNoIssuer
+	}
+	if len(config.Claims) > 0 && config.DNAttribute == "" {
+		return nil, errNoDnClaim
+	}
+
+	tlsConfig, err := config.TLS.Load("")
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: config.Timeout.Or(10 * time.Second),
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	return &Backend{
+		config:        config,
+		client:        client,
+		clientContext: oidc.ClientContext(context.Background(), client),
+		users:         make(map[string]*listedUser),
+		now:           time.Now,
+	}, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	ok, err := backend.AuthenticateErr(ctx, username, password)
+	if err != nil {
+		log.Printf("oauth2: authentication of %s failed: %v", username, err)
+	}
+
+	return ok
+}
+
+// AuthenticateErr returns an error if the identity provider couldn't be asked,
+// client errors of the token endpoint like invalid grants are rejections.
+func (backend *Backend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	err := backend.authenticate(ctx, username, password)
+	if err == nil {
+		return true, nil
+	}
+
+	if retrieveErr, ok := err.(*oauth2.RetrieveError); ok && retrieveErr.Response != nil &&
+		retrieveErr.Response.StatusCode >= 400 && retrieveErr.Response.StatusCode < 500 {
+		log.Debugf("oauth2: authentication of %s failed: %v", username, err)
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.expireUsers()
+
+	users := []*pkg.User{}
+	for _, listed := range backend.users {
+		if f == nil || util.MatchFilter(f, listed.user.Attributes) {
+			users = append(users, listed.user.Copy())
+		}
+	}
+
+	return users, nil
+}
+
+func (backend *Backend) authenticate(ctx context.Context, username string, password string) error {
+	err := backend.discover()
+	if err != nil {
+		return err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, backend.client)
+
+	token, err := backend.oauth2.PasswordCredentialsToken(ctx, username, password)
+	if err != nil {
+		return err
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return errNoIdToken
+	}
+
+	idToken, err := backend.verifier.Verify(oidc.ClientContext(ctx, backend.client), rawIdToken)
+	if err != nil {
+		return err
+	}
+
+	if len(backend.config.Claims) == 0 {
+		return nil
+	}
+
+	claims := map[string]json.RawMessage{}
+	if backend.userinfoUrl != "" {
+		err = backend.userinfo(ctx, token, &claims)
+	} else {
+		err = idToken.Claims(&claims)
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := backend.mapClaims(claims)
+	if err != nil {
+		// the user is authenticated but can't be listed
+		log.Printf("oauth2: %s: %v", username, err)
+		return nil
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.expireUsers()
+	backend.users[username] = &listedUser{
+		user:    user,
+		expires: backend.now().Add(backend.config.UserTtl.Or(24 * time.Hour)),
+	}
+
+	return nil
+}
+
+// expireUsers removes the users whose last bind is older than the user ttl,
+// the mutex must be held.
+func (backend *Backend) expireUsers() {
+	now := backend.now()
+	for username, listed := range backend.users {
+		if now.After(listed.expires) {
+			delete(backend.users, username)
+		}
+	}
+}
+
+// discover completes the configuration using the discovery document of the
+// issuer.
+func (backend *Backend) discover() error {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	if backend.verifier != nil {
+		return nil
+	}
+
+	config := backend.config
+	tokenUrl, jwksUrl, userinfoUrl := config.TokenUrl, config.JwksUrl, config.UserinfoUrl
+
+	if tokenUrl == "" || jwksUrl == "" {
+		provider, err := oidc.NewProvider(backend.clientContext, config.Issuer)
+		if err != nil {
+			return err
+		}
+
+		endpoints := struct {
+			TokenUrl    string `json:"token_endpoint"`
+			JwksUrl     string `json:"jwks_uri"`
+			UserinfoUrl string `json:"userinfo_endpoint"`
+		}{}
+		err = provider.Claims(&endpoints)
+		if err != nil {
+			return err
+		}
+
+		if tokenUrl == "" {
+			tokenUrl = endpoints.TokenUrl
+		}
+		if jwksUrl == "" {
+			jwksUrl = endpoints.JwksUrl
+		}
+		if userinfoUrl == "" {
+			userinfoUrl = endpoints.UserinfoUrl
+		}
+	}
+
+	if jwksUrl == "" {
+		return errNotVerified
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID}
+	}
+
+	backend.oauth2 = &oauth2.Config{
+		ClientID:     config.ClientId,
+		ClientSecret: config.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			TokenURL: tokenUrl,
+		},
+		Scopes: scopes,
+	}
+	backend.verifier = oidc.NewVerifier(config.Issuer, oidc.NewRemoteKeySet(backend.clientContext, jwksUrl), &oidc.Config{
+		ClientID: config.ClientId,
+	})
+	backend.userinfoUrl = userinfoUrl
+
+	return nil
+}
+
+func (backend *Backend) userinfo(ctx context.Context, token *oauth2.Token, claims interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, backend.userinfoUrl, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	token.SetAuthHeader(req)
+
+	res, err := backend.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth2: unexpected userinfo status %s", res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(claims)
+}
+
+// mapClaims converts the configured claims into attributes. Claims which
+// aren't single values or lists of values are ignored.
+func (backend *Backend) mapClaims(claims map[string]json.RawMessage) (*pkg.User, error) {
+	user := &pkg.User{
+		Attributes: map[string][]string{},
+	}
+
+	for claim, attribute := range backend.config.Claims {
+		raw, ok := claims[claim]
+		if !ok {
+			continue
+		}
+
+		var values util.Values
+		err := json.Unmarshal(raw, &values)
+		if err != nil {
+			log.Debugf("oauth2: ignoring claim %s: %v", claim, err)
+			continue
+		}
+
+		user.Attributes[attribute] = values
+	}
+
+	dn := user.Attributes[backend.config.DNAttribute]
+	if len(dn) == 0 {
+		return nil, errNoDnClaim
+	}
+	user.DN = dn[0]
+
+	return user, nil
+}
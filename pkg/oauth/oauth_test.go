@@ -0,0 +1,256 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/square/go-jose.v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// identityProvider is a stub of an OIDC identity provider supporting the
+// password grant.
+type identityProvider struct {
+	*httptest.Server
+
+	key       *rsa.PrivateKey
+	issuer    string
+	userinfos int
+}
+
+func newIdentityProvider(t *testing.T) *identityProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &identityProvider{key: key}
+	idp.Server = httptest.NewServer(idp)
+	idp.issuer = idp.Server.URL
+
+	return idp
+}
+
+func (idp *identityProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":            idp.URL,
+			"token_endpoint":    idp.URL + "/token",
+			"jwks_uri":          idp.URL + "/keys",
+			"userinfo_endpoint": idp.URL + "/userinfo",
+		})
+
+	case "/keys":
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{Key: &idp.key.PublicKey, KeyID: "1", Algorithm: "RS256", Use: "sig"}},
+		})
+
+	case "/token":
+		clientId, clientSecret, _ := r.BasicAuth()
+		if r.PostFormValue("client_id") != "" {
+			clientId, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+		}
+
+		if r.PostFormValue("grant_type") != "password" || clientId != "proxy" || clientSecret != "secret" ||
+			r.PostFormValue("username") != "alice" || r.PostFormValue("password") != "test123" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idp.idToken(),
+		})
+
+	case "/userinfo":
+		idp.userinfos++
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"sub": "1", "preferred_username": "alice", "email": "alice@example.org", "groups": ["admins", "developers"], "address": {"country": "DE"}}`))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (idp *identityProvider) idToken() string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: idp.key, KeyID: "1"}}, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":                idp.issuer,
+		"sub":                "1",
+		"aud":                "proxy",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"preferred_username": "alice",
+	})
+
+	signature, err := signer.Sign(claims)
+	if err != nil {
+		panic(err)
+	}
+
+	token, err := signature.CompactSerialize()
+	if err != nil {
+		panic(err)
+	}
+
+	return token
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given an identity provider", t, func() {
+		idp := newIdentityProvider(t)
+		defer idp.Close()
+
+		config := &Config{
+			Issuer:       idp.URL,
+			ClientId:     "proxy",
+			ClientSecret: "secret",
+		}
+
+		Convey("Given a backend using discovery", func() {
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then valid credentials are accepted", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			})
+
+			Convey("Then invalid credentials are rejected", func() {
+				So(backend.Authenticate(context.Background(), "alice", "wrong"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeFalse)
+			})
+
+			Convey("Then no users are listed", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+
+				users, err := backend.GetUsers(context.Background(), nil)
+				So(err, ShouldBeNil)
+				So(users, ShouldBeEmpty)
+				So(idp.userinfos, ShouldEqual, 0)
+			})
+		})
+
+		Convey("Given an id token of another issuer", func() {
+			idp.issuer = "https://evil.example.org"
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the credentials are rejected", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Given a backend for another client", func() {
+			config.ClientId = "other"
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the credentials are rejected", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Given a backend with explicit endpoints and claim mapping", func() {
+			config.TokenUrl = idp.URL + "/token"
+			config.JwksUrl = idp.URL + "/keys"
+			config.UserinfoUrl = idp.URL + "/userinfo"
+			config.DNAttribute = "uid"
+			config.Claims = map[string]string{
+				"preferred_username": "uid",
+				"email":              "mail",
+				"groups":             "memberOf",
+				"address":            "postalAddress",
+			}
+
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("When a user authenticates", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+
+				Convey("Then the claims are mapped to the attributes", func() {
+					users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "memberOf", Value: []byte("admins")})
+
+					So(err, ShouldBeNil)
+					So(users, ShouldHaveLength, 1)
+					So(users[0], ShouldResemble, &pkg.User{
+						DN: "alice",
+						Attributes: map[string][]string{
+							"uid":      {"alice"},
+							"mail":     {"alice@example.org"},
+							"memberOf": {"admins", "developers"},
+						},
+					})
+				})
+
+				Convey("Then the listed users are copies", func() {
+					users, err := backend.GetUsers(context.Background(), nil)
+					So(err, ShouldBeNil)
+					So(users, ShouldHaveLength, 1)
+					users[0].Attributes["uid"][0] = "mallory"
+
+					users, err = backend.GetUsers(context.Background(), nil)
+					So(err, ShouldBeNil)
+					So(users[0].Attributes["uid"], ShouldResemble, []string{"alice"})
+				})
+
+				Convey("Then the user isn't listed after the user ttl", func() {
+					backend.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+
+					users, err := backend.GetUsers(context.Background(), nil)
+					So(err, ShouldBeNil)
+					So(users, ShouldBeEmpty)
+				})
+			})
+		})
+	})
+
+	Convey("Given a claim mapping without dn attribute", t, func() {
+		_, err := NewBackend(&Config{Issuer: "https://idp.example.org", Claims: map[string]string{"email": "mail"}})
+
+		Convey("Then the backend can't be created", func() {
+			So(err, ShouldEqual, errNoDnClaim)
+		})
+	})
+}
@@ -0,0 +1,251 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/samuel/go-ldap/ldap"
+)
+
+var (
+	errNoClientDn = errors.New("policy: a client needs a dn or a base")
+)
+
+type wrapperFactory struct{}
+
+func NewWrapperFactory() pkg.WrapperFactory {
+	return &wrapperFactory{}
+}
+
+func (wrapperFactory) Name() string {
+	return "policy"
+}
+
+func (wrapperFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (wrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	policyConfig, ok := config.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	return NewBackend(backend, policyConfig)
+}
+
+// Config of the search policy. The filter applies to all clients without an
+// entry in clients, an empty filter doesn't restrict the searches.
+type Config struct {
+	Filter  string   `json:"filter"`
+	Clients []Client `json:"clients"`
+	Rules   []Rule   `json:"rules"`
+}
+
+// Client selects the mandatory filter by the bound dn. Either the dn equals
+// the bound dn or the bound dn is located below the base.
+type Client struct {
+	Dn     string `json:"dn"`
+	Base   string `json:"base"`
+	Filter string `json:"filter"`
+}
+
+type client struct {
+	dn     string
+	base   string
+	filter ldap.Filter
+}
+
+// Backend enforces the search policy. The rules are applied to the filters of
+// the clients first, afterwards the mandatory filter of the bound dn is added.
+type Backend struct {
+	delegate pkg.Backend
+	filter   ldap.Filter
+	clients  []*client
+	rules    []*rule
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Wrapper = &Backend{}
+
+func NewBackend(delegate pkg.Backend, config *Config) (*Backend, error) {
+	backend := &Backend{
+		delegate: delegate,
+	}
+
+	var err error
+	backend.filter, err = parseFilter(config.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, clientConfig := range config.Clients {
+		if clientConfig.Dn == "" && clientConfig.Base == "" {
+			return nil, errNoClientDn
+		}
+
+		c := &client{dn: clientConfig.Dn, base: clientConfig.Base}
+		c.filter, err = parseFilter(clientConfig.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.clients = append(backend.clients, c)
+	}
+
+	for _, ruleConfig := range config.Rules {
+		r, err := compileRule(ruleConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debugf("policy: %s: %s", delegate.Name(), r)
+		backend.rules = append(backend.rules, r)
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() string {
+	return backend.delegate.Name()
+}
+
+func (backend *Backend) Unwrap() pkg.Backend {
+	return backend.delegate
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	return backend.delegate.Authenticate(ctx, username, password)
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	f, err := backend.rewrite(pkg.BoundDn(ctx), f)
+	if err != nil {
+		return nil, err
+	}
+
+	return backend.delegate.GetUsers(ctx, f)
+}
+
+// rewrite applies the rules to the filter and adds the mandatory filter of the
+// bound dn.
+func (backend *Backend) rewrite(boundDn string, f ldap.Filter) (ldap.Filter, error) {
+	if f != nil {
+		rewritten, err := backend.apply(f)
+		if err != nil {
+			log.Debugf("policy: %s: %v: %s", boundDn, err, f)
+			return nil, err
+		}
+		f = rewritten
+	}
+
+	mandatory := backend.mandatory(boundDn)
+	switch {
+	case mandatory == nil:
+		return f, nil
+	case f == nil:
+		f = mandatory
+	default:
+		f = &ldap.AND{Filters: []ldap.Filter{mandatory, f}}
+	}
+
+	log.Debugf("policy: %s searches with %s", boundDn, f)
+
+	return f, nil
+}
+
+// apply replaces or rejects the first part of the filter matched by a rule.
+// The replacements aren't matched again.
+func (backend *Backend) apply(f ldap.Filter) (ldap.Filter, error) {
+	for _, r := range backend.rules {
+		values, ok := r.matches(f)
+		if !ok {
+			continue
+		}
+
+		if r.reject {
+			return nil, pkg.ErrSearchRejected
+		}
+
+		replaced := fill(r.replace, values)
+		log.Debugf("policy: %s replaced by %s", f, replaced)
+
+		return replaced, nil
+	}
+
+	var err error
+	switch f := f.(type) {
+	case *ldap.AND:
+		and := &ldap.AND{Filters: make([]ldap.Filter, len(f.Filters))}
+		for i, filter := range f.Filters {
+			if and.Filters[i], err = backend.apply(filter); err != nil {
+				return nil, err
+			}
+		}
+		return and, nil
+	case *ldap.OR:
+		or := &ldap.OR{Filters: make([]ldap.Filter, len(f.Filters))}
+		for i, filter := range f.Filters {
+			if or.Filters[i], err = backend.apply(filter); err != nil {
+				return nil, err
+			}
+		}
+		return or, nil
+	case *ldap.NOT:
+		not := &ldap.NOT{}
+		if not.Filter, err = backend.apply(f.Filter); err != nil {
+			return nil, err
+		}
+		return not, nil
+	default:
+		return f, nil
+	}
+}
+
+// mandatory returns the filter of the first client matching the bound dn or
+// the default filter.
+func (backend *Backend) mandatory(boundDn string) ldap.Filter {
+	for _, c := range backend.clients {
+		if c.dn != "" && dn.Equal(boundDn, c.dn) || c.base != "" && dn.IsWithin(boundDn, c.base) {
+			return c.filter
+		}
+	}
+
+	return backend.filter
+}
+
+func parseFilter(filter string) (ldap.Filter, error) {
+	if filter == "" {
+		return nil, nil
+	}
+
+	f, err := ldap.ParseFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("policy: invalid filter %s: %v", filter, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,153 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package policy
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type testBackend struct {
+	lastFilter ldap.Filter
+}
+
+func (backend *testBackend) Name() string {
+	return "test"
+}
+
+func (backend *testBackend) Authenticate(ctx context.Context, username string, password string) bool {
+	return true
+}
+
+func (backend *testBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.lastFilter = f
+	return []*pkg.User{}, nil
+}
+
+func mustParse(filter string) ldap.Filter {
+	f, err := ldap.ParseFilter(filter)
+	So(err, ShouldBeNil)
+	return f
+}
+
+func TestBackend_Mandatory(t *testing.T) {
+	Convey("Given a policy with mandatory filters", t, func() {
+		backend, err := NewBackend(&testBackend{}, &Config{
+			Filter: "(employeeType=staff)",
+			Clients: []Client{
+				{Dn: "cn=admin,ou=Apps,dc=example,dc=com"},
+				{Base: "ou=Partners,dc=example,dc=com", Filter: "(employeeType=partner)"},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		rewrite := func(boundDn string, filter string) string {
+			var f ldap.Filter
+			if filter != "" {
+				f = mustParse(filter)
+			}
+
+			f, err := backend.rewrite(boundDn, f)
+			So(err, ShouldBeNil)
+			if f == nil {
+				return ""
+			}
+			return f.String()
+		}
+
+		Convey("Then the default filter is added for other clients", func() {
+			So(rewrite("cn=gitlab,ou=Apps,dc=example,dc=com", "(uid=alice)"), ShouldEqual, "(&(employeeType=staff)(uid=alice))")
+			So(rewrite("cn=gitlab,ou=Apps,dc=example,dc=com", ""), ShouldEqual, "(employeeType=staff)")
+		})
+
+		Convey("Then the filter is selected by the bound dn", func() {
+			So(rewrite("CN=admin, ou=apps,dc=example,dc=com", "(uid=alice)"), ShouldEqual, "(uid=alice)")
+			So(rewrite("cn=shop,ou=Partners,dc=example,dc=com", "(uid=alice)"), ShouldEqual, "(&(employeeType=partner)(uid=alice))")
+		})
+	})
+}
+
+func TestBackend_Rules(t *testing.T) {
+	Convey("Given a policy with rules", t, func() {
+		delegate := &testBackend{}
+		backend, err := NewBackend(delegate, &Config{
+			Rules: []Rule{
+				{Match: "(mail={mail})", Replace: "(|(mail={mail})(mailAlias={mail}))"},
+				{Match: "(userPassword={value})", Reject: true},
+				{Match: "(uid=*)", Reject: true},
+				{Match: "(cn=*{part}*)", Replace: "(cn={part}*)"},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		search := func(filter string) (ldap.Filter, error) {
+			_, err := backend.GetUsers(context.Background(), mustParse(filter))
+			return delegate.lastFilter, err
+		}
+
+		Convey("Then matching filters are replaced", func() {
+			f, err := search(`(&(objectClass=person)(MAIL=a\2ab@example.com))`)
+			So(err, ShouldBeNil)
+			So(f.String(), ShouldEqual, `(&(objectClass=person)(|(mail=a\2ab@example.com)(mailAlias=a\2ab@example.com)))`)
+
+			f, err = search("(cn=*smith*)")
+			So(err, ShouldBeNil)
+			So(f.String(), ShouldEqual, "(cn=smith*)")
+		})
+
+		Convey("Then other shapes are passed on", func() {
+			f, err := search("(cn=*smith*jones*)")
+			So(err, ShouldBeNil)
+			So(f.String(), ShouldEqual, "(cn=*smith*jones*)")
+		})
+
+		Convey("Then rejected filters don't reach the backend", func() {
+			delegate.lastFilter = nil
+
+			_, err := search("(|(uid=alice)(!(userPassword=secret)))")
+			So(err, ShouldEqual, pkg.ErrSearchRejected)
+
+			_, err = search("(uid=*)")
+			So(err, ShouldEqual, pkg.ErrSearchRejected)
+			So(delegate.lastFilter, ShouldBeNil)
+		})
+	})
+
+	Convey("Given invalid rules", t, func() {
+		_, err := NewBackend(&testBackend{}, &Config{Rules: []Rule{{Replace: "(uid=*)"}}})
+		So(err, ShouldEqual, errNoMatch)
+
+		_, err = NewBackend(&testBackend{}, &Config{Rules: []Rule{{Match: "(uid=*)"}}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewBackend(&testBackend{}, &Config{Rules: []Rule{{Match: "(uid={uid})", Replace: "(mail={mail})"}}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewBackend(&testBackend{}, &Config{Filter: "(uid=alice"})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewBackend(&testBackend{}, &Config{Clients: []Client{{Filter: "(uid=alice)"}}})
+		So(err, ShouldEqual, errNoClientDn)
+	})
+}
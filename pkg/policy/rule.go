@@ -0,0 +1,232 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/samuel/go-ldap/ldap"
+	"regexp"
+	"strings"
+)
+
+var (
+	errNoMatch            = errors.New("policy: a rule needs a match")
+	errNoAction           = errors.New("policy: a rule either replaces or rejects")
+	errUnknownPlaceholder = errors.New("policy: placeholder not part of the match")
+
+	placeholderRegex = regexp.MustCompile(`^\{([^{}]+)\}$`)
+	replaceRegex     = regexp.MustCompile(`\{([^{}]+)\}`)
+)
+
+// Rule matches a filter shape like (mail={mail}) and replaces it, e. g. with
+// (|(mail={mail})(mailAlias={mail})), or rejects the search. Attribute names
+// and values are compared case insensitive, a value consisting of a
+// placeholder matches any value.
+type Rule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	Reject  bool   `json:"reject"`
+}
+
+type rule struct {
+	match   ldap.Filter
+	replace ldap.Filter
+	reject  bool
+}
+
+func compileRule(config Rule) (*rule, error) {
+	if config.Match == "" {
+		return nil, errNoMatch
+	}
+	if config.Reject == (config.Replace != "") {
+		return nil, fmt.Errorf("%v: %s", errNoAction, config.Match)
+	}
+
+	r := &rule{reject: config.Reject}
+
+	var err error
+	if r.match, err = parseFilter(config.Match); err != nil {
+		return nil, err
+	}
+	if r.replace, err = parseFilter(config.Replace); err != nil {
+		return nil, err
+	}
+
+	placeholders := map[string]bool{}
+	for _, name := range replaceRegex.FindAllStringSubmatch(config.Match, -1) {
+		placeholders[name[1]] = true
+	}
+	for _, name := range replaceRegex.FindAllStringSubmatch(config.Replace, -1) {
+		if !placeholders[name[1]] {
+			return nil, fmt.Errorf("%v: %s", errUnknownPlaceholder, name[1])
+		}
+	}
+
+	return r, nil
+}
+
+func (r *rule) String() string {
+	if r.reject {
+		return fmt.Sprintf("reject %s", r.match)
+	}
+
+	return fmt.Sprintf("replace %s with %s", r.match, r.replace)
+}
+
+// matches compares the filter with the shape of the rule and returns the
+// values of the placeholders.
+func (r *rule) matches(f ldap.Filter) (map[string]string, bool) {
+	values := map[string]string{}
+	if !match(r.match, f, values) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+func match(pattern ldap.Filter, f ldap.Filter, values map[string]string) bool {
+	switch p := pattern.(type) {
+	case *ldap.AND:
+		g, ok := f.(*ldap.AND)
+		return ok && matchAll(p.Filters, g.Filters, values)
+	case *ldap.OR:
+		g, ok := f.(*ldap.OR)
+		return ok && matchAll(p.Filters, g.Filters, values)
+	case *ldap.NOT:
+		g, ok := f.(*ldap.NOT)
+		return ok && match(p.Filter, g.Filter, values)
+	case *ldap.EqualityMatch:
+		g, ok := f.(*ldap.EqualityMatch)
+		return ok && matchAssertion(p.Attribute, string(p.Value), g.Attribute, string(g.Value), values)
+	case *ldap.ApproxMatch:
+		g, ok := f.(*ldap.ApproxMatch)
+		return ok && matchAssertion(p.Attribute, string(p.Value), g.Attribute, string(g.Value), values)
+	case *ldap.GreaterOrEqual:
+		g, ok := f.(*ldap.GreaterOrEqual)
+		return ok && matchAssertion(p.Attribute, string(p.Value), g.Attribute, string(g.Value), values)
+	case *ldap.LessOrEqual:
+		g, ok := f.(*ldap.LessOrEqual)
+		return ok && matchAssertion(p.Attribute, string(p.Value), g.Attribute, string(g.Value), values)
+	case *ldap.Present:
+		g, ok := f.(*ldap.Present)
+		return ok && strings.EqualFold(p.Attribute, g.Attribute)
+	case *ldap.Substrings:
+		g, ok := f.(*ldap.Substrings)
+		if !ok || !strings.EqualFold(p.Attribute, g.Attribute) || len(p.Any) != len(g.Any) {
+			return false
+		}
+		if !matchValue(p.Initial, g.Initial, values) || !matchValue(p.Final, g.Final, values) {
+			return false
+		}
+		for i := range p.Any {
+			if !matchValue(p.Any[i], g.Any[i], values) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// matchAll matches the filters of an and or or in the given order
+func matchAll(patterns []ldap.Filter, filters []ldap.Filter, values map[string]string) bool {
+	if len(patterns) != len(filters) {
+		return false
+	}
+
+	for i := range patterns {
+		if !match(patterns[i], filters[i], values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchAssertion(patternAttribute, pattern, attribute, value string, values map[string]string) bool {
+	return strings.EqualFold(patternAttribute, attribute) && matchValue(pattern, value, values)
+}
+
+// matchValue compares the values or assigns the value to the placeholder. A
+// placeholder used more than once must match the same value.
+func matchValue(pattern, value string, values map[string]string) bool {
+	placeholder := placeholderRegex.FindStringSubmatch(pattern)
+	if placeholder == nil {
+		return strings.EqualFold(pattern, value)
+	}
+
+	if previous, ok := values[placeholder[1]]; ok {
+		return strings.EqualFold(previous, value)
+	}
+
+	values[placeholder[1]] = value
+	return true
+}
+
+// fill replaces the placeholders inside the assertion values of the filter.
+// The values aren't put into the filter string, so they can't change the
+// structure of the filter.
+func fill(f ldap.Filter, values map[string]string) ldap.Filter {
+	replace := func(s string) string {
+		return replaceRegex.ReplaceAllStringFunc(s, func(placeholder string) string {
+			return values[placeholder[1:len(placeholder)-1]]
+		})
+	}
+
+	switch f := f.(type) {
+	case *ldap.AND:
+		and := &ldap.AND{}
+		for _, filter := range f.Filters {
+			and.Filters = append(and.Filters, fill(filter, values))
+		}
+		return and
+	case *ldap.OR:
+		or := &ldap.OR{}
+		for _, filter := range f.Filters {
+			or.Filters = append(or.Filters, fill(filter, values))
+		}
+		return or
+	case *ldap.NOT:
+		return &ldap.NOT{Filter: fill(f.Filter, values)}
+	case *ldap.EqualityMatch:
+		return &ldap.EqualityMatch{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.ApproxMatch:
+		return &ldap.ApproxMatch{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.GreaterOrEqual:
+		return &ldap.GreaterOrEqual{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.LessOrEqual:
+		return &ldap.LessOrEqual{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.Substrings:
+		substrings := &ldap.Substrings{
+			Attribute: f.Attribute,
+			Initial:   replace(f.Initial),
+			Final:     replace(f.Final),
+		}
+		for _, any := range f.Any {
+			substrings.Any = append(substrings.Any, replace(any))
+		}
+		return substrings
+	default:
+		return f
+	}
+}
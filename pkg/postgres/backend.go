@@ -22,11 +22,12 @@ package postgres
 
 import (
 	"database/sql"
+	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"net/url"
 
 	"context"
-	"errors"
 	"fmt"
 	"github.com/gopenguin/ldap-proxy/pkg"
 	"github.com/gopenguin/ldap-proxy/pkg/log"
@@ -34,16 +35,30 @@ import (
 	"github.com/samuel/go-ldap/ldap"
 	sq "gopkg.in/Masterminds/squirrel.v1"
 	"regexp"
-	"strconv"
 	"strings"
 )
 
 type Backend struct {
-	db     *sql.DB
-	config *Config
+	db         *sql.DB
+	endpoints  *endpoints
+	config     *Config
+	dialect    *Dialect
+	statements sq.StatementBuilderType
 
 	userDnRegex *regexp.Regexp
 
+	// the quoted identifiers, the mapped columns are only validated
+	table          string
+	loginColumn    string
+	passwordColumn string
+	columns        []string
+
+	// the array columns and the joined tables of multi-valued attributes, the
+	// hidden columns are only selected for the joins
+	arrays map[string]bool
+	joins  []*join
+	hidden []string
+
 	colAttr map[string]string
 	attrCol map[string]string
 	cols    []string
@@ -51,35 +66,138 @@ type Backend struct {
 }
 
 var _ pkg.Backend = &Backend{}
+var _ pkg.ErrorAuthenticator = &Backend{}
+var _ pkg.Closer = &Backend{}
 
 type Config struct {
 	pkg.Config
+	Driver  string            `json:"driver"`
+	Dialect string            `json:"dialect"`
 	Url     string            `json:"url"`
 	Columns map[string]string `json:"columns"`
+
+	// the table or view of the users and the columns of the credentials
+	Table          string  `json:"table"`
+	LoginColumn    string  `json:"loginColumn"`
+	PasswordColumn string  `json:"passwordColumn"`
+	Queries        Queries `json:"queries"`
+
+	// store a new hash after a successful bind if the scheme or the cost of the
+	// stored one is outdated
+	Rehash bool `json:"rehash"`
+
+	// the array columns and the one-to-many tables of multi-valued attributes
+	Arrays []string `json:"arrays"`
+	Joins  []Join   `json:"joins"`
+
+	// the formats of columns overriding the conversion of their type
+	Formats map[string]string `json:"formats"`
+
+	// the object classes of all users if objectClass isn't mapped to a column
+	ObjectClasses []string `json:"objectClasses"`
+
+	// read replicas and the failover settings
+	Replicas            []string      `json:"replicas"`
+	HealthCheckInterval util.Duration `json:"healthCheckInterval"`
+	Retries             *int          `json:"retries"`
+	Backoff             util.Duration `json:"backoff"`
+}
+
+// the database/sql driver, postgres if none is configured
+func (config *Config) driver() string {
+	if config.Driver == "" {
+		return "postgres"
+	}
+
+	return config.Driver
+}
+
+func (config *Config) table() string {
+	if config.Table == "" {
+		return "users"
+	}
+
+	return config.Table
+}
+
+func (config *Config) loginColumn() string {
+	if config.LoginColumn == "" {
+		return "name"
+	}
+
+	return config.LoginColumn
+}
+
+func (config *Config) passwordColumn() string {
+	if config.PasswordColumn == "" {
+		return "password"
+	}
+
+	return config.PasswordColumn
 }
 
 func NewBackend(config *Config) (*Backend, error) {
-	parsedUrl, err := url.Parse(config.Url)
+	db, err := open(config, config.Url)
 	if err != nil {
 		return nil, err
 	}
 
-	db, err := sql.Open("postgres", parsedUrl.String())
+	replicas := []*sql.DB{}
+	for _, replicaUrl := range config.Replicas {
+		replica, err := open(config, replicaUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		replicas = append(replicas, replica)
+	}
+
+	return newBackend(config, db, replicas...)
+}
+
+func open(config *Config, dataSource string) (*sql.DB, error) {
+	if config.driver() == "postgres" {
+		parsedUrl, err := url.Parse(dataSource)
+		if err != nil {
+			return nil, err
+		}
+
+		dataSource = parsedUrl.String()
+	}
+
+	return sql.Open(config.driver(), dataSource)
+}
+
+// newBackend creates the backend with the primary database used for writes
+// and the replicas used for reads.
+func newBackend(config *Config, db *sql.DB, replicas ...*sql.DB) (*Backend, error) {
+	dialect, err := lookupDialect(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return newBackend(config, db)
-}
+	replicaEndpoints := make([]*endpoint, len(replicas))
+	for i, replica := range replicas {
+		name := fmt.Sprintf("replica-%d", i)
+		if i < len(config.Replicas) {
+			name = endpointName(config.Replicas[i], i)
+		}
+
+		replicaEndpoints[i] = &endpoint{name: name, db: replica}
+	}
+	primary := &endpoint{name: endpointName(config.Url, -1), db: db}
 
-func newBackend(config *Config, db *sql.DB) (*Backend, error) {
 	backend := Backend{
-		db:      db,
-		config:  config,
-		colAttr: make(map[string]string),
-		attrCol: make(map[string]string),
-		cols:    []string{},
-		attr:    make([]string, len(config.Columns)),
+		db:         db,
+		endpoints:  newEndpoints(config.Name, replicaEndpoints, primary, config),
+		config:     config,
+		dialect:    dialect,
+		statements: sq.StatementBuilder.PlaceholderFormat(dialect.Placeholder),
+		arrays:     make(map[string]bool),
+		colAttr:    make(map[string]string),
+		attrCol:    make(map[string]string),
+		cols:       []string{},
+		attr:       make([]string, len(config.Columns)),
 	}
 
 	for col, attr := range config.Columns {
@@ -93,32 +211,139 @@ func newBackend(config *Config, db *sql.DB) (*Backend, error) {
 		backend.attr[i] = backend.colAttr[col]
 	}
 
+	for _, col := range config.Arrays {
+		if !dialect.Arrays {
+			return nil, errArraysUnsupported
+		}
+		if _, ok := backend.colAttr[col]; !ok {
+			return nil, fmt.Errorf("%v: %s", errUnknownArrayColumn, col)
+		}
+
+		backend.arrays[col] = true
+	}
+
+	for col, format := range config.Formats {
+		if _, ok := formats[format]; !ok {
+			return nil, fmt.Errorf("%v: %s (%s)", errUnknownFormat, format, col)
+		}
+	}
+
+	err = backend.prepareQueries()
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.prepareJoins()
+	if err != nil {
+		return nil, err
+	}
+
+	err = backend.validateRehash()
+	if err != nil {
+		return nil, err
+	}
+
 	return &backend, nil
 }
 
+// prepareQueries quotes the configured identifiers and validates the query
+// templates.
+func (backend *Backend) prepareQueries() (err error) {
+	config := backend.config
+
+	backend.table, err = backend.dialect.quoteTable(config.table())
+	if err != nil {
+		return err
+	}
+	backend.loginColumn, err = backend.dialect.quote(config.loginColumn())
+	if err != nil {
+		return err
+	}
+	backend.passwordColumn, err = backend.dialect.quote(config.passwordColumn())
+	if err != nil {
+		return err
+	}
+
+	backend.columns = make([]string, len(backend.cols))
+	for i, col := range backend.cols {
+		backend.columns[i], err = validateIdentifier(col)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.Queries.Authenticate != "" {
+		err = validateTemplate(config.Queries.Authenticate, "username")
+		if err != nil {
+			return err
+		}
+	}
+	if config.Queries.Search != "" {
+		err = validateTemplate(config.Queries.Search, "where", "columns")
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (backend *Backend) Name() (name string) {
 	return backend.config.Name
 }
 
 func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
-	rows, err := backend.db.QueryContext(ctx, "SELECT password FROM users WHERE name = $1", username)
-	if err != nil {
-		return false
+	ok, err := backend.AuthenticateErr(ctx, username, password)
+	if err != nil && err != pkg.ErrUnknownUser {
+		log.Printf("sql backend: %v", err)
 	}
-	defer rows.Close()
 
-	if !rows.Next() {
-		return false
+	return ok
+}
+
+// AuthenticateErr returns an error if the query failed and
+// pkg.ErrUnknownUser if no row matches the username, wrong passwords are
+// rejections.
+func (backend *Backend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	query, args, err := backend.authenticateQuery(username)
+	if err != nil {
+		return false, err
 	}
+
 	var hashedPassword string
-	err = rows.Scan(&hashedPassword)
+	err = backend.endpoints.do(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, query, args...).Scan(&hashedPassword)
+	})
+	if err == sql.ErrNoRows {
+		return false, pkg.ErrUnknownUser
+	}
 	if err != nil {
-		return false
+		return false, err
 	}
 
 	log.Debugf("[auth] found user %s", username)
 
-	return util.VerifyPasswordCtx(ctx, hashedPassword, password)
+	if !util.VerifyPasswordCtx(ctx, hashedPassword, password) {
+		return false, nil
+	}
+
+	backend.rehash(ctx, username, hashedPassword, password)
+
+	return true, nil
+}
+
+func (backend *Backend) authenticateQuery(username string) (string, []interface{}, error) {
+	if backend.config.Queries.Authenticate != "" {
+		return backend.dialect.expand(backend.config.Queries.Authenticate, map[string]sq.Sqlizer{
+			"username": sq.Expr("?", username),
+		})
+	}
+
+	return backend.statements.
+		Select(backend.passwordColumn).
+		From(backend.table).
+		Where(sq.Eq{backend.loginColumn: username}).
+		ToSql()
 }
 
 func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
@@ -129,14 +354,51 @@ func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.Use
 
 	log.Debug(query)
 
-	rows, err := backend.db.QueryContext(ctx, query, args...)
+	var users []*pkg.User
+	err = backend.endpoints.do(ctx, func(db *sql.DB) error {
+		users, err = backend.queryUsers(ctx, db, query, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// columnName returns the configured name of a column of the result. The mapped
+// columns are selected unquoted, so the database may return them folded to
+// lower or upper case.
+func (backend *Backend) columnName(name string) string {
+	if _, ok := backend.colAttr[name]; ok {
+		return name
+	}
+
+	for _, col := range append(backend.cols, backend.hidden...) {
+		if strings.EqualFold(col, name) {
+			return col
+		}
+	}
+
+	return name
+}
+
+func (backend *Backend) queryUsers(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]*pkg.User, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	// the templates may return the columns in any order
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
 	users := []*pkg.User{}
-	columns := make([]interface{}, len(backend.cols))
+	references := []map[string]interface{}{}
+	columns := make([]interface{}, len(names))
 	var columnsP []interface{}
 	for i := range columns {
 		columnsP = append(columnsP, &columns[i])
@@ -150,135 +412,97 @@ func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.Use
 		user := &pkg.User{
 			Attributes: map[string][]string{},
 		}
+		reference := map[string]interface{}{}
 
 		for i, col := range columns {
-			if backend.attr[i] == backend.config.DNAttribute {
-				user.DN = col.(string)
+			name := backend.columnName(names[i])
+			reference[name] = col
+
+			attr, ok := backend.colAttr[name]
+			if !ok {
+				continue
+			}
+
+			if backend.arrays[name] {
+				values, err := arrayValues(col)
+				if err != nil {
+					return nil, fmt.Errorf("%v (%s)", err, name)
+				}
+				if len(values) > 0 {
+					user.Attributes[attr] = values
+				}
+				continue
 			}
-			switch col.(type) {
-			case string:
-				user.Attributes[backend.attr[i]] = []string{col.(string)}
-			case int64:
-				user.Attributes[backend.attr[i]] = []string{strconv.FormatInt(col.(int64), 10)}
-			default:
-				return nil, errors.New(fmt.Sprintf("postgres backend: unsupported column type %T (%s)", col, backend.cols[i]))
+
+			format := backend.config.Formats[name]
+			value, ok, err := columnValue(col, format)
+			if err != nil && format != "" {
+				// a single value not matching its format shouldn't fail the search
+				log.Printf("sql backend: ignoring the value of %s: %v", name, err)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%v (%s)", err, name)
 			}
+			if !ok {
+				// NULL values are missing attributes
+				continue
+			}
+
+			if attr == backend.config.DNAttribute {
+				user.DN = value
+			}
+			user.Attributes[attr] = []string{value}
 		}
 
 		users = append(users, user)
+		references = append(references, reference)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = backend.loadJoins(ctx, db, users, references)
+	if err != nil {
+		return nil, err
 	}
 
 	return users, nil
 }
 
 func (backend *Backend) Close() {
-	backend.db.Close()
+	backend.endpoints.close()
 }
 
 func (backend *Backend) createQuery(f ldap.Filter) (sql string, args []interface{}, err error) {
 	log.Debug("convert ldap filter to query")
-	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).
-		RunWith(backend.db)
-
-	query := psql.
-		Select(strings.Join(backend.cols, ", ")).
-		From("users")
 
+	var cond sq.Sqlizer
 	if f != nil {
-		cond, err := backend.createCondition(f)
+		cond, err = backend.createCondition(f)
 		if err != nil {
 			log.Debug(err)
 			return "", nil, err
 		}
-
-		query = query.Where(cond)
 	}
 
-	return query.ToSql()
-}
-
-func (backend *Backend) createCondition(f ldap.Filter) (cond sq.Sqlizer, err error) {
-	switch f.(type) {
-	case *ldap.AND:
-		a := f.(*ldap.AND)
-		log.Debug("START and")
-
-		var ret sq.And
-		for _, sa := range a.Filters {
-			cond, err := backend.createCondition(sa)
-			if err != nil {
-				return nil, err
-			}
-			ret = append(ret, cond)
+	if backend.config.Queries.Search != "" {
+		if cond == nil {
+			cond = toSqlBool(true)
 		}
 
-		log.Debug("END and")
-		return ret, nil
-
-	case *ldap.OR:
-		o := f.(*ldap.OR)
-
-		log.Debug("START or")
-
-		var ret sq.Or
-		for _, sa := range o.Filters {
-			cond, err := backend.createCondition(sa)
-			if err != nil {
-				return nil, err
-			}
-			ret = append(ret, cond)
-		}
-		log.Debug("END or")
-		return ret, nil
-
-	case *ldap.EqualityMatch:
-		e := f.(*ldap.EqualityMatch)
-
-		return backend.equalMatch(e.Attribute, string(e.Value))
-
-	case *ldap.ApproxMatch:
-		e := f.(*ldap.ApproxMatch)
-
-		return backend.equalMatch(e.Attribute, string(e.Value))
-
-	case *ldap.Present:
-		p := f.(*ldap.Present)
-
-		_, ok := backend.attrCol[p.Attribute]
-		return toSqlBool(strings.ToLower(p.Attribute) == "objectclass" || ok), nil
-
-	default:
-		return nil, errors.New("unsupported condition type")
-	}
-}
-
-func (backend *Backend) equalMatch(attr, value string) (sq.Sqlizer, error) {
-	log.Debugf("EQ: %s = %s", attr, value)
-	if value == "*" {
-		return toSqlBool(true), nil
+		return backend.dialect.expand(backend.config.Queries.Search, map[string]sq.Sqlizer{
+			"columns": sq.Expr(strings.Join(backend.columns, ", ")),
+			"where":   cond,
+		})
 	}
 
-	return sq.Eq{
-		backend.attrCol[attr]: value,
-	}, nil
-}
-
-func toSqlBool(value bool) sq.Sqlizer {
-	return &sqlBool{
-		value: value,
+	query := backend.statements.
+		Select(strings.Join(backend.columns, ", ")).
+		From(backend.table)
+	if cond != nil {
+		query = query.Where(cond)
 	}
-}
 
-type sqlBool struct {
-	value bool
-}
-
-var _ sq.Sqlizer = &sqlBool{}
-
-func (this sqlBool) ToSql() (string, []interface{}, error) {
-	if this.value {
-		return "TRUE", []interface{}{}, nil
-	} else {
-		return "FALSE", []interface{}{}, nil
-	}
+	return query.ToSql()
 }
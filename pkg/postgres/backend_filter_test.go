@@ -24,20 +24,29 @@ import (
 	"github.com/samuel/go-ldap/ldap"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
+	"time"
 )
 
 func TestCreateCondition(t *testing.T) {
 	testBackend := Backend{
+		config:  &Config{},
+		dialect: dialects["postgres"],
 		colAttr: map[string]string{
-			"cn": "cn",
-			"l":  "l",
+			"cn":         "cn",
+			"l":          "l",
+			"uid_number": "uidNumber",
+			"created":    "createTimestamp",
+			"shell":      "loginShell",
 		},
 		attrCol: map[string]string{
-			"cn": "cn",
-			"l":  "l",
+			"cn":              "cn",
+			"l":               "l",
+			"uidNumber":       "uid_number",
+			"createTimestamp": "created",
+			"loginShell":      "shell",
 		},
-		cols: []string{"cn", "l"},
-		attr: []string{"cn", "l"},
+		cols: []string{"cn", "l", "uid_number", "created", "shell"},
+		attr: []string{"cn", "l", "uidNumber", "createTimestamp", "loginShell"},
 	}
 
 	Convey("Given an equality test", t, func() {
@@ -51,7 +60,7 @@ func TestCreateCondition(t *testing.T) {
 			sql, params, _ := cond.ToSql()
 
 			So(err, ShouldBeNil)
-			So(sql, ShouldEqual, `cn = ?`)
+			So(sql, ShouldEqual, `(cn IS NOT NULL AND lower(cn) = lower(?))`)
 			So(params, ShouldHaveLength, 1)
 			So(params[0], ShouldEqual, "test")
 		})
@@ -62,12 +71,12 @@ func TestCreateCondition(t *testing.T) {
 			Attribute: "cn",
 		}
 
-		Convey("Expect a query with true", func() {
+		Convey("Expect a query excluding NULLs", func() {
 			cond, err := testBackend.createCondition(f)
 			sql, params, _ := cond.ToSql()
 
 			So(err, ShouldBeNil)
-			So(sql, ShouldEqual, "TRUE")
+			So(sql, ShouldEqual, `cn IS NOT NULL`)
 			So(params, ShouldHaveLength, 0)
 		})
 
@@ -104,7 +113,7 @@ func TestCreateCondition(t *testing.T) {
 			sql, params, _ := cond.ToSql()
 
 			So(err, ShouldBeNil)
-			So(sql, ShouldEqual, `(cn = ? AND l = ?)`)
+			So(sql, ShouldEqual, `((cn IS NOT NULL AND lower(cn) = lower(?)) AND (l IS NOT NULL AND lower(l) = lower(?)))`)
 			So(params, ShouldHaveLength, 2)
 			So(params[0], ShouldEqual, "test")
 			So(params[1], ShouldEqual, "UK")
@@ -130,10 +139,178 @@ func TestCreateCondition(t *testing.T) {
 			sql, params, _ := cond.ToSql()
 
 			So(err, ShouldBeNil)
-			So(sql, ShouldEqual, `(cn = ? OR l = ?)`)
+			So(sql, ShouldEqual, `((cn IS NOT NULL AND lower(cn) = lower(?)) OR (l IS NOT NULL AND lower(l) = lower(?)))`)
 			So(params, ShouldHaveLength, 2)
 			So(params[0], ShouldEqual, "test")
 			So(params[1], ShouldEqual, "UK")
 		})
 	})
+
+	Convey("Given a negation", t, func() {
+		cond, err := testBackend.createCondition(&ldap.NOT{Filter: &ldap.EqualityMatch{Attribute: "cn", Value: []byte("test")}})
+		sql, params, _ := cond.ToSql()
+
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, `NOT ((cn IS NOT NULL AND lower(cn) = lower(?)))`)
+		So(params, ShouldResemble, []interface{}{"test"})
+	})
+
+	Convey("Given substrings", t, func() {
+		cond, err := testBackend.createCondition(&ldap.Substrings{Attribute: "cn", Initial: "a_b", Any: []string{"50%"}, Final: "c!"})
+		sql, params, _ := cond.ToSql()
+
+		Convey("Expect a like query with escaped wildcards", func() {
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(cn IS NOT NULL AND lower(cn) LIKE lower(?) ESCAPE '!')`)
+			So(params, ShouldResemble, []interface{}{"a!_b%50!%%c!!"})
+		})
+	})
+
+	Convey("Given ordering matches", t, func() {
+		cond, err := testBackend.createCondition(&ldap.GreaterOrEqual{Attribute: "uidNumber", Value: []byte("42")})
+		sql, params, _ := cond.ToSql()
+
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, `(uid_number IS NOT NULL AND uid_number >= ?)`)
+		So(params, ShouldResemble, []interface{}{int64(42)})
+
+		cond, err = testBackend.createCondition(&ldap.LessOrEqual{Attribute: "createTimestamp", Value: []byte("20240102040405+0100")})
+		sql, params, _ = cond.ToSql()
+
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, `(created IS NOT NULL AND created <= ?)`)
+		So(params, ShouldResemble, []interface{}{time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)})
+
+		Convey("Then strings are compared by the ordering rule of the attribute", func() {
+			cond, err := testBackend.createCondition(&ldap.GreaterOrEqual{Attribute: "cn", Value: []byte("123")})
+			sql, params, _ := cond.ToSql()
+
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(cn IS NOT NULL AND lower(cn) >= lower(?))`)
+			So(params, ShouldResemble, []interface{}{"123"})
+
+			cond, err = testBackend.createCondition(&ldap.GreaterOrEqual{Attribute: "loginShell", Value: []byte("/bin/B")})
+			sql, params, _ = cond.ToSql()
+
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(shell IS NOT NULL AND shell >= ?)`)
+			So(params, ShouldResemble, []interface{}{"/bin/B"})
+		})
+
+		Convey("Then invalid values are undefined", func() {
+			cond, err := testBackend.createCondition(&ldap.GreaterOrEqual{Attribute: "uidNumber", Value: []byte("abc")})
+			sql, _, _ := cond.ToSql()
+
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, "NULL")
+		})
+	})
+
+	Convey("Given matches on a case exact attribute", t, func() {
+		cond, err := testBackend.createCondition(&ldap.EqualityMatch{Attribute: "loginShell", Value: []byte("/bin/bash")})
+		sql, _, _ := cond.ToSql()
+
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, `(shell IS NOT NULL AND shell = ?)`)
+
+		cond, err = testBackend.createCondition(&ldap.Substrings{Attribute: "loginShell", Final: "sh"})
+		sql, _, _ = cond.ToSql()
+
+		So(err, ShouldBeNil)
+		So(sql, ShouldEqual, `(shell IS NOT NULL AND shell LIKE ? ESCAPE '!')`)
+	})
+
+	Convey("Given conditions on unknown attributes", t, func() {
+		for _, f := range []ldap.Filter{
+			&ldap.EqualityMatch{Attribute: "gn", Value: []byte("test")},
+			&ldap.Substrings{Attribute: "gn", Initial: "test"},
+			&ldap.GreaterOrEqual{Attribute: "gn", Value: []byte("test")},
+		} {
+			cond, err := testBackend.createCondition(&ldap.NOT{Filter: f})
+			sql, _, _ := cond.ToSql()
+
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, "NOT (NULL)")
+		}
+	})
+
+	Convey("Given conditions on the object class", t, func() {
+		match := func(f ldap.Filter) string {
+			cond, err := testBackend.createCondition(f)
+			So(err, ShouldBeNil)
+			sql, _, _ := cond.ToSql()
+			return sql
+		}
+
+		So(match(&ldap.EqualityMatch{Attribute: "objectClass", Value: []byte("InetOrgPerson")}), ShouldEqual, "TRUE")
+		So(match(&ldap.EqualityMatch{Attribute: "objectClass", Value: []byte("posixAccount")}), ShouldEqual, "FALSE")
+		So(match(&ldap.Substrings{Attribute: "objectclass", Final: "person"}), ShouldEqual, "TRUE")
+
+		testBackend.config = &Config{ObjectClasses: []string{"posixAccount"}}
+		So(match(&ldap.EqualityMatch{Attribute: "objectClass", Value: []byte("posixAccount")}), ShouldEqual, "TRUE")
+		So(match(&ldap.EqualityMatch{Attribute: "objectClass", Value: []byte("person")}), ShouldEqual, "FALSE")
+		testBackend.config = &Config{}
+	})
+
+	Convey("Given conditions naming the attribute differently", t, func() {
+		for _, attribute := range []string{"CN", "commonName", "2.5.4.3"} {
+			cond, err := testBackend.createCondition(&ldap.EqualityMatch{Attribute: attribute, Value: []byte("test")})
+			sql, _, _ := cond.ToSql()
+
+			So(err, ShouldBeNil)
+			So(sql, ShouldEqual, `(cn IS NOT NULL AND lower(cn) = lower(?))`)
+		}
+	})
+}
+
+func TestCreateCondition_MultiValued(t *testing.T) {
+	testBackend := Backend{
+		config:  &Config{},
+		dialect: dialects["postgres"],
+		table:   `"users"`,
+		arrays:  map[string]bool{"phones": true},
+		joins: []*join{
+			{attribute: "mail", table: `"user_emails"`, key: `"user_id"`, column: `"email"`, references: `"id"`},
+		},
+		colAttr: map[string]string{"phones": "telephoneNumber"},
+		attrCol: map[string]string{"telephoneNumber": "phones"},
+	}
+
+	match := func(f ldap.Filter) (string, []interface{}) {
+		cond, err := testBackend.createCondition(f)
+		So(err, ShouldBeNil)
+		sql, args, err := cond.ToSql()
+		So(err, ShouldBeNil)
+		return sql, args
+	}
+
+	Convey("Given conditions on an array column", t, func() {
+		sql, args := match(&ldap.EqualityMatch{Attribute: "telephoneNumber", Value: []byte("+1 555")})
+		So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM unnest(phones) AS v WHERE lower(v) = lower(?))`)
+		So(args, ShouldResemble, []interface{}{"+1 555"})
+
+		sql, _ = match(&ldap.Substrings{Attribute: "telephoneNumber", Initial: "+1"})
+		So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM unnest(phones) AS v WHERE lower(v) LIKE lower(?) ESCAPE '!')`)
+
+		sql, _ = match(&ldap.Present{Attribute: "telephoneNumber"})
+		So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM unnest(phones) AS v WHERE v IS NOT NULL)`)
+	})
+
+	Convey("Given conditions on a joined table", t, func() {
+		sql, args := match(&ldap.EqualityMatch{Attribute: "mail", Value: []byte("a@example.com")})
+		So(sql, ShouldEqual, `EXISTS (SELECT 1 FROM "user_emails" WHERE "user_emails"."user_id" = "users"."id" AND lower("user_emails"."email") = lower(?))`)
+		So(args, ShouldResemble, []interface{}{"a@example.com"})
+
+		sql, _ = match(&ldap.NOT{Filter: &ldap.GreaterOrEqual{Attribute: "mail", Value: []byte("b")}})
+		So(sql, ShouldEqual, `NOT (EXISTS (SELECT 1 FROM "user_emails" WHERE "user_emails"."user_id" = "users"."id" AND "user_emails"."email" >= ?))`)
+	})
+
+	Convey("Given a postgres array", t, func() {
+		values, err := arrayValues([]byte(`{a,NULL,"b c"}`))
+
+		Convey("Then the values without NULLs are returned", func() {
+			So(err, ShouldBeNil)
+			So(values, ShouldResemble, []string{"a", "b c"})
+		})
+	})
 }
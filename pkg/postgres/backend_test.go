@@ -30,6 +30,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"testing"
+	"time"
 )
 
 func TestNewBackend(t *testing.T) {
@@ -67,7 +68,7 @@ func TestBackend_CreateUser(t *testing.T) {
 	Convey("Given some credentials and a database", t, backendWithMockedDatabase(func(backend *Backend, mock sqlmock.Sqlmock) {
 		Convey("When a new user is inserted", func() {
 			passwordCatcher := newArgumentCatcher()
-			mock.ExpectExec("INSERT INTO users").
+			mock.ExpectExec(`INSERT INTO "users" \("name","password"\)`).
 				WithArgs("userA", passwordCatcher.Catcher()).
 				WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -94,20 +95,27 @@ func TestBackend_Authenticate(t *testing.T) {
 		emptyRows := sqlmock.NewRows([]string{"password"})
 
 		Convey("User userA should be able to authenticate with 'test123'", func() {
-			mock.ExpectQuery("^SELECT (.+) FROM users WHERE name = \\$1$").WithArgs("userA").WillReturnRows(useraRows)
+			mock.ExpectQuery(`^SELECT "password" FROM "users" WHERE "name" = \$1$`).WithArgs("userA").WillReturnRows(useraRows)
 			So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
 		})
 		Convey("User userA should not be able to authenticate with 'wrongPassword'", func() {
-			mock.ExpectQuery("^SELECT (.+) FROM users WHERE name = \\$1$").WithArgs("userA").WillReturnRows(useraRows)
+			mock.ExpectQuery(`^SELECT "password" FROM "users" WHERE "name" = \$1$`).WithArgs("userA").WillReturnRows(useraRows)
 			So(backend.Authenticate(context.Background(), "userA", "wrongPassword"), ShouldBeFalse)
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
 		})
 		Convey("User userB should be able to authenticate with 'test123'", func() {
-			mock.ExpectQuery("^SELECT (.+) FROM users WHERE name = \\$1$").WithArgs("userB").WillReturnRows(emptyRows)
+			mock.ExpectQuery(`^SELECT "password" FROM "users" WHERE "name" = \$1$`).WithArgs("userB").WillReturnRows(emptyRows)
 			So(backend.Authenticate(context.Background(), "userB", "test123"), ShouldBeFalse)
 			So(mock.ExpectationsWereMet(), ShouldBeNil)
 		})
+		Convey("User userB should be reported as unknown", func() {
+			mock.ExpectQuery(`^SELECT "password" FROM "users" WHERE "name" = \$1$`).WithArgs("userB").WillReturnRows(emptyRows)
+			ok, err := backend.AuthenticateErr(context.Background(), "userB", "test123")
+			So(ok, ShouldBeFalse)
+			So(err, ShouldEqual, pkg.ErrUnknownUser)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
 	}))
 }
 
@@ -128,7 +136,7 @@ func TestBackend_GetUsers(t *testing.T) {
 		useraRows := sqlmock.NewRows(backend.cols).AddRow(row...)
 
 		Convey("When the users are requested", func() {
-			mock.ExpectQuery("^SELECT (.+) FROM users").WillReturnRows(useraRows)
+			mock.ExpectQuery(`^SELECT (.+) FROM "users"`).WillReturnRows(useraRows)
 			users, err := backend.GetUsers(context.Background(), nil)
 
 			Convey("Then userA will be returned", func() {
@@ -145,7 +153,7 @@ func TestBackend_GetUsers(t *testing.T) {
 			}
 
 			Convey("When the users are requested", func() {
-				mock.ExpectQuery("^SELECT (.+) FROM users WHERE \\(firstname = \\$1 AND lastname = \\$2\\)").WithArgs("a", "user").WillReturnRows(useraRows)
+				mock.ExpectQuery(`^SELECT (.+) FROM "users" WHERE \(\(firstname IS NOT NULL AND lower\(firstname\) = lower\(\$1\)\) AND \(lastname IS NOT NULL AND lower\(lastname\) = lower\(\$2\)\)\)`).WithArgs("a", "user").WillReturnRows(useraRows)
 				users, err := backend.GetUsers(context.Background(), filter)
 
 				Convey("Then userA will be returned", func() {
@@ -156,6 +164,191 @@ func TestBackend_GetUsers(t *testing.T) {
 	}))
 }
 
+func TestBackend_Queries(t *testing.T) {
+	Convey("Given a backend with a custom table and columns", t, func() {
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		backend, err := newBackend(&Config{
+			Table:          "auth.accounts",
+			LoginColumn:    "login",
+			PasswordColumn: "pw_hash",
+			Columns:        map[string]string{"login": "uid"},
+		}, db)
+		So(err, ShouldBeNil)
+
+		Convey("Then the identifiers are quoted", func() {
+			mock.ExpectQuery(`^SELECT "pw_hash" FROM "auth"."accounts" WHERE "login" = \$1$`).WithArgs("userA").
+				WillReturnRows(sqlmock.NewRows([]string{"pw_hash"}))
+			So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeFalse)
+
+			mock.ExpectQuery(`^SELECT login FROM "auth"."accounts" WHERE \(login IS NOT NULL AND lower\(login\) = lower\(\$1\)\)$`).WithArgs("userA").
+				WillReturnRows(sqlmock.NewRows([]string{"login"}).AddRow("userA"))
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("userA")})
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a backend with query templates", t, func() {
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		backend, err := newBackend(&Config{
+			Columns: map[string]string{"login": "uid", "mail": "mail"},
+			Queries: Queries{
+				Authenticate: "SELECT hash FROM accounts WHERE lower(login) = lower({username}) AND active",
+				Search:       "SELECT {columns}, 1 AS extra FROM accounts WHERE active AND {where}",
+			},
+		}, db)
+		So(err, ShouldBeNil)
+
+		Convey("Then the placeholders are replaced", func() {
+			mock.ExpectQuery(`^SELECT hash FROM accounts WHERE lower\(login\) = lower\(\$1\) AND active$`).WithArgs("userA").
+				WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny"))
+			So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+
+			mock.ExpectQuery(`^SELECT (login|mail), (login|mail), 1 AS extra FROM accounts WHERE active AND \(mail IS NOT NULL AND lower\(mail\) = lower\(\$1\)\)$`).WithArgs("a@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"extra", "mail", "login"}).AddRow(1, "a@example.com", "userA"))
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "mail", Value: []byte("a@example.com")})
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].Attributes, ShouldResemble, map[string][]string{"uid": {"userA"}, "mail": {"a@example.com"}})
+
+			mock.ExpectQuery(`WHERE active AND TRUE$`).WillReturnRows(sqlmock.NewRows([]string{"login"}))
+			_, err = backend.GetUsers(context.Background(), nil)
+			So(err, ShouldBeNil)
+
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given mixed case columns", t, func() {
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		backend, err := newBackend(&Config{
+			Config:  pkg.Config{DNAttribute: "uid"},
+			Columns: map[string]string{"userName": "uid", "firstName": "gn"},
+		}, db)
+		So(err, ShouldBeNil)
+
+		Convey("Then they are selected unquoted and mapped from the folded names", func() {
+			mock.ExpectQuery(`^SELECT (userName|firstName), (userName|firstName) FROM "users" WHERE \(firstName IS NOT NULL AND lower\(firstName\) = lower\(\$1\)\)$`).WithArgs("a").
+				WillReturnRows(sqlmock.NewRows([]string{"username", "firstname"}).AddRow("userA", "a"))
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "gn", Value: []byte("a")})
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "userA")
+			So(users[0].Attributes, ShouldResemble, map[string][]string{"uid": {"userA"}, "gn": {"a"}})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given invalid identifiers or templates", t, func() {
+		db, _, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		for _, config := range []*Config{
+			{Table: "users; DROP TABLE users"},
+			{Table: "a.b.c"},
+			{LoginColumn: `na"me`},
+			{Columns: map[string]string{"e mail": "mail"}},
+			{Queries: Queries{Authenticate: "SELECT password FROM users"}},
+			{Queries: Queries{Search: "SELECT {columns} FROM users WHERE {filter}"}},
+			{Formats: map[string]string{"email": "rot13"}},
+		} {
+			_, err := newBackend(config, db)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestBackend_ColumnTypes(t *testing.T) {
+	Convey("Given a backend with columns of different types", t, func() {
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		backend, err := newBackend(&Config{
+			Config: pkg.Config{
+				DNAttribute: "uid",
+			},
+			Columns: map[string]string{
+				"name":    "uid",
+				"email":   "mail",
+				"active":  "active",
+				"score":   "score",
+				"created": "createTimestamp",
+				"id":      "entryUUID",
+				"key":     "sshPublicKey",
+				"admin":   "admin",
+			},
+			Formats: map[string]string{
+				"id":    "uuid",
+				"key":   "base64",
+				"admin": "boolean",
+			},
+		}, db)
+		So(err, ShouldBeNil)
+
+		values := map[string]driver.Value{
+			"name":    "userA",
+			"email":   nil,
+			"active":  true,
+			"score":   1.5,
+			"created": time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+			"id":      []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+			"key":     []byte{0, 1, 2},
+			"admin":   int64(1),
+		}
+		row := make([]driver.Value, len(backend.cols))
+		for i, col := range backend.cols {
+			row[i] = values[col]
+		}
+
+		Convey("When the users are requested", func() {
+			mock.ExpectQuery(`^SELECT (.+) FROM "users"`).WillReturnRows(sqlmock.NewRows(backend.cols).AddRow(row...))
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			Convey("Then the values are converted and NULLs are missing", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].Attributes, ShouldResemble, map[string][]string{
+					"uid":             {"userA"},
+					"active":          {"TRUE"},
+					"score":           {"1.5"},
+					"createTimestamp": {"20240102020405Z"},
+					"entryUUID":       {"123e4567-e89b-12d3-a456-426614174000"},
+					"sshPublicKey":    {"AAEC"},
+					"admin":           {"TRUE"},
+				})
+			})
+		})
+
+		Convey("When a value doesn't match its format", func() {
+			values["id"] = []byte{0x12, 0x3e}
+			values["admin"] = []byte("maybe")
+			for i, col := range backend.cols {
+				row[i] = values[col]
+			}
+
+			mock.ExpectQuery(`^SELECT (.+) FROM "users"`).WillReturnRows(sqlmock.NewRows(backend.cols).AddRow(row...))
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			Convey("Then the value is missing and the search succeeds", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].Attributes, ShouldNotContainKey, "entryUUID")
+				So(users[0].Attributes, ShouldNotContainKey, "admin")
+				So(users[0].Attributes["uid"], ShouldResemble, []string{"userA"})
+				So(users[0].Attributes["sshPublicKey"], ShouldResemble, []string{"AAEC"})
+			})
+		})
+	})
+}
+
 func backendWithMockedDatabase(test func(backend *Backend, mock sqlmock.Sqlmock)) func() {
 	return func() {
 		db, mock, err := sqlmock.New()
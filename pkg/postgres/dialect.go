@@ -0,0 +1,85 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"errors"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+)
+
+var (
+	errNoDriver       = errors.New("sql backend: no driver configured")
+	errUnknownDialect = errors.New("sql backend: unknown dialect")
+)
+
+// A Dialect contains the database specific parts of the sql backend. The
+// CreateTable statement is formatted with the table, the login and the
+// password column. Arrays reports whether array columns are supported.
+type Dialect struct {
+	Name            string
+	Placeholder     sq.PlaceholderFormat
+	IdentifierQuote string
+	Arrays          bool
+	CreateTable     string
+}
+
+var dialects = map[string]*Dialect{
+	"postgres": {
+		Name:            "postgres",
+		Placeholder:     sq.Dollar,
+		IdentifierQuote: `"`,
+		Arrays:          true,
+		CreateTable:     "CREATE TABLE %s (id SERIAL PRIMARY KEY, %s VARCHAR(256) NOT NULL UNIQUE, %s VARCHAR(1024) NOT NULL, email VARCHAR(256), firstname VARCHAR(256), lastname VARCHAR(256))",
+	},
+	"mysql": {
+		Name:            "mysql",
+		Placeholder:     sq.Question,
+		IdentifierQuote: "`",
+		CreateTable:     "CREATE TABLE %s (id INTEGER AUTO_INCREMENT PRIMARY KEY, %s VARCHAR(256) NOT NULL UNIQUE, %s VARCHAR(1024) NOT NULL, email VARCHAR(256), firstname VARCHAR(256), lastname VARCHAR(256))",
+	},
+	"sqlite": {
+		Name:            "sqlite",
+		Placeholder:     sq.Question,
+		IdentifierQuote: `"`,
+		CreateTable:     "CREATE TABLE %s (id INTEGER PRIMARY KEY AUTOINCREMENT, %s VARCHAR(256) NOT NULL UNIQUE, %s VARCHAR(1024) NOT NULL, email VARCHAR(256), firstname VARCHAR(256), lastname VARCHAR(256))",
+	},
+}
+
+// the dialects used if only the driver is configured
+var driverDialects = map[string]string{
+	"postgres": "postgres",
+	"mysql":    "mysql",
+	"sqlite3":  "sqlite",
+}
+
+func lookupDialect(config *Config) (*Dialect, error) {
+	name := config.Dialect
+	if name == "" {
+		name = driverDialects[config.driver()]
+	}
+
+	dialect, ok := dialects[name]
+	if !ok {
+		return nil, errUnknownDialect
+	}
+
+	return dialect, nil
+}
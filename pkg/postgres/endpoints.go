@@ -0,0 +1,260 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRetries             = 2
+	defaultBackoff             = 100 * time.Millisecond
+	defaultHealthCheckInterval = 10 * time.Second
+
+	pingTimeout = 2 * time.Second
+)
+
+var (
+	errNoEndpoint = errors.New("sql backend: no endpoint available")
+
+	endpointUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "postgres",
+		Name:      "endpoint_up",
+		Help:      "Whether the database endpoint is healthy",
+	}, []string{"backend", "endpoint"})
+
+	endpointErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "postgres",
+		Name:      "endpoint_errors_total",
+		Help:      "The total number of failed requests to the database endpoint",
+	}, []string{"backend", "endpoint"})
+)
+
+func init() {
+	prometheus.MustRegister(endpointUp)
+	prometheus.MustRegister(endpointErrorsTotal)
+}
+
+type endpoint struct {
+	name    string
+	db      *sql.DB
+	healthy int32
+}
+
+func (e *endpoint) isHealthy() bool {
+	return atomic.LoadInt32(&e.healthy) == 1
+}
+
+// endpoints distributes the reads over the replicas and the primary. The
+// healthy replicas are used in turn, the primary only if no replica is
+// healthy. An endpoint is marked unhealthy if a request fails and it doesn't
+// respond to a ping, a successful request or the health check marks it healthy
+// again.
+type endpoints struct {
+	backend   string
+	endpoints []*endpoint // the replicas followed by the primary
+	retries   int
+	backoff   time.Duration
+
+	next uint32
+	done chan struct{}
+	once sync.Once
+}
+
+func newEndpoints(backend string, replicas []*endpoint, primary *endpoint, config *Config) *endpoints {
+	set := &endpoints{
+		backend:   backend,
+		endpoints: append(replicas, primary),
+		retries:   defaultRetries,
+		backoff:   config.Backoff.Or(defaultBackoff),
+		done:      make(chan struct{}),
+	}
+	if config.Retries != nil {
+		set.retries = *config.Retries
+	}
+
+	for _, e := range set.endpoints {
+		set.setHealthy(e, true)
+	}
+
+	go set.healthCheck(config.HealthCheckInterval.Or(defaultHealthCheckInterval))
+
+	return set
+}
+
+// do runs the request on the first healthy endpoint and fails over to the
+// next one if the endpoint is down. All endpoints are tried again after a
+// backoff until the retries are exhausted.
+func (set *endpoints) do(ctx context.Context, request func(db *sql.DB) error) error {
+	backoff := set.backoff
+	err := errNoEndpoint
+
+	for try := 0; try <= set.retries; try++ {
+		if try > 0 {
+			log.Debugf("sql backend: retrying in %v: %v", backoff, err)
+
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		for _, e := range set.candidates() {
+			err = request(e.db)
+			if err == nil || err == sql.ErrNoRows {
+				set.up(e)
+				return err
+			}
+			if ctx.Err() != nil {
+				return err
+			}
+
+			endpointErrorsTotal.With(set.labels(e)).Inc()
+			if set.ping(ctx, e) == nil {
+				// the endpoint is fine, the request itself failed
+				set.up(e)
+				return err
+			}
+
+			log.Printf("sql backend: endpoint %s of %s is down: %v", e.name, set.backend, err)
+			set.setHealthy(e, false)
+		}
+	}
+
+	return err
+}
+
+// candidates returns the healthy replicas beginning with the next one in turn
+// and the primary. All endpoints are returned if none is healthy.
+func (set *endpoints) candidates() []*endpoint {
+	replicas := set.endpoints[:len(set.endpoints)-1]
+	primary := set.endpoints[len(set.endpoints)-1]
+
+	candidates := []*endpoint{}
+	if len(replicas) > 0 {
+		start := int(atomic.AddUint32(&set.next, 1)) % len(replicas)
+		for i := range replicas {
+			if e := replicas[(start+i)%len(replicas)]; e.isHealthy() {
+				candidates = append(candidates, e)
+			}
+		}
+	}
+	if primary.isHealthy() {
+		candidates = append(candidates, primary)
+	}
+
+	if len(candidates) == 0 {
+		return set.endpoints
+	}
+
+	return candidates
+}
+
+func (set *endpoints) ping(ctx context.Context, e *endpoint) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	return e.db.PingContext(ctx)
+}
+
+func (set *endpoints) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			set.check()
+		case <-set.done:
+			return
+		}
+	}
+}
+
+// check pings all endpoints and updates their health
+func (set *endpoints) check() {
+	for _, e := range set.endpoints {
+		err := set.ping(context.Background(), e)
+		if err != nil && e.isHealthy() {
+			log.Printf("sql backend: endpoint %s of %s is down: %v", e.name, set.backend, err)
+		} else if err == nil && !e.isHealthy() {
+			log.Printf("sql backend: endpoint %s of %s is up again", e.name, set.backend)
+		}
+
+		set.setHealthy(e, err == nil)
+	}
+}
+
+// up marks the endpoint healthy after it answered a request
+func (set *endpoints) up(e *endpoint) {
+	if !e.isHealthy() {
+		log.Printf("sql backend: endpoint %s of %s is up again", e.name, set.backend)
+		set.setHealthy(e, true)
+	}
+}
+
+func (set *endpoints) setHealthy(e *endpoint, healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&e.healthy, 1)
+		endpointUp.With(set.labels(e)).Set(1)
+	} else {
+		atomic.StoreInt32(&e.healthy, 0)
+		endpointUp.With(set.labels(e)).Set(0)
+	}
+}
+
+func (set *endpoints) labels(e *endpoint) prometheus.Labels {
+	return prometheus.Labels{"backend": set.backend, "endpoint": e.name}
+}
+
+func (set *endpoints) close() {
+	set.once.Do(func() {
+		close(set.done)
+		for _, e := range set.endpoints {
+			e.db.Close()
+		}
+	})
+}
+
+// endpointName returns the host of an url or the position of the endpoint for
+// other data sources. The credentials must not show up in the metrics.
+func endpointName(dataSource string, i int) string {
+	parsedUrl, err := url.Parse(dataSource)
+	if err == nil && parsedUrl.Host != "" {
+		return parsedUrl.Host
+	}
+
+	if i < 0 {
+		return "primary"
+	}
+	return fmt.Sprintf("replica-%d", i)
+}
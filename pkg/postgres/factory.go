@@ -53,3 +53,39 @@ func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err erro
 
 	return
 }
+
+type sqlBackendFactory struct{}
+
+var _ pkg.BackendFactory = &sqlBackendFactory{}
+
+// NewSqlFactory creates the factory for the generic sql backend which uses
+// the configured database/sql driver and dialect.
+func NewSqlFactory() (factory pkg.BackendFactory) {
+	return &sqlBackendFactory{}
+}
+
+func (sqlBackendFactory) Name() (name string) {
+	return "sql"
+}
+
+func (sqlBackendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (sqlBackendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	if config.Driver == "" {
+		return nil, errNoDriver
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
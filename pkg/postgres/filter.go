@@ -0,0 +1,460 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"github.com/samuel/go-ldap/ldap"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+	"strconv"
+	"strings"
+)
+
+var (
+	errUnsupportedFilter = errors.New("unsupported condition type")
+
+	// the object classes of the users if objectClass isn't a column
+	defaultObjectClasses = []string{"top", "person", "organizationalPerson", "inetOrgPerson"}
+)
+
+// the character escaping the wildcards of like patterns
+const likeEscape = "!"
+
+// createCondition translates the ldap filter into a sql condition. Conditions
+// on attributes without column are undefined and translated to NULL, which
+// behaves like undefined in the three-valued logic of ldap filters. Conditions
+// on NULL columns are FALSE, as the attribute is missing.
+func (backend *Backend) createCondition(f ldap.Filter) (cond sq.Sqlizer, err error) {
+	switch f.(type) {
+	case *ldap.AND:
+		a := f.(*ldap.AND)
+		log.Debug("START and")
+
+		var ret sq.And
+		for _, sa := range a.Filters {
+			cond, err := backend.createCondition(sa)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, cond)
+		}
+
+		log.Debug("END and")
+		return ret, nil
+
+	case *ldap.OR:
+		o := f.(*ldap.OR)
+
+		log.Debug("START or")
+
+		var ret sq.Or
+		for _, sa := range o.Filters {
+			cond, err := backend.createCondition(sa)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, cond)
+		}
+		log.Debug("END or")
+		return ret, nil
+
+	case *ldap.NOT:
+		n := f.(*ldap.NOT)
+
+		cond, err := backend.createCondition(n.Filter)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlNot{cond: cond}, nil
+
+	case *ldap.EqualityMatch:
+		e := f.(*ldap.EqualityMatch)
+
+		return backend.equalMatch(e.Attribute, string(e.Value))
+
+	case *ldap.ApproxMatch:
+		e := f.(*ldap.ApproxMatch)
+
+		// the equality matching rule is the approximate one as well
+		return backend.equalMatch(e.Attribute, string(e.Value))
+
+	case *ldap.Substrings:
+		s := f.(*ldap.Substrings)
+
+		return backend.likeMatch(s.Attribute, substringsPattern(s), func(value string) bool {
+			return schema.Default.MatchSubstrings(s.Attribute, value, s.Initial, s.Any, s.Final)
+		})
+
+	case *ldap.GreaterOrEqual:
+		e := f.(*ldap.GreaterOrEqual)
+
+		return backend.orderingMatch(e.Attribute, ">=", string(e.Value))
+
+	case *ldap.LessOrEqual:
+		e := f.(*ldap.LessOrEqual)
+
+		return backend.orderingMatch(e.Attribute, "<=", string(e.Value))
+
+	case *ldap.Present:
+		p := f.(*ldap.Present)
+
+		return backend.presentMatch(p.Attribute), nil
+
+	default:
+		return nil, errUnsupportedFilter
+	}
+}
+
+// equalMatch compares the values by the equality matching rule of the
+// attribute
+func (backend *Backend) equalMatch(attr, value string) (sq.Sqlizer, error) {
+	log.Debugf("EQ: %s = %s", attr, value)
+	if value == "*" {
+		return toSqlBool(true), nil
+	}
+
+	if backend.isObjectClass(attr) {
+		return backend.objectClassMatch(func(objectClass string) bool {
+			return schema.Default.Equal(attr, objectClass, value)
+		}), nil
+	}
+
+	c, ok := newComparison(schema.Default.Lookup(attr).Equality, value)
+	if !ok {
+		return sqlNull{}, nil
+	}
+
+	if col, ok := backend.column(attr); ok && backend.isArray(attr) && !c.ignoreCase {
+		return &sqlDefined{column: col, cond: sq.Expr("? = ANY("+col+")", c.value)}, nil
+	}
+
+	cond, ok := backend.valueCondition(attr, func(col string) sq.Sqlizer {
+		return c.sql(col, "=")
+	})
+	if !ok {
+		return sqlNull{}, nil
+	}
+
+	return cond, nil
+}
+
+// likeMatch compares the column with the pattern, the case is ignored if the
+// substrings matching rule of the attribute does. The object classes are
+// compared with the function instead.
+func (backend *Backend) likeMatch(attr, pattern string, match func(string) bool) (sq.Sqlizer, error) {
+	if backend.isObjectClass(attr) {
+		return backend.objectClassMatch(match), nil
+	}
+
+	c, ok := newComparison(schema.Default.Lookup(attr).Substr, pattern)
+	if !ok {
+		return sqlNull{}, nil
+	}
+
+	cond, ok := backend.valueCondition(attr, func(col string) sq.Sqlizer {
+		return c.like(col)
+	})
+	if !ok {
+		return sqlNull{}, nil
+	}
+
+	return cond, nil
+}
+
+// orderingMatch compares the values by the ordering matching rule of the
+// attribute. Without ordering rule the condition is undefined.
+func (backend *Backend) orderingMatch(attr, operator, value string) (sq.Sqlizer, error) {
+	c, ok := newComparison(schema.Default.Lookup(attr).Ordering, value)
+	if !ok {
+		return sqlNull{}, nil
+	}
+
+	cond, ok := backend.valueCondition(attr, func(col string) sq.Sqlizer {
+		return c.sql(col, operator)
+	})
+	if !ok {
+		return sqlNull{}, nil
+	}
+
+	return cond, nil
+}
+
+// presentMatch checks that the attribute has a value, NULLs are missing
+// values.
+func (backend *Backend) presentMatch(attr string) sq.Sqlizer {
+	if col, ok := backend.singleColumn(attr); ok {
+		return sq.Expr(col + " IS NOT NULL")
+	}
+
+	cond, ok := backend.valueCondition(attr, func(col string) sq.Sqlizer {
+		return sq.Expr(col + " IS NOT NULL")
+	})
+	if !ok {
+		return toSqlBool(backend.isObjectClass(attr))
+	}
+
+	return cond
+}
+
+// valueCondition creates the condition on the values of the attribute with the
+// function creating the condition on a single value. The values of arrays and
+// joined tables match if any value matches, the condition on a single column is
+// FALSE if the column is NULL.
+func (backend *Backend) valueCondition(attr string, match func(col string) sq.Sqlizer) (sq.Sqlizer, bool) {
+	if col, ok := backend.singleColumn(attr); ok {
+		return &sqlDefined{column: col, cond: match(col)}, true
+	}
+
+	if j, ok := backend.join(attr); ok {
+		return &sqlExists{
+			query: "SELECT 1 FROM " + j.table + " WHERE " + j.table + "." + j.key + " = " + backend.table + "." + j.references + " AND ",
+			cond:  match(j.table + "." + j.column),
+		}, true
+	}
+
+	col, ok := backend.column(attr)
+	if !ok {
+		return nil, false
+	}
+
+	return &sqlExists{
+		query: "SELECT 1 FROM unnest(" + col + ") AS v WHERE ",
+		cond:  match("v"),
+	}, true
+}
+
+// singleColumn returns the column of an attribute with a single value, which
+// is neither an array nor joined.
+func (backend *Backend) singleColumn(attr string) (string, bool) {
+	if _, ok := backend.join(attr); ok {
+		return "", false
+	}
+
+	col, ok := backend.column(attr)
+	if !ok || backend.isArray(attr) {
+		return "", false
+	}
+
+	return col, true
+}
+
+func (backend *Backend) isArray(attr string) bool {
+	col, ok := backend.attributeColumn(attr)
+	return ok && backend.arrays[col]
+}
+
+func (backend *Backend) objectClassMatch(match func(string) bool) sq.Sqlizer {
+	for _, objectClass := range backend.objectClasses() {
+		if match(objectClass) {
+			return toSqlBool(true)
+		}
+	}
+
+	return toSqlBool(false)
+}
+
+// isObjectClass reports whether the attribute is the objectClass attribute
+// served from the configuration instead of a column.
+func (backend *Backend) isObjectClass(attr string) bool {
+	_, ok := backend.attributeColumn(attr)
+	return !ok && schema.Default.Key(attr) == "objectclass"
+}
+
+func (backend *Backend) objectClasses() []string {
+	if backend.config.ObjectClasses == nil {
+		return defaultObjectClasses
+	}
+
+	return backend.config.ObjectClasses
+}
+
+// column returns the column of the attribute, the mapped columns are used
+// unquoted
+func (backend *Backend) column(attr string) (string, bool) {
+	return backend.attributeColumn(attr)
+}
+
+// attributeColumn returns the column of the attribute, the attribute names are
+// compared by the schema
+func (backend *Backend) attributeColumn(attr string) (string, bool) {
+	if col, ok := backend.attrCol[attr]; ok {
+		return col, true
+	}
+
+	key := schema.Default.Key(attr)
+	for a, col := range backend.attrCol {
+		if schema.Default.Key(a) == key {
+			return col, true
+		}
+	}
+
+	return "", false
+}
+
+// comparison compares the column with the assertion value converted for a
+// matching rule
+type comparison struct {
+	value      interface{}
+	ignoreCase bool
+}
+
+// newComparison converts the assertion value for the matching rule. Integers
+// and generalized times are compared by their value, strings either exactly or
+// ignoring the case. The comparison is undefined without rule or if the value
+// is invalid for the rule.
+func newComparison(rule string, value string) (comparison, bool) {
+	switch strings.ToLower(rule) {
+	case "":
+		return comparison{}, false
+	case "integermatch", "integerorderingmatch":
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return comparison{}, false
+		}
+		return comparison{value: i}, true
+	case "generalizedtimematch", "generalizedtimeorderingmatch":
+		t, ok := schema.ParseGeneralizedTime(value)
+		if !ok {
+			return comparison{}, false
+		}
+		return comparison{value: t.UTC()}, true
+	case "caseignorematch", "caseignoreorderingmatch", "caseignoresubstringsmatch", "distinguishednamematch",
+		"telephonenumbermatch", "telephonenumbersubstringsmatch":
+		return comparison{value: value, ignoreCase: true}, true
+	default:
+		return comparison{value: value}, true
+	}
+}
+
+// sql compares the column with the operator
+func (c comparison) sql(col string, operator string) sq.Sqlizer {
+	return sq.Expr(c.expr(col, operator), c.value)
+}
+
+// like matches the column with the value as like pattern
+func (c comparison) like(col string) sq.Sqlizer {
+	return sq.Expr(c.expr(col, "LIKE")+" ESCAPE '"+likeEscape+"'", c.value)
+}
+
+func (c comparison) expr(col string, operator string) string {
+	if c.ignoreCase {
+		return "lower(" + col + ") " + operator + " lower(?)"
+	}
+
+	return col + " " + operator + " ?"
+}
+
+// escapeLike escapes the wildcards of like patterns
+func escapeLike(value string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(value)
+}
+
+func substringsPattern(s *ldap.Substrings) string {
+	pattern := escapeLike(s.Initial) + "%"
+	for _, any := range s.Any {
+		pattern += escapeLike(any) + "%"
+	}
+
+	return pattern + escapeLike(s.Final)
+}
+
+func toSqlBool(value bool) sq.Sqlizer {
+	return &sqlBool{
+		value: value,
+	}
+}
+
+type sqlBool struct {
+	value bool
+}
+
+var _ sq.Sqlizer = &sqlBool{}
+
+func (this sqlBool) ToSql() (string, []interface{}, error) {
+	if this.value {
+		return "TRUE", []interface{}{}, nil
+	} else {
+		return "FALSE", []interface{}{}, nil
+	}
+}
+
+// sqlNull is the undefined result of a condition. It excludes the row like
+// FALSE, but stays undefined if negated.
+type sqlNull struct{}
+
+var _ sq.Sqlizer = sqlNull{}
+
+func (sqlNull) ToSql() (string, []interface{}, error) {
+	return "NULL", []interface{}{}, nil
+}
+
+type sqlNot struct {
+	cond sq.Sqlizer
+}
+
+var _ sq.Sqlizer = &sqlNot{}
+
+func (this sqlNot) ToSql() (string, []interface{}, error) {
+	sql, args, err := this.cond.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "NOT (" + sql + ")", args, nil
+}
+
+// sqlDefined is FALSE if the column is NULL and the condition otherwise. A
+// condition on NULL is NULL in sql, which would stay NULL if negated instead of
+// turning TRUE like a condition on a missing attribute.
+type sqlDefined struct {
+	column string
+	cond   sq.Sqlizer
+}
+
+var _ sq.Sqlizer = &sqlDefined{}
+
+func (this sqlDefined) ToSql() (string, []interface{}, error) {
+	sql, args, err := this.cond.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "(" + this.column + " IS NOT NULL AND " + sql + ")", args, nil
+}
+
+// sqlExists checks that the query returns a row for the condition
+type sqlExists struct {
+	query string
+	cond  sq.Sqlizer
+}
+
+var _ sq.Sqlizer = &sqlExists{}
+
+func (this sqlExists) ToSql() (string, []interface{}, error) {
+	sql, args, err := this.cond.ToSql()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return "EXISTS (" + this.query + sql + ")", args, nil
+}
@@ -0,0 +1,231 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"github.com/lib/pq"
+	"strings"
+)
+
+var (
+	errArraysUnsupported  = errors.New("sql backend: array columns are not supported by the dialect")
+	errUnknownArrayColumn = errors.New("sql backend: array column is not mapped to an attribute")
+	errInvalidJoin        = errors.New("sql backend: join without table, key, column or attribute")
+
+	// the number of users whose values are loaded by a single query
+	joinBatchSize = 500
+)
+
+// Join maps the rows of a one-to-many table to the values of a multi-valued
+// attribute. The key column of the table references the column of the users
+// table, the login column by default.
+type Join struct {
+	Table      string `json:"table"`
+	Key        string `json:"key"`
+	References string `json:"references"`
+	Column     string `json:"column"`
+	Attribute  string `json:"attribute"`
+}
+
+// join is a configured join with quoted identifiers
+type join struct {
+	attribute string
+
+	table      string
+	key        string
+	column     string
+	references string
+
+	// the unquoted references column as returned by the search query
+	referencesName string
+}
+
+func (backend *Backend) prepareJoins() error {
+	backend.joins = make([]*join, len(backend.config.Joins))
+
+	for i, config := range backend.config.Joins {
+		if config.Table == "" || config.Key == "" || config.Column == "" || config.Attribute == "" {
+			return errInvalidJoin
+		}
+
+		references := config.References
+		if references == "" {
+			references = backend.config.loginColumn()
+		}
+
+		j := &join{attribute: config.Attribute, referencesName: references}
+
+		var err error
+		if j.table, err = backend.dialect.quoteTable(config.Table); err != nil {
+			return err
+		}
+		if j.key, err = backend.dialect.quote(config.Key); err != nil {
+			return err
+		}
+		if j.column, err = backend.dialect.quote(config.Column); err != nil {
+			return err
+		}
+		if j.references, err = backend.dialect.quote(references); err != nil {
+			return err
+		}
+
+		backend.joins[i] = j
+
+		// the referenced column is selected even if it isn't mapped
+		if !backend.selected(references) {
+			backend.columns = append(backend.columns, j.references)
+			backend.hidden = append(backend.hidden, references)
+		}
+	}
+
+	return nil
+}
+
+// selected reports whether the search query selects the column
+func (backend *Backend) selected(column string) bool {
+	for _, col := range append(backend.cols, backend.hidden...) {
+		if col == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// join returns the join of the attribute
+func (backend *Backend) join(attr string) (*join, bool) {
+	for _, j := range backend.joins {
+		if schema.Default.Key(j.attribute) == schema.Default.Key(attr) {
+			return j, true
+		}
+	}
+
+	return nil, false
+}
+
+// loadJoins adds the values of the joined tables to the users. The references
+// hold the value of the referenced column of each user.
+func (backend *Backend) loadJoins(ctx context.Context, db *sql.DB, users []*pkg.User, references []map[string]interface{}) error {
+	for _, j := range backend.joins {
+		for start := 0; start < len(users); start += joinBatchSize {
+			end := start + joinBatchSize
+			if end > len(users) {
+				end = len(users)
+			}
+
+			err := backend.loadJoin(ctx, db, j, users[start:end], references[start:end])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (backend *Backend) loadJoin(ctx context.Context, db *sql.DB, j *join, users []*pkg.User, references []map[string]interface{}) error {
+	usersByKey := map[string][]*pkg.User{}
+	var keys []interface{}
+
+	for i, user := range users {
+		reference := references[i][j.referencesName]
+		if reference == nil {
+			continue
+		}
+
+		key, _, err := columnValue(reference, "")
+		if err != nil {
+			return err
+		}
+
+		if _, ok := usersByKey[key]; !ok {
+			keys = append(keys, reference)
+		}
+		usersByKey[key] = append(usersByKey[key], user)
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	query, args, err := backend.statements.
+		Select(j.table+"."+j.key+", "+j.table+"."+j.column).
+		From(j.table).
+		Where(j.table+"."+j.key+" IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")+")", keys...).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value interface{}
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		k, _, err := columnValue(key, "")
+		if err != nil {
+			return err
+		}
+		v, ok, err := columnValue(value, "")
+		if err != nil {
+			return fmt.Errorf("%v (%s)", err, j.attribute)
+		}
+		if !ok {
+			continue
+		}
+
+		for _, user := range usersByKey[k] {
+			user.Attributes[j.attribute] = append(user.Attributes[j.attribute], v)
+		}
+	}
+
+	return rows.Err()
+}
+
+// arrayValues parses a postgres array, NULL elements are skipped
+func arrayValues(col interface{}) ([]string, error) {
+	var elements []sql.NullString
+	err := pq.GenericArray{A: &elements}.Scan(col)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []string{}
+	for _, element := range elements {
+		if element.Valid {
+			values = append(values, element.String)
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,85 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+)
+
+func TestMysqlDialect(t *testing.T) {
+	Convey("Given a backend with the mysql driver", t, func() {
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		backend, err := newBackend(&Config{
+			Config:  pkg.Config{DNAttribute: "uid"},
+			Driver:  "mysql",
+			Columns: map[string]string{"name": "uid", "email": "email"},
+		}, db)
+		So(err, ShouldBeNil)
+		So(backend.dialect.Name, ShouldEqual, "mysql")
+
+		Convey("Then the credentials are queried with backticks and question marks", func() {
+			mock.ExpectQuery("^SELECT `password` FROM `users` WHERE `name` = \\?$").WithArgs("userA").
+				WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow("$2a$04$7aS0AmbLn./PTc0DpX2XeOpKV2VPM6RRrooSHsG/n.zolLV78BGny"))
+			So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Then substring filters use LIKE", func() {
+			mock.ExpectQuery("^SELECT (.+) FROM `users` WHERE \\(email IS NOT NULL AND lower\\(email\\) LIKE lower\\(\\?\\) ESCAPE '!'\\)$").WithArgs("%@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"name", "email"}).AddRow("userA", "a@example.com"))
+			users, err := backend.GetUsers(context.Background(), &ldap.Substrings{Attribute: "email", Final: "@example.com"})
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].Attributes["uid"], ShouldResemble, []string{"userA"})
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Then users are inserted with backticks and question marks", func() {
+			mock.ExpectExec("^INSERT INTO `users` \\(`name`,`password`\\) VALUES \\(\\?,\\?\\)$").
+				WithArgs("userA", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			So(backend.CreateUser("userA", "test123"), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given a mysql backend with array columns", t, func() {
+		db, _, err := sqlmock.New()
+		So(err, ShouldBeNil)
+
+		_, err = newBackend(&Config{
+			Driver:  "mysql",
+			Columns: map[string]string{"name": "uid", "phones": "telephoneNumber"},
+			Arrays:  []string{"phones"},
+		}, db)
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldEqual, errArraysUnsupported)
+		})
+	})
+}
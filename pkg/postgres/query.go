@@ -0,0 +1,139 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+	"regexp"
+	"strings"
+)
+
+var (
+	errInvalidIdentifier  = errors.New("sql backend: invalid identifier")
+	errMissingPlaceholder = errors.New("sql backend: query template without placeholder")
+	errUnknownPlaceholder = errors.New("sql backend: unknown placeholder in query template")
+
+	identifierRegex  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	placeholderRegex = regexp.MustCompile(`\{([A-Za-z]+)\}`)
+)
+
+// Queries are sql templates replacing the generated queries. The authenticate
+// query returns the password hash of the user given by the placeholder
+// {username}. The search query contains the placeholder {where} for the
+// condition created from the ldap filter and optionally {columns} for the
+// configured columns. The update password query stores the rehashed password
+// {password} of the user {username}.
+type Queries struct {
+	Authenticate   string `json:"authenticate"`
+	Search         string `json:"search"`
+	UpdatePassword string `json:"updatePassword"`
+}
+
+// quote validates the identifier and quotes it for the dialect
+func (dialect *Dialect) quote(identifier string) (string, error) {
+	if _, err := validateIdentifier(identifier); err != nil {
+		return "", err
+	}
+
+	return dialect.IdentifierQuote + identifier + dialect.IdentifierQuote, nil
+}
+
+// validateIdentifier validates an identifier used without quotes. The columns
+// of the columns map stay unquoted as before the table and the credential
+// columns became configurable, so the database keeps folding their case.
+func validateIdentifier(identifier string) (string, error) {
+	if !identifierRegex.MatchString(identifier) {
+		return "", fmt.Errorf("%v: %q", errInvalidIdentifier, identifier)
+	}
+
+	return identifier, nil
+}
+
+// quoteTable quotes the table or view name which may be qualified by a schema
+func (dialect *Dialect) quoteTable(table string) (string, error) {
+	parts := strings.Split(table, ".")
+	if len(parts) > 2 {
+		return "", fmt.Errorf("%v: %q", errInvalidIdentifier, table)
+	}
+
+	for i, part := range parts {
+		quoted, err := dialect.quote(part)
+		if err != nil {
+			return "", fmt.Errorf("%v: %q", errInvalidIdentifier, table)
+		}
+		parts[i] = quoted
+	}
+
+	return strings.Join(parts, "."), nil
+}
+
+// validateTemplate checks that the template contains the required and only
+// known placeholders.
+func validateTemplate(template string, required string, optional ...string) error {
+	known := map[string]bool{required: true}
+	for _, name := range optional {
+		known[name] = true
+	}
+
+	found := false
+	for _, match := range placeholderRegex.FindAllStringSubmatch(template, -1) {
+		if !known[match[1]] {
+			return fmt.Errorf("%v: %s", errUnknownPlaceholder, match[0])
+		}
+		found = found || match[1] == required
+	}
+
+	if !found {
+		return fmt.Errorf("%v {%s}: %s", errMissingPlaceholder, required, template)
+	}
+
+	return nil
+}
+
+// expand replaces the placeholders of the template with the sql of the values
+// and converts the query to the placeholder format of the dialect.
+func (dialect *Dialect) expand(template string, values map[string]sq.Sqlizer) (string, []interface{}, error) {
+	var args []interface{}
+	var err error
+
+	query := placeholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder[1:len(placeholder)-1]]
+		if !ok {
+			return placeholder
+		}
+
+		sql, valueArgs, valueErr := value.ToSql()
+		if valueErr != nil {
+			err = valueErr
+		}
+		args = append(args, valueArgs...)
+
+		return sql
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	query, err = dialect.Placeholder.ReplacePlaceholders(query)
+	return query, args, err
+}
@@ -0,0 +1,155 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	sq "gopkg.in/Masterminds/squirrel.v1"
+)
+
+var (
+	errNoUpdateQuery = errors.New("sql backend: rehashing with an authenticate query requires an updatePassword query")
+)
+
+var _ pkg.PasswordStore = &Backend{}
+
+// validateRehash checks that the rehashed passwords can be stored. With an
+// authenticate query the table may be a view, so the update must be given.
+func (backend *Backend) validateRehash() error {
+	queries := backend.config.Queries
+
+	if queries.UpdatePassword != "" {
+		placeholders := []string{"username", "password", "oldPassword"}
+		for i, required := range placeholders {
+			optional := append(append([]string{}, placeholders[:i]...), placeholders[i+1:]...)
+
+			err := validateTemplate(queries.UpdatePassword, required, optional...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if backend.config.Rehash && queries.Authenticate != "" && queries.UpdatePassword == "" {
+		return errNoUpdateQuery
+	}
+
+	return nil
+}
+
+// rehash replaces the hash of the user after a successful bind if it uses a
+// deprecated scheme or a lower cost than new hashes. The hash is only replaced
+// if it is still the verified one: it may have been read from a lagging replica
+// or changed in the meantime. Failures are only logged, the bind succeeds
+// anyway.
+func (backend *Backend) rehash(ctx context.Context, username string, hashedPassword string, password string) {
+	if !backend.config.Rehash || !util.NeedsRehash(hashedPassword) {
+		return
+	}
+
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		log.Printf("sql backend: rehashing the password of %s: %v", username, err)
+		return
+	}
+
+	query, args, err := backend.updatePasswordQuery(username, hash, hashedPassword)
+	if err != nil {
+		log.Printf("sql backend: rehashing the password of %s: %v", username, err)
+		return
+	}
+
+	// the primary database receives the writes
+	res, err := backend.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("sql backend: rehashing the password of %s: %v", username, err)
+		return
+	}
+
+	rows, err := res.RowsAffected()
+	if err == nil && rows == 0 {
+		log.Debugf("[auth] the password of %s changed, keeping the new hash", username)
+		return
+	}
+
+	log.Debugf("[auth] rehashed the password of %s", username)
+}
+
+func (backend *Backend) updatePasswordQuery(username string, hash string, oldHash string) (string, []interface{}, error) {
+	if backend.config.Queries.UpdatePassword != "" {
+		return backend.dialect.expand(backend.config.Queries.UpdatePassword, map[string]sq.Sqlizer{
+			"username":    sq.Expr("?", username),
+			"password":    sq.Expr("?", hash),
+			"oldPassword": sq.Expr("?", oldHash),
+		})
+	}
+
+	return backend.statements.
+		Update(backend.table).
+		Set(backend.passwordColumn, hash).
+		Where(sq.Eq{backend.loginColumn: username}).
+		Where(sq.Eq{backend.passwordColumn: oldHash}).
+		ToSql()
+}
+
+// PasswordHashes returns the password hashes of the configured table, users
+// without password are skipped.
+func (backend *Backend) PasswordHashes(ctx context.Context) ([]string, error) {
+	query, args, err := backend.statements.
+		Select(backend.passwordColumn).
+		From(backend.table).
+		ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := []string{}
+	err = backend.endpoints.do(ctx, func(db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		hashes = hashes[:0]
+		for rows.Next() {
+			var hash sql.NullString
+			if err := rows.Scan(&hash); err != nil {
+				return err
+			}
+			if hash.Valid {
+				hashes = append(hashes, hash.String)
+			}
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
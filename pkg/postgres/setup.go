@@ -21,14 +21,15 @@
 package postgres
 
 import (
+	"fmt"
 	"github.com/gopenguin/ldap-proxy/pkg/log"
 	"github.com/gopenguin/ldap-proxy/pkg/util"
 	"os"
 )
 
 func (backend *Backend) Init() error {
-	log.Print("creating table users ... ")
-	_, err := backend.db.Exec("CREATE TABLE users (id SERIAL PRIMARY KEY, name VARCHAR(256), password VARCHAR(1024), email VARCHAR(256), firstname VARCHAR(256), lastname VARCHAR(256))")
+	log.Printf("creating table %s ... ", backend.config.table())
+	_, err := backend.db.Exec(fmt.Sprintf(backend.dialect.CreateTable, backend.table, backend.loginColumn, backend.passwordColumn))
 	if err != nil {
 		return err
 	}
@@ -42,8 +43,8 @@ func (backend *Backend) Init() error {
 }
 
 func (backend *Backend) Cleanup() error {
-	log.Print("deleting tabel users ...")
-	_, err := backend.db.Exec("DROP TABLE users")
+	log.Printf("deleting table %s ...", backend.config.table())
+	_, err := backend.db.Exec("DROP TABLE " + backend.table)
 	if err != nil {
 		log.Print(err)
 		os.Exit(-1)
@@ -53,11 +54,23 @@ func (backend *Backend) Cleanup() error {
 }
 
 func (backend *Backend) CreateUser(name string, password string) error {
-	hash := util.HashPassword(password, 12)
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		return err
+	}
 
 	log.Print("Password hashed ...")
 
-	res, err := backend.db.Exec("INSERT INTO users (name, password) VALUES ($1, $2)", name, string(hash))
+	query, args, err := backend.statements.
+		Insert(backend.table).
+		Columns(backend.loginColumn, backend.passwordColumn).
+		Values(name, hash).
+		ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := backend.db.Exec(query, args...)
 	if err != nil {
 		return err
 	}
@@ -68,11 +81,10 @@ func (backend *Backend) CreateUser(name string, password string) error {
 }
 
 func (backend *Backend) validateDatabaseStructure() error {
-	_, err := backend.db.Query("SELECT name, password FROM users LIMIT 1")
-
+	rows, err := backend.db.Query(fmt.Sprintf("SELECT %s, %s FROM %s LIMIT 1", backend.loginColumn, backend.passwordColumn, backend.table))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return rows.Close()
 }
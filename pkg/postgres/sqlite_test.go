@@ -0,0 +1,408 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupDialect(t *testing.T) {
+	Convey("Given a config without driver and dialect", t, func() {
+		dialect, err := lookupDialect(&Config{})
+
+		Convey("Then the postgres dialect is used", func() {
+			So(err, ShouldBeNil)
+			So(dialect.Name, ShouldEqual, "postgres")
+		})
+	})
+
+	Convey("Given a config with the sqlite driver", t, func() {
+		dialect, err := lookupDialect(&Config{Driver: "sqlite3"})
+
+		Convey("Then the sqlite dialect is used", func() {
+			So(err, ShouldBeNil)
+			So(dialect.Name, ShouldEqual, "sqlite")
+		})
+	})
+
+	Convey("Given a config with an explicit dialect", t, func() {
+		dialect, err := lookupDialect(&Config{Driver: "custom", Dialect: "mysql"})
+
+		Convey("Then the dialect is used", func() {
+			So(err, ShouldBeNil)
+			So(dialect.Name, ShouldEqual, "mysql")
+		})
+	})
+
+	Convey("Given a config with an unknown driver", t, func() {
+		_, err := lookupDialect(&Config{Driver: "custom"})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldEqual, errUnknownDialect)
+		})
+	})
+}
+
+func TestSqlFactory(t *testing.T) {
+	Convey("Given the sql factory", t, func() {
+		factory := NewSqlFactory()
+		So(factory.Name(), ShouldEqual, "sql")
+
+		Convey("When no driver is configured", func() {
+			_, err := factory.New(factory.NewConfig())
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldEqual, errNoDriver)
+			})
+		})
+
+		Convey("When array columns are configured for sqlite", func() {
+			_, err := factory.New(&Config{Driver: "sqlite3", Url: ":memory:", Columns: map[string]string{"phones": "telephoneNumber"}, Arrays: []string{"phones"}})
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldEqual, errArraysUnsupported)
+			})
+		})
+
+		Convey("When a join is incomplete", func() {
+			_, err := factory.New(&Config{Driver: "sqlite3", Url: ":memory:", Joins: []Join{{Table: "user_emails"}}})
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldEqual, errInvalidJoin)
+			})
+		})
+	})
+}
+
+func TestBackend_Sqlite(t *testing.T) {
+	Convey("Given a sqlite database", t, func() {
+		dir, err := ioutil.TempDir("", "ldap-proxy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		backend, err := NewBackend(&Config{
+			Config: pkg.Config{
+				DNAttribute: "uid",
+			},
+			Driver: "sqlite3",
+			Url:    filepath.Join(dir, "users.db"),
+			Columns: map[string]string{
+				"name":  "uid",
+				"email": "mail",
+			},
+		})
+		So(err, ShouldBeNil)
+		defer backend.Close()
+
+		So(backend.Init(), ShouldBeNil)
+
+		_, err = backend.db.Exec("INSERT INTO users (name, password, email) VALUES ('userB', '', 'user-b@example.com')")
+		So(err, ShouldBeNil)
+
+		Convey("When a user is created", func() {
+			So(backend.CreateUser("userA", "test123"), ShouldBeNil)
+			_, err = backend.db.Exec("UPDATE users SET email = 'user-a@example.com' WHERE name = 'userA'")
+			So(err, ShouldBeNil)
+
+			Convey("Then the user is able to authenticate", func() {
+				So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "userA", "wrongPassword"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "userC", "test123"), ShouldBeFalse)
+			})
+
+			Convey("Then all users are returned", func() {
+				users, err := backend.GetUsers(context.Background(), nil)
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 2)
+			})
+
+			Convey("Then the user is found by a filter", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.OR{
+					Filters: []ldap.Filter{
+						&ldap.EqualityMatch{Attribute: "mail", Value: []byte("user-a@example.com")},
+						&ldap.EqualityMatch{Attribute: "uid", Value: []byte("userX")},
+					},
+				})
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "userA")
+				So(users[0].Attributes["mail"], ShouldResemble, []string{"user-a@example.com"})
+			})
+
+			Convey("Then the user is found ignoring the case of the values", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.AND{
+					Filters: []ldap.Filter{
+						&ldap.EqualityMatch{Attribute: "uid", Value: []byte("USERA")},
+						&ldap.EqualityMatch{Attribute: "mail", Value: []byte("User-A@Example.com")},
+					},
+				})
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "userA")
+			})
+
+			Convey("Then the user is found by substrings and negations", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.AND{
+					Filters: []ldap.Filter{
+						&ldap.Substrings{Attribute: "mail", Initial: "USER-", Final: "@example.com"},
+						&ldap.NOT{Filter: &ldap.EqualityMatch{Attribute: "uid", Value: []byte("userB")}},
+						&ldap.EqualityMatch{Attribute: "objectClass", Value: []byte("person")},
+					},
+				})
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "userA")
+			})
+
+			Convey("Then users without mail are found and have no mail attribute", func() {
+				_, err = backend.db.Exec("INSERT INTO users (name, password) VALUES ('userC', '')")
+				So(err, ShouldBeNil)
+
+				users, err := backend.GetUsers(context.Background(), &ldap.NOT{
+					Filter: &ldap.Present{Attribute: "mail"},
+				})
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "userC")
+				So(users[0].Attributes, ShouldNotContainKey, "mail")
+			})
+
+			Convey("Then negated conditions match users without the attribute", func() {
+				_, err = backend.db.Exec("INSERT INTO users (name, password) VALUES ('userC', '')")
+				So(err, ShouldBeNil)
+
+				for _, f := range []ldap.Filter{
+					&ldap.EqualityMatch{Attribute: "mail", Value: []byte("user-a@example.com")},
+					&ldap.Substrings{Attribute: "mail", Any: []string{"-a@"}},
+					&ldap.LessOrEqual{Attribute: "mail", Value: []byte("user-a@example.com")},
+				} {
+					users, err := backend.GetUsers(context.Background(), &ldap.NOT{Filter: f})
+
+					So(err, ShouldBeNil)
+					So(users, ShouldHaveLength, 2)
+					So([]string{users[0].DN, users[1].DN}, ShouldContain, "userB")
+					So([]string{users[0].DN, users[1].DN}, ShouldContain, "userC")
+				}
+			})
+
+			Convey("Then negated conditions on unknown attributes match nothing", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.NOT{
+					Filter: &ldap.EqualityMatch{Attribute: "sn", Value: []byte("user")},
+				})
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 0)
+			})
+		})
+	})
+}
+
+func TestBackend_SqliteJoin(t *testing.T) {
+	Convey("Given a sqlite database with a table of mail addresses", t, func() {
+		dir, err := ioutil.TempDir("", "ldap-proxy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		backend, err := NewBackend(&Config{
+			Config: pkg.Config{
+				DNAttribute: "uid",
+			},
+			Driver: "sqlite3",
+			Url:    filepath.Join(dir, "users.db"),
+			Columns: map[string]string{
+				"name": "uid",
+			},
+			Joins: []Join{
+				{Table: "user_emails", Key: "user_id", References: "id", Column: "email", Attribute: "mail"},
+			},
+		})
+		So(err, ShouldBeNil)
+		defer backend.Close()
+
+		So(backend.Init(), ShouldBeNil)
+
+		for _, statement := range []string{
+			"CREATE TABLE user_emails (user_id INTEGER, email VARCHAR(256))",
+			"INSERT INTO users (id, name, password) VALUES (1, 'userA', ''), (2, 'userB', '')",
+			"INSERT INTO user_emails (user_id, email) VALUES (1, 'a@example.com'), (1, 'alias@example.com'), (2, 'b@example.com')",
+		} {
+			_, err = backend.db.Exec(statement)
+			So(err, ShouldBeNil)
+		}
+
+		Convey("When all users are listed", func() {
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			Convey("Then the joined values are returned as multi-valued attribute", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 2)
+
+				mails := map[string][]string{}
+				for _, user := range users {
+					mails[user.DN] = user.Attributes["mail"]
+					So(user.Attributes, ShouldNotContainKey, "id")
+				}
+				So(mails["userA"], ShouldHaveLength, 2)
+				So(mails["userA"], ShouldContain, "alias@example.com")
+				So(mails["userB"], ShouldResemble, []string{"b@example.com"})
+			})
+		})
+
+		Convey("When the users are filtered by a joined value", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.OR{
+				Filters: []ldap.Filter{
+					&ldap.EqualityMatch{Attribute: "mail", Value: []byte("alias@example.com")},
+					&ldap.Substrings{Attribute: "mail", Initial: "C"},
+				},
+			})
+
+			Convey("Then the users with any matching value are returned", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "userA")
+				So(users[0].Attributes["mail"], ShouldHaveLength, 2)
+			})
+		})
+	})
+}
+
+func TestBackend_SqliteRehash(t *testing.T) {
+	Convey("Given a sqlite database with a ssha password", t, func() {
+		So(util.SetDefaultPasswordScheme("bcrypt", 4), ShouldBeNil)
+		defer util.SetDefaultPasswordScheme("bcrypt", 0)
+
+		dir, err := ioutil.TempDir("", "ldap-proxy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		config := &Config{
+			Driver: "sqlite3",
+			Url:    filepath.Join(dir, "users.db"),
+		}
+		backend, err := NewBackend(config)
+		So(err, ShouldBeNil)
+		defer backend.Close()
+
+		So(backend.Init(), ShouldBeNil)
+
+		_, err = backend.db.Exec("INSERT INTO users (name, password) VALUES ('userA', '{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==')")
+		So(err, ShouldBeNil)
+
+		storedHash := func() string {
+			hashes, err := backend.PasswordHashes(context.Background())
+			So(err, ShouldBeNil)
+			So(hashes, ShouldHaveLength, 1)
+			return hashes[0]
+		}
+
+		Convey("When rehashing is disabled", func() {
+			So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+
+			Convey("Then the hash is kept", func() {
+				So(storedHash(), ShouldStartWith, "{SSHA}")
+			})
+		})
+
+		Convey("When rehashing is enabled", func() {
+			config.Rehash = true
+
+			Convey("Then a failed bind keeps the hash", func() {
+				So(backend.Authenticate(context.Background(), "userA", "test124"), ShouldBeFalse)
+				So(storedHash(), ShouldStartWith, "{SSHA}")
+			})
+
+			Convey("Then a successful bind stores a hash of the default scheme", func() {
+				So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+				So(storedHash(), ShouldStartWith, "$2a$04$")
+				So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+			})
+
+			Convey("Then a hash with a lower cost is replaced", func() {
+				So(util.SetDefaultPasswordScheme("bcrypt", 5), ShouldBeNil)
+				So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeTrue)
+				So(storedHash(), ShouldStartWith, "$2a$05$")
+			})
+
+			Convey("Then a hash changed between the read and the write is kept", func() {
+				newHash, err := util.HashPasswordWith("bcrypt", 4, "changed")
+				So(err, ShouldBeNil)
+				_, err = backend.db.Exec("UPDATE users SET password = ? WHERE name = 'userA'", newHash)
+				So(err, ShouldBeNil)
+
+				// the verified hash was read before the change, e. g. from a replica
+				backend.rehash(context.Background(), "userA", "{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==", "test123")
+
+				So(storedHash(), ShouldEqual, newHash)
+				So(backend.Authenticate(context.Background(), "userA", "test123"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "userA", "changed"), ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given rehashing with an authenticate query", t, func() {
+		factory := NewSqlFactory()
+		config := &Config{
+			Driver:  "sqlite3",
+			Url:     ":memory:",
+			Rehash:  true,
+			Queries: Queries{Authenticate: "SELECT hash FROM accounts WHERE login = {username}"},
+		}
+
+		Convey("When there is no update query", func() {
+			_, err := factory.New(config)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldEqual, errNoUpdateQuery)
+			})
+		})
+
+		Convey("When the update query lacks the old password", func() {
+			config.Queries.UpdatePassword = "UPDATE accounts SET hash = {password} WHERE login = {username}"
+			_, err := factory.New(config)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When there is an update query", func() {
+			config.Queries.UpdatePassword = "UPDATE accounts SET hash = {password} WHERE login = {username} AND hash = {oldPassword}"
+			_, err := factory.New(config)
+
+			Convey("Then the backend is created", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
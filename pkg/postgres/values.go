@@ -0,0 +1,163 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	errUnknownFormat   = errors.New("sql backend: unknown column format")
+	errUnsupportedType = errors.New("sql backend: unsupported column type")
+	errInvalidValue    = errors.New("sql backend: value doesn't match the column format")
+)
+
+// the layout of the ldap generalized time syntax
+const generalizedTime = "20060102150405.999999999Z"
+
+// the formats overriding the conversion of a column value
+var formats = map[string]func(value interface{}) (string, error){
+	"base64":   formatBase64,
+	"hex":      formatHex,
+	"uuid":     formatUuid,
+	"boolean":  formatBoolean,
+	"unixTime": formatUnixTime,
+}
+
+// columnValue converts the value returned by the driver to an attribute value
+// with the format or the default conversion of its type. NULL values are
+// reported as missing.
+func columnValue(col interface{}, format string) (string, bool, error) {
+	if col == nil {
+		return "", false, nil
+	}
+
+	if format != "" {
+		convert, ok := formats[format]
+		if !ok {
+			return "", false, fmt.Errorf("%v: %s", errUnknownFormat, format)
+		}
+
+		value, err := convert(col)
+		return value, err == nil, err
+	}
+
+	switch col := col.(type) {
+	case string:
+		return col, true, nil
+	case []byte:
+		// text columns of mysql, numeric, uuid and json columns of postgres and
+		// binary columns are returned as raw bytes
+		return string(col), true, nil
+	case int64:
+		return strconv.FormatInt(col, 10), true, nil
+	case float64:
+		return strconv.FormatFloat(col, 'f', -1, 64), true, nil
+	case bool:
+		return formatBool(col), true, nil
+	case time.Time:
+		return col.UTC().Format(generalizedTime), true, nil
+	default:
+		return "", false, fmt.Errorf("%v %T", errUnsupportedType, col)
+	}
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+
+	return "FALSE"
+}
+
+func formatBase64(value interface{}) (string, error) {
+	b, err := rawBytes(value)
+	return base64.StdEncoding.EncodeToString(b), err
+}
+
+func formatHex(value interface{}) (string, error) {
+	b, err := rawBytes(value)
+	return hex.EncodeToString(b), err
+}
+
+// formatUuid formats binary uuids like the ones of mysql, textual uuids are
+// returned unchanged
+func formatUuid(value interface{}) (string, error) {
+	b, err := rawBytes(value)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(b) {
+	case 16:
+		h := hex.EncodeToString(b)
+		return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+	case 36:
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("%v: uuid", errInvalidValue)
+	}
+}
+
+// formatBoolean converts booleans and the integers of databases without
+// boolean type
+func formatBoolean(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case bool:
+		return formatBool(value), nil
+	case int64:
+		return formatBool(value != 0), nil
+	case []byte:
+		parsed, err := strconv.ParseBool(string(value))
+		if err != nil {
+			return "", fmt.Errorf("%v: boolean", errInvalidValue)
+		}
+		return formatBool(parsed), nil
+	default:
+		return "", fmt.Errorf("%v: boolean", errInvalidValue)
+	}
+}
+
+// formatUnixTime converts timestamps to seconds since the epoch
+func formatUnixTime(value interface{}) (string, error) {
+	t, ok := value.(time.Time)
+	if !ok {
+		return "", fmt.Errorf("%v: unixTime", errInvalidValue)
+	}
+
+	return strconv.FormatInt(t.Unix(), 10), nil
+}
+
+func rawBytes(value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	default:
+		return nil, fmt.Errorf("%v %T", errUnsupportedType, value)
+	}
+}
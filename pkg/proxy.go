@@ -24,10 +24,12 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
 	"github.com/gopenguin/ldap-proxy/pkg/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/samuel/go-ldap/ldap"
 	"net"
+	"strings"
 )
 
 var (
@@ -148,10 +150,17 @@ func (ldapProxy *LdapProxy) Bind(ctx ldap.Context, req *ldap.BindRequest) (*ldap
 
 			res.BaseResponse.Code = ldap.ResultSuccess
 			res.MatchedDN = req.DN
-			break
+			return res, nil
 		}
 	}
 
+	if urls := ldapProxy.referral(req.DN); urls != nil {
+		log.Debugf("referring bind of %s to %v", req.DN, urls)
+
+		res.BaseResponse.Code = ldap.ResultReferral
+		res.BaseResponse.Message = referralMessage(urls)
+	}
+
 	return res, nil
 }
 
@@ -219,7 +228,7 @@ func (ldapProxy *LdapProxy) Search(ctx ldap.Context, req *ldap.SearchRequest) (*
 
 	requestsTotal.With(prometheus.Labels{"action": "search"}).Inc()
 
-	if getDn(sess.context) == "" {
+	if BoundDn(sess.context) == "" {
 		return &ldap.SearchResponse{
 			BaseResponse: ldap.BaseResponse{
 				Code: ldap.ResultInsufficientAccessRights,
@@ -227,20 +236,64 @@ func (ldapProxy *LdapProxy) Search(ctx ldap.Context, req *ldap.SearchRequest) (*
 		}, nil
 	}
 
+	if _, err := dn.Parse(req.BaseDN); err != nil {
+		log.Debugf("invalid search base: %v", err)
+
+		return &ldap.SearchResponse{
+			BaseResponse: ldap.BaseResponse{
+				Code:    ldap.ResultInvalidDNSyntax,
+				Message: err.Error(),
+			},
+		}, nil
+	}
+
+	if urls := ldapProxy.referral(req.BaseDN); urls != nil {
+		log.Debugf("referring search in %s to %v", req.BaseDN, urls)
+
+		return &ldap.SearchResponse{
+			BaseResponse: ldap.BaseResponse{
+				Code:    ldap.ResultReferral,
+				Message: referralMessage(urls),
+			},
+		}, nil
+	}
+
 	res := &ldap.SearchResponse{
 		BaseResponse: ldap.BaseResponse{
 			Code: ldap.ResultSuccess,
 		},
 	}
 
+	searchContext := setSearchScope(setSearchBase(sess.context, req.BaseDN), req.Scope)
+
 	var searchResults []*ldap.SearchResult
 
 	for _, backend := range ldapProxy.backends {
 		timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
 			backendActionDuration.With(prometheus.Labels{"action": "search", "backend": backend.Name()}).Observe(v)
 		}))
-		users, err := backend.GetUsers(sess.context, req.Filter)
+		users, err := backend.GetUsers(searchContext, req.Filter)
 		timer.ObserveDuration()
+		if err == ErrUnavailable {
+			log.Debugf("backend %s is unavailable", backend.Name())
+
+			return &ldap.SearchResponse{
+				BaseResponse: ldap.BaseResponse{
+					Code:    ldap.ResultUnavailable,
+					Message: "the backend " + backend.Name() + " is unavailable",
+				},
+			}, nil
+		}
+		if err == ErrSearchRejected {
+			log.Debugf("search %s rejected by backend %s", req.Filter, backend.Name())
+
+			return &ldap.SearchResponse{
+				BaseResponse: ldap.BaseResponse{
+					Code:    ldap.ResultUnwillingToPerform,
+					Message: "the search filter is not allowed",
+				},
+			}, nil
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -276,5 +329,35 @@ func (ldapProxy *LdapProxy) Whoami(ctx ldap.Context) (string, error) {
 
 	requestsTotal.With(prometheus.Labels{"action": "whoami"}).Inc()
 
-	return getDn(sess.context), nil
+	return BoundDn(sess.context), nil
+}
+
+// referral asks all backends implementing pkg.Referrer, also behind wrappers,
+// for a referral of the given dn and returns the first one found.
+func (ldapProxy *LdapProxy) referral(dn string) []string {
+	if dn == "" {
+		return nil
+	}
+
+	for _, backend := range ldapProxy.backends {
+		referrer, ok := FindReferrer(backend)
+		if !ok {
+			continue
+		}
+
+		if urls := referrer.Referral(dn); len(urls) > 0 {
+			return urls
+		}
+	}
+
+	return nil
+}
+
+// referralMessage formats the referral urls for the diagnostic message. The
+// ldap library encodes neither the referral field of the ldap result nor search
+// result references, so the urls are handed to the client as part of the
+// message only and searches above a referred naming context don't return
+// continuation references.
+func referralMessage(urls []string) string {
+	return "referral: " + strings.Join(urls, " ")
 }
@@ -22,6 +22,7 @@ package pkg
 
 import (
 	"context"
+	"github.com/samuel/go-ldap/ldap"
 	"sync/atomic"
 )
 
@@ -30,6 +31,8 @@ type proxyContextKey int
 const (
 	contextKeyId = proxyContextKey(iota)
 	contextKeyDn
+	contextKeySearchBase
+	contextKeySearchScope
 )
 
 var (
@@ -53,7 +56,9 @@ func setDn(ctx context.Context, dn string) context.Context {
 	return context.WithValue(ctx, contextKeyDn, dn)
 }
 
-func getDn(ctx context.Context) string {
+// BoundDn returns the dn the client of the current request is bound as or an
+// empty string if the client isn't bound.
+func BoundDn(ctx context.Context) string {
 	value := ctx.Value(contextKeyDn)
 	if value == nil {
 		return ""
@@ -61,3 +66,39 @@ func getDn(ctx context.Context) string {
 		return value.(string)
 	}
 }
+
+func setSearchBase(ctx context.Context, baseDn string) context.Context {
+	return context.WithValue(ctx, contextKeySearchBase, baseDn)
+}
+
+// SearchBase returns the base dn of the search request currently processed or
+// an empty string if there is none.
+func SearchBase(ctx context.Context) string {
+	value := ctx.Value(contextKeySearchBase)
+	if value == nil {
+		return ""
+	} else {
+		return value.(string)
+	}
+}
+
+// WithSearch returns a copy of the context with another base dn and scope.
+// Wrappers use it to translate a search into the namespace of their delegate.
+func WithSearch(ctx context.Context, baseDn string, scope ldap.Scope) context.Context {
+	return setSearchScope(setSearchBase(ctx, baseDn), scope)
+}
+
+func setSearchScope(ctx context.Context, scope ldap.Scope) context.Context {
+	return context.WithValue(ctx, contextKeySearchScope, scope)
+}
+
+// SearchScope returns the scope of the search request currently processed. It
+// defaults to the whole subtree.
+func SearchScope(ctx context.Context) ldap.Scope {
+	value := ctx.Value(contextKeySearchScope)
+	if value == nil {
+		return ldap.ScopeWholeSubtree
+	} else {
+		return value.(ldap.Scope)
+	}
+}
@@ -22,6 +22,7 @@ package pkg
 
 import (
 	"context"
+	"github.com/samuel/go-ldap/ldap"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -36,7 +37,7 @@ func TestSetSessId(t *testing.T) {
 
 			Convey("Then it can later on be retrieved", func() {
 				So(getId(ctx), ShouldEqual, 123)
-				So(getDn(ctx), ShouldEqual, "")
+				So(BoundDn(ctx), ShouldEqual, "")
 			})
 		})
 
@@ -44,9 +45,20 @@ func TestSetSessId(t *testing.T) {
 			ctx = setDn(ctx, "uid=admin,ou=people,dc=example,dc=com")
 
 			Convey("Then the dn can be retrieved from the context", func() {
-				So(getDn(ctx), ShouldEqual, "uid=admin,ou=people,dc=example,dc=com")
+				So(BoundDn(ctx), ShouldEqual, "uid=admin,ou=people,dc=example,dc=com")
 				So(getId(ctx), ShouldEqual, -1)
 			})
 		})
+
+		Convey("When the search is set", func() {
+			So(SearchScope(ctx), ShouldEqual, ldap.ScopeWholeSubtree)
+
+			ctx = setSearchScope(setSearchBase(ctx, "dc=example,dc=com"), ldap.ScopeSingleLevel)
+
+			Convey("Then the search base and scope can be retrieved from the context", func() {
+				So(SearchBase(ctx), ShouldEqual, "dc=example,dc=com")
+				So(SearchScope(ctx), ShouldEqual, ldap.ScopeSingleLevel)
+			})
+		})
 	})
 }
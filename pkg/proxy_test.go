@@ -78,7 +78,7 @@ func TestLdapproxy_Bind(t *testing.T) {
 				Convey("Then the bind fails with 'Invalid Credentials' and the session is uninitialized", func() {
 					So(err, ShouldBeNil)
 					So(res.Code, ShouldEqual, ldap.ResultInvalidCredentials)
-					So(getDn(sess.context), ShouldBeBlank)
+					So(BoundDn(sess.context), ShouldBeBlank)
 				})
 			})
 		})
@@ -117,6 +117,59 @@ func TestLdapproxy_Bind(t *testing.T) {
 	})
 }
 
+func TestLdapProxy_Search(t *testing.T) {
+	Convey("Given a ldap proxy with a backend rejecting the search", t, func() {
+		proxy := NewLdapProxy()
+		proxy.AddBackend(&testBackend{err: ErrSearchRejected})
+
+		ctx, cancle := context.WithCancel(setDn(context.Background(), "cn=app"))
+		sess := &session{
+			context: ctx,
+			cancle:  cancle,
+		}
+
+		Convey("When there is a search request", func() {
+			res, err := proxy.Search(sess, &ldap.SearchRequest{
+				BaseDN: "dc=example,dc=org",
+				Scope:  ldap.ScopeWholeSubtree,
+				Filter: &ldap.Present{Attribute: "userPassword"},
+			})
+
+			Convey("Then the search fails with 'Unwilling To Perform' and the connection is kept", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultUnwillingToPerform)
+				So(res.Message, ShouldNotBeBlank)
+				So(res.Results, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestLdapProxy_SearchUnavailable(t *testing.T) {
+	Convey("Given a ldap proxy with an unavailable backend", t, func() {
+		proxy := NewLdapProxy()
+		proxy.AddBackend(&testBackend{err: ErrUnavailable})
+
+		ctx, cancle := context.WithCancel(setDn(context.Background(), "cn=app"))
+		sess := &session{
+			context: ctx,
+			cancle:  cancle,
+		}
+
+		Convey("When there is a search request", func() {
+			res, err := proxy.Search(sess, &ldap.SearchRequest{
+				BaseDN: "dc=example,dc=org",
+				Scope:  ldap.ScopeWholeSubtree,
+			})
+
+			Convey("Then the search fails with 'Unavailable' and the connection is kept", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultUnavailable)
+			})
+		})
+	})
+}
+
 func TestLdapProxy_Whoami(t *testing.T) {
 	Convey("Given a ldap proxy", t, func() {
 		proxy := NewLdapProxy()
@@ -144,3 +197,102 @@ func TestLdapProxy_Whoami(t *testing.T) {
 		})
 	})
 }
+
+func TestLdapProxy_Referral(t *testing.T) {
+	Convey("Given a ldap proxy with a referrer", t, func() {
+		proxy := NewLdapProxy()
+		proxy.AddBackend(&testReferrer{namingContext: "dc=legacy,dc=org"})
+
+		ctx, cancle := context.WithCancel(setDn(context.Background(), "cn=app"))
+		sess := &session{
+			context: ctx,
+			cancle:  cancle,
+		}
+
+		Convey("When there is a bind request inside the referred naming context", func() {
+			res, err := proxy.Bind(sess, &ldap.BindRequest{
+				DN:       "uid=test,dc=legacy,dc=org",
+				Password: []byte("secure"),
+			})
+
+			Convey("Then the client is referred", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultReferral)
+				So(res.Message, ShouldContainSubstring, "ldap://legacy/uid=test,dc=legacy,dc=org")
+			})
+		})
+
+		Convey("When there is a bind request outside of the referred naming context", func() {
+			res, err := proxy.Bind(sess, &ldap.BindRequest{
+				DN:       "uid=test,dc=example,dc=org",
+				Password: []byte("secure"),
+			})
+
+			Convey("Then the bind fails with 'Invalid Credentials'", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultInvalidCredentials)
+			})
+		})
+
+		Convey("When there is a search request inside the referred naming context", func() {
+			res, err := proxy.Search(sess, &ldap.SearchRequest{
+				BaseDN: "ou=People,dc=legacy,dc=org",
+				Scope:  ldap.ScopeWholeSubtree,
+			})
+
+			Convey("Then the client is referred", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultReferral)
+				So(res.Message, ShouldContainSubstring, "ldap://legacy/ou=People,dc=legacy,dc=org")
+			})
+		})
+
+		Convey("When there is a search request with an invalid base", func() {
+			res, err := proxy.Search(sess, &ldap.SearchRequest{
+				BaseDN: "ou=People,legacy",
+				Scope:  ldap.ScopeWholeSubtree,
+			})
+
+			Convey("Then the search fails with 'Invalid DN Syntax'", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultInvalidDNSyntax)
+			})
+		})
+	})
+
+	Convey("Given a ldap proxy with a wrapped referrer", t, func() {
+		proxy := NewLdapProxy()
+		proxy.AddBackend(&testWrapper{Backend: &testWrapper{Backend: &testReferrer{namingContext: "dc=legacy,dc=org"}}})
+
+		ctx, cancle := context.WithCancel(setDn(context.Background(), "cn=app"))
+		sess := &session{
+			context: ctx,
+			cancle:  cancle,
+		}
+
+		Convey("When there is a bind request inside the referred naming context", func() {
+			res, err := proxy.Bind(sess, &ldap.BindRequest{
+				DN:       "uid=test,dc=legacy,dc=org",
+				Password: []byte("secure"),
+			})
+
+			Convey("Then the client is referred", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultReferral)
+				So(res.Message, ShouldContainSubstring, "ldap://legacy/uid=test,dc=legacy,dc=org")
+			})
+		})
+
+		Convey("When there is a search request inside the referred naming context", func() {
+			res, err := proxy.Search(sess, &ldap.SearchRequest{
+				BaseDN: "ou=People,dc=legacy,dc=org",
+				Scope:  ldap.ScopeWholeSubtree,
+			})
+
+			Convey("Then the client is referred", func() {
+				So(err, ShouldBeNil)
+				So(res.Code, ShouldEqual, ldap.ResultReferral)
+			})
+		})
+	})
+}
@@ -0,0 +1,266 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package radius
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// packet codes of RFC 2865
+const (
+	codeAccessRequest   = 1
+	codeAccessAccept    = 2
+	codeAccessReject    = 3
+	codeAccessChallenge = 11
+)
+
+// attribute types of RFC 2865 and RFC 3579
+const (
+	attrUserName             = 1
+	attrUserPassword         = 2
+	attrNasIdentifier        = 32
+	attrMessageAuthenticator = 80
+)
+
+const (
+	headerLength    = 20
+	maxPacketLength = 4096
+)
+
+var (
+	errShortPacket          = errors.New("radius: packet too short")
+	errInvalidLength        = errors.New("radius: invalid packet length")
+	errInvalidAttribute     = errors.New("radius: invalid attribute")
+	errInvalidAuthenticator = errors.New("radius: invalid response authenticator")
+	errInvalidMessageAuth   = errors.New("radius: invalid message authenticator")
+	errUnknownAttributeName = errors.New("radius: unknown attribute name")
+	errPasswordTooLong      = errors.New("radius: password too long")
+)
+
+// attributeNames are the names of the attributes which are usually returned in
+// an Access-Accept
+var attributeNames = map[string]byte{
+	"User-Name":               1,
+	"Service-Type":            6,
+	"Framed-IP-Address":       8,
+	"Filter-Id":               11,
+	"Reply-Message":           18,
+	"Class":                   25,
+	"Session-Timeout":         27,
+	"Idle-Timeout":            28,
+	"Vendor-Specific":         26,
+	"Tunnel-Private-Group-Id": 81,
+}
+
+// attributeType looks up the type of an attribute by name or number
+func attributeType(name string) (byte, error) {
+	if t, ok := attributeNames[name]; ok {
+		return t, nil
+	}
+
+	t, err := strconv.ParseUint(name, 10, 8)
+	if err != nil || t == 0 {
+		return 0, errUnknownAttributeName
+	}
+
+	return byte(t), nil
+}
+
+type attribute struct {
+	Type  byte
+	Value []byte
+}
+
+type packet struct {
+	Code          byte
+	Identifier    byte
+	Authenticator [16]byte
+	Attributes    []attribute
+}
+
+func (p *packet) add(t byte, value []byte) {
+	p.Attributes = append(p.Attributes, attribute{Type: t, Value: value})
+}
+
+// get returns the first value of the attribute
+func (p *packet) get(t byte) ([]byte, bool) {
+	for _, attr := range p.Attributes {
+		if attr.Type == t {
+			return attr.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+func (p *packet) encode() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, headerLength, maxPacketLength))
+	for _, attr := range p.Attributes {
+		if len(attr.Value) > 253 {
+			return nil, errInvalidAttribute
+		}
+
+		buf.WriteByte(attr.Type)
+		buf.WriteByte(byte(len(attr.Value) + 2))
+		buf.Write(attr.Value)
+	}
+
+	data := buf.Bytes()
+	if len(data) > maxPacketLength {
+		return nil, errInvalidLength
+	}
+
+	data[0] = p.Code
+	data[1] = p.Identifier
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+	copy(data[4:20], p.Authenticator[:])
+
+	return data, nil
+}
+
+func decodePacket(data []byte) (*packet, error) {
+	if len(data) < headerLength {
+		return nil, errShortPacket
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < headerLength || length > len(data) || length > maxPacketLength {
+		return nil, errInvalidLength
+	}
+	data = data[:length]
+
+	p := &packet{
+		Code:       data[0],
+		Identifier: data[1],
+	}
+	copy(p.Authenticator[:], data[4:20])
+
+	for rest := data[headerLength:]; len(rest) > 0; {
+		if len(rest) < 2 || rest[1] < 2 || int(rest[1]) > len(rest) {
+			return nil, errInvalidAttribute
+		}
+
+		p.add(rest[0], append([]byte{}, rest[2:rest[1]]...))
+		rest = rest[rest[1]:]
+	}
+
+	return p, nil
+}
+
+// encryptPassword hides the password as described in RFC 2865 section 5.2
+func encryptPassword(password []byte, secret []byte, authenticator [16]byte) ([]byte, error) {
+	if len(password) > 128 {
+		return nil, errPasswordTooLong
+	}
+
+	length := (len(password) + 15) / 16 * 16
+	if length == 0 {
+		length = 16
+	}
+
+	result := make([]byte, length)
+	copy(result, password)
+
+	last := authenticator[:]
+	for i := 0; i < length; i += 16 {
+		hash := md5.Sum(append(append([]byte{}, secret...), last...))
+		for j := 0; j < 16; j++ {
+			result[i+j] ^= hash[j]
+		}
+		last = result[i : i+16]
+	}
+
+	return result, nil
+}
+
+// decryptPassword reverses encryptPassword and strips the padding
+func decryptPassword(encrypted []byte, secret []byte, authenticator [16]byte) ([]byte, error) {
+	if len(encrypted) == 0 || len(encrypted)%16 != 0 || len(encrypted) > 128 {
+		return nil, errInvalidAttribute
+	}
+
+	result := make([]byte, len(encrypted))
+	last := authenticator[:]
+	for i := 0; i < len(encrypted); i += 16 {
+		hash := md5.Sum(append(append([]byte{}, secret...), last...))
+		for j := 0; j < 16; j++ {
+			result[i+j] = encrypted[i+j] ^ hash[j]
+		}
+		last = encrypted[i : i+16]
+	}
+
+	return bytes.TrimRight(result, "\x00"), nil
+}
+
+// signRequest adds the Message-Authenticator of RFC 3579 to the encoded
+// request. The attribute must have been added with 16 zero bytes before
+// encoding, it is the last attribute.
+func signRequest(data []byte, secret []byte) {
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+	copy(data[len(data)-16:], mac.Sum(nil))
+}
+
+// verifyResponse checks the response authenticator and, if present, the
+// Message-Authenticator of the response to the request with the given
+// authenticator.
+func verifyResponse(data []byte, response *packet, secret []byte, requestAuthenticator [16]byte) error {
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	data = append([]byte{}, data[:length]...)
+
+	hash := md5.New()
+	hash.Write(data[:4])
+	hash.Write(requestAuthenticator[:])
+	hash.Write(data[headerLength:])
+	hash.Write(secret)
+	if !hmac.Equal(hash.Sum(nil), response.Authenticator[:]) {
+		return errInvalidAuthenticator
+	}
+
+	messageAuth, ok := response.get(attrMessageAuthenticator)
+	if !ok {
+		return nil
+	}
+
+	// the hmac is calculated with the request authenticator and a zeroed
+	// Message-Authenticator
+	copy(data[4:20], requestAuthenticator[:])
+	for rest := data[headerLength:]; len(rest) > 0; rest = rest[rest[1]:] {
+		if rest[0] == attrMessageAuthenticator {
+			for i := 2; i < int(rest[1]); i++ {
+				rest[i] = 0
+			}
+		}
+	}
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), messageAuth) {
+		return errInvalidMessageAuth
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package radius
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestPacket(t *testing.T) {
+	Convey("Given a packet", t, func() {
+		p := &packet{
+			Code:          codeAccessRequest,
+			Identifier:    42,
+			Authenticator: [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		}
+		p.add(attrUserName, []byte("alice"))
+		p.add(attrNasIdentifier, []byte("vpn"))
+
+		Convey("Then it survives encoding and decoding", func() {
+			data, err := p.encode()
+			So(err, ShouldBeNil)
+			So(data, ShouldHaveLength, 20+7+5)
+
+			decoded, err := decodePacket(data)
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, p)
+		})
+
+		Convey("Then truncated packets are rejected", func() {
+			data, _ := p.encode()
+
+			_, err := decodePacket(data[:10])
+			So(err, ShouldEqual, errShortPacket)
+
+			_, err = decodePacket(data[:len(data)-1])
+			So(err, ShouldEqual, errInvalidLength)
+		})
+	})
+}
+
+func TestPassword(t *testing.T) {
+	Convey("Given a secret and an authenticator", t, func() {
+		secret := []byte("s3cr3t")
+		authenticator := [16]byte{16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}
+
+		Convey("Then passwords are padded and restored", func() {
+			for _, password := range []string{"", "short", "exactly 16 bytes", "a password which is longer than 16 bytes"} {
+				encrypted, err := encryptPassword([]byte(password), secret, authenticator)
+				So(err, ShouldBeNil)
+				So(len(encrypted)%16, ShouldEqual, 0)
+
+				decrypted, err := decryptPassword(encrypted, secret, authenticator)
+				So(err, ShouldBeNil)
+				So(string(decrypted), ShouldEqual, password)
+			}
+		})
+
+		Convey("Then long passwords are rejected", func() {
+			_, err := encryptPassword(make([]byte, 129), secret, authenticator)
+
+			So(err, ShouldEqual, errPasswordTooLong)
+		})
+	})
+}
+
+func TestAttributeType(t *testing.T) {
+	Convey("Then attributes are looked up by name or number", t, func() {
+		t, err := attributeType("Filter-Id")
+		So(err, ShouldBeNil)
+		So(t, ShouldEqual, 11)
+
+		t, err = attributeType("25")
+		So(err, ShouldBeNil)
+		So(t, ShouldEqual, 25)
+
+		_, err = attributeType("Unknown")
+		So(err, ShouldEqual, errUnknownAttributeName)
+	})
+}
@@ -0,0 +1,372 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package radius
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	errNoServers = errors.New("radius: no servers configured")
+	errNoSecret  = errors.New("radius: no shared secret configured")
+
+	errNoResponse       = errors.New("radius: no response")
+	errNoServerAnswered = errors.New("radius: no server answered")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "radius"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	Servers       []string          `json:"servers"`
+	Secret        string            `json:"secret"`
+	NasIdentifier string            `json:"nasIdentifier"`
+	Timeout       util.Duration     `json:"timeout"`
+	Retries       *int              `json:"retries"`
+	Attributes    map[string]string `json:"attributes"`
+
+	// how long authenticated users are listed after their last bind
+	UserTtl util.Duration `json:"userTtl"`
+}
+
+// the number of retransmissions to a single server
+func (config *Config) retries() int {
+	if config.Retries == nil {
+		return 2
+	}
+
+	return *config.Retries
+}
+
+// Backend authenticates users with a PAP Access-Request. The servers are
+// tried in order, a server which doesn't respond is skipped until the next
+// one fails as well.
+//
+// RADIUS doesn't allow to list users. If attributes are configured, the
+// attributes of the Access-Accept are mapped and the users are returned by
+// GetUsers after they authenticated within the user ttl.
+type Backend struct {
+	config     *Config
+	servers    []string
+	attributes map[byte]string
+
+	mutex   sync.Mutex
+	current int
+	users   map[string]*listedUser
+	now     func() time.Time
+}
+
+// listedUser is an authenticated user which is listed until it expires
+type listedUser struct {
+	user    *pkg.User
+	expires time.Time
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.ErrorAuthenticator = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if len(config.Servers) == 0 {
+		return nil, errNoServers
+	}
+	if config.Secret == "" {
+		return nil, errNoSecret
+	}
+
+	backend := &Backend{
+		config:     config,
+		attributes: map[byte]string{},
+		users:      map[string]*listedUser{},
+		now:        time.Now,
+	}
+
+	for _, server := range config.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "1812")
+		}
+		backend.servers = append(backend.servers, server)
+	}
+
+	for name, attribute := range config.Attributes {
+		t, err := attributeType(name)
+		if err != nil {
+			return nil, err
+		}
+		backend.attributes[t] = attribute
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	ok, err := backend.AuthenticateErr(ctx, username, password)
+	if err != nil {
+		log.Debugf("radius: %s: %v", username, err)
+	}
+
+	return ok
+}
+
+// AuthenticateErr returns an error if no server answered, rejects and
+// challenges are rejections.
+func (backend *Backend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	request, err := backend.newRequest(username, password)
+	if err != nil {
+		return false, err
+	}
+
+	backend.mutex.Lock()
+	first := backend.current
+	backend.mutex.Unlock()
+
+	for i := range backend.servers {
+		n := (first + i) % len(backend.servers)
+
+		response, err := backend.exchange(ctx, backend.servers[n], request)
+		if err != nil {
+			log.Printf("radius: server %s failed: %v", backend.servers[n], err)
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			continue
+		}
+
+		backend.mutex.Lock()
+		backend.current = n
+		backend.mutex.Unlock()
+
+		switch response.Code {
+		case codeAccessAccept:
+			backend.storeUser(username, response)
+			return true, nil
+		case codeAccessChallenge:
+			log.Debugf("radius: %s: challenges are not supported", username)
+			return false, nil
+		default:
+			return false, nil
+		}
+	}
+
+	return false, errNoServerAnswered
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.expireUsers()
+
+	users := []*pkg.User{}
+	for _, listed := range backend.users {
+		if f == nil || util.MatchFilter(f, listed.user.Attributes) {
+			users = append(users, listed.user.Copy())
+		}
+	}
+
+	return users, nil
+}
+
+func (backend *Backend) newRequest(username string, password string) (*packet, error) {
+	request := &packet{
+		Code: codeAccessRequest,
+	}
+
+	random := make([]byte, 17)
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+	request.Identifier = random[0]
+	copy(request.Authenticator[:], random[1:])
+
+	encrypted, err := encryptPassword([]byte(password), []byte(backend.config.Secret), request.Authenticator)
+	if err != nil {
+		return nil, err
+	}
+
+	request.add(attrUserName, []byte(username))
+	request.add(attrUserPassword, encrypted)
+	if backend.config.NasIdentifier != "" {
+		request.add(attrNasIdentifier, []byte(backend.config.NasIdentifier))
+	}
+	request.add(attrMessageAuthenticator, make([]byte, 16))
+
+	return request, nil
+}
+
+// exchange sends the request to the server and waits for the response. The
+// request is sent again after every timeout until the retries are exhausted.
+func (backend *Backend) exchange(ctx context.Context, server string, request *packet) (*packet, error) {
+	data, err := request.encode()
+	if err != nil {
+		return nil, err
+	}
+	secret := []byte(backend.config.Secret)
+	signRequest(data, secret)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// unblock the read if the bind is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	timeout := backend.config.Timeout.Or(3 * time.Second)
+	buf := make([]byte, maxPacketLength)
+
+	for try := 0; try <= backend.config.retries(); try++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		_, err = conn.Write(data)
+		if err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(buf)
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() && ctx.Err() == nil {
+				break
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				return nil, err
+			}
+
+			response, err := decodePacket(buf[:n])
+			if err != nil || response.Identifier != request.Identifier {
+				// not the response to this request
+				continue
+			}
+
+			err = verifyResponse(buf[:n], response, secret, request.Authenticator)
+			if err != nil {
+				log.Debugf("radius: ignoring response of %s: %v", server, err)
+				continue
+			}
+
+			return response, nil
+		}
+
+		log.Debugf("radius: no response of %s after %v", server, timeout)
+	}
+
+	return nil, errNoResponse
+}
+
+func (backend *Backend) storeUser(username string, response *packet) {
+	if len(backend.attributes) == 0 {
+		return
+	}
+
+	user := &pkg.User{
+		DN: username,
+		Attributes: map[string][]string{
+			"uid": {username},
+		},
+	}
+
+	for _, attr := range response.Attributes {
+		name, ok := backend.attributes[attr.Type]
+		if !ok {
+			continue
+		}
+		user.Attributes[name] = append(user.Attributes[name], string(attr.Value))
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	backend.expireUsers()
+	backend.users[username] = &listedUser{
+		user:    user,
+		expires: backend.now().Add(backend.config.UserTtl.Or(24 * time.Hour)),
+	}
+}
+
+// expireUsers removes the users whose last bind is older than the user ttl,
+// the mutex must be held.
+func (backend *Backend) expireUsers() {
+	now := backend.now()
+	for username, listed := range backend.users {
+		if now.After(listed.expires) {
+			delete(backend.users, username)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package radius
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// responder is a minimal RADIUS server accepting PAP requests
+type responder struct {
+	conn     net.PacketConn
+	secret   []byte
+	users    map[string]string
+	drop     int32
+	requests int32
+}
+
+func newResponder(secret string, users map[string]string) *responder {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	So(err, ShouldBeNil)
+
+	r := &responder{conn: conn, secret: []byte(secret), users: users}
+	go r.serve()
+
+	return r
+}
+
+func (r *responder) addr() string {
+	return r.conn.LocalAddr().String()
+}
+
+func (r *responder) close() {
+	r.conn.Close()
+}
+
+func (r *responder) serve() {
+	buf := make([]byte, maxPacketLength)
+	for {
+		n, addr, err := r.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&r.requests, 1)
+
+		if atomic.AddInt32(&r.drop, -1) >= 0 {
+			continue
+		}
+
+		response := r.respond(append([]byte{}, buf[:n]...))
+		if response != nil {
+			r.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (r *responder) respond(data []byte) []byte {
+	request, err := decodePacket(data)
+	if err != nil || request.Code != codeAccessRequest {
+		return nil
+	}
+
+	// verify the message authenticator of the request
+	messageAuth, _ := request.get(attrMessageAuthenticator)
+	copy(data[len(data)-16:], make([]byte, 16))
+	mac := hmac.New(md5.New, r.secret)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), messageAuth) {
+		return nil
+	}
+
+	username, _ := request.get(attrUserName)
+	encrypted, _ := request.get(attrUserPassword)
+	password, err := decryptPassword(encrypted, r.secret, request.Authenticator)
+	if err != nil {
+		return nil
+	}
+
+	response := &packet{
+		Code:          codeAccessReject,
+		Identifier:    request.Identifier,
+		Authenticator: request.Authenticator,
+	}
+	if expected, ok := r.users[string(username)]; ok && expected == string(password) {
+		response.Code = codeAccessAccept
+		response.add(11, []byte("staff"))
+		response.add(25, []byte("gold"))
+	}
+	response.add(attrMessageAuthenticator, make([]byte, 16))
+
+	result, _ := response.encode()
+	signRequest(result, r.secret)
+
+	hash := md5.New()
+	hash.Write(result)
+	hash.Write(r.secret)
+	copy(result[4:20], hash.Sum(nil))
+
+	return result
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given a radius server", t, func() {
+		server := newResponder("s3cr3t", map[string]string{"alice": "test123"})
+		defer server.close()
+
+		config := &Config{
+			Servers:       []string{server.addr()},
+			Secret:        "s3cr3t",
+			NasIdentifier: "vpn",
+			Timeout:       timeout(100 * time.Millisecond),
+		}
+
+		Convey("Then users authenticate with their password", func() {
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "alice", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeFalse)
+		})
+
+		Convey("Then no users are listed", func() {
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			So(err, ShouldBeNil)
+			So(users, ShouldBeEmpty)
+		})
+
+		Convey("When attributes are configured", func() {
+			config.Attributes = map[string]string{"Filter-Id": "memberOf", "25": "employeeType"}
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+
+			Convey("Then the authenticated users are listed with the attributes", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "memberOf", Value: []byte("staff")})
+
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "alice")
+				So(users[0].Attributes["uid"], ShouldResemble, []string{"alice"})
+				So(users[0].Attributes["employeeType"], ShouldResemble, []string{"gold"})
+			})
+
+			Convey("Then the users expire after the user ttl", func() {
+				backend.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+
+				users, err := backend.GetUsers(context.Background(), nil)
+
+				So(err, ShouldBeNil)
+				So(users, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the secret is wrong", func() {
+			config.Secret = "wrong"
+			config.Retries = new(int)
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the authentication fails", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When the first request is lost", func() {
+			atomic.StoreInt32(&server.drop, 1)
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the request is retried", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+				So(atomic.LoadInt32(&server.requests), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the first server doesn't respond", func() {
+			silent := newResponder("s3cr3t", nil)
+			atomic.StoreInt32(&silent.drop, 1000)
+			defer silent.close()
+
+			config.Servers = []string{silent.addr(), server.addr()}
+			config.Retries = new(int)
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the next server is used", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+				So(atomic.LoadInt32(&silent.requests), ShouldEqual, 1)
+				So(atomic.LoadInt32(&server.requests), ShouldEqual, 2)
+			})
+		})
+
+		Convey("When the bind is cancelled", func() {
+			atomic.StoreInt32(&server.drop, 1000)
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			Convey("Then the authentication fails early", func() {
+				start := time.Now()
+				So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeFalse)
+				So(time.Since(start), ShouldBeLessThan, 100*time.Millisecond)
+			})
+		})
+	})
+
+	Convey("Given an invalid config", t, func() {
+		_, err := NewBackend(&Config{Secret: "s3cr3t"})
+		So(err, ShouldEqual, errNoServers)
+
+		_, err = NewBackend(&Config{Servers: []string{"localhost"}})
+		So(err, ShouldEqual, errNoSecret)
+
+		_, err = NewBackend(&Config{Servers: []string{"localhost"}, Secret: "s3cr3t", Attributes: map[string]string{"Unknown": "x"}})
+		So(err, ShouldEqual, errUnknownAttributeName)
+	})
+}
+
+func timeout(d time.Duration) util.Duration {
+	return util.Duration{Duration: d}
+}
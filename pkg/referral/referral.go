@@ -0,0 +1,172 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package referral
+
+import (
+	"context"
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/upstream"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"net/url"
+)
+
+var (
+	errNoNamingContext = errors.New("referral: no naming context configured")
+	errNoUrls          = errors.New("referral: no urls configured")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "referral"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	NamingContext string         `json:"namingContext"`
+	Urls          []string       `json:"urls"`
+	Chain         bool           `json:"chain"`
+	StartTLS      bool           `json:"startTls"`
+	TLS           util.TLSConfig `json:"tls"`
+	BindDn        string         `json:"bindDn"`
+	BindPassword  string         `json:"bindPassword"`
+}
+
+// Backend refers requests for a naming context to other ldap servers. In
+// chaining mode the backend follows the referral itself and returns the
+// remote result.
+type Backend struct {
+	config  *Config
+	servers []*upstream.Server
+	chain   *upstream.Backend
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Referrer = &Backend{}
+var _ pkg.Closer = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if config.NamingContext == "" {
+		return nil, errNoNamingContext
+	}
+	if len(config.Urls) == 0 {
+		return nil, errNoUrls
+	}
+
+	backend := &Backend{
+		config: config,
+	}
+
+	for _, rawUrl := range config.Urls {
+		srv, err := upstream.ParseServer(rawUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.servers = append(backend.servers, srv)
+	}
+
+	if config.Chain {
+		chain, err := upstream.NewBackend(&upstream.Config{
+			Config:       config.Config,
+			Urls:         config.Urls,
+			StartTLS:     config.StartTLS,
+			TLS:          config.TLS,
+			BindDn:       config.BindDn,
+			BindPassword: config.BindPassword,
+			SearchBase:   config.NamingContext,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		backend.chain = chain
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	if backend.chain == nil || !dn.IsWithin(username, backend.config.NamingContext) {
+		return false
+	}
+
+	return backend.chain.Authenticate(ctx, username, password)
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	if backend.chain == nil {
+		return []*pkg.User{}, nil
+	}
+
+	return backend.chain.GetUsers(ctx, f)
+}
+
+// Close closes the connections of the chaining mode
+func (backend *Backend) Close() {
+	if backend.chain != nil {
+		backend.chain.Close()
+	}
+}
+
+func (backend *Backend) Referral(target string) []string {
+	if backend.chain != nil || !dn.IsWithin(target, backend.config.NamingContext) {
+		return nil
+	}
+
+	urls := make([]string, len(backend.servers))
+	for i, srv := range backend.servers {
+		urls[i] = srv.Url + "/" + url.PathEscape(target)
+	}
+
+	return urls
+}
@@ -0,0 +1,223 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package referral
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type upstreamBackend struct {
+	ldap.Backend
+
+	lastSearch *ldap.SearchRequest
+}
+
+func (*upstreamBackend) Bind(ctx ldap.Context, req *ldap.BindRequest) (*ldap.BindResponse, error) {
+	res := &ldap.BindResponse{
+		BaseResponse: ldap.BaseResponse{
+			Code: ldap.ResultInvalidCredentials,
+		},
+	}
+
+	if req.DN == "uid=legacy,ou=People,dc=legacy,dc=org" && string(req.Password) == "secret" {
+		res.BaseResponse.Code = ldap.ResultSuccess
+	}
+
+	return res, nil
+}
+
+func (backend *upstreamBackend) Search(ctx ldap.Context, req *ldap.SearchRequest) (*ldap.SearchResponse, error) {
+	backend.lastSearch = req
+
+	return &ldap.SearchResponse{
+		Results: []*ldap.SearchResult{
+			{
+				DN: "uid=legacy,ou=People,dc=legacy,dc=org",
+				Attributes: map[string][][]byte{
+					"uid": {[]byte("legacy")},
+				},
+			},
+		},
+	}, nil
+}
+
+func TestNewBackend(t *testing.T) {
+	Convey("Given a config without naming context", t, func() {
+		_, err := NewBackend(&Config{Urls: []string{"ldap://localhost"}})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldEqual, errNoNamingContext)
+		})
+	})
+
+	Convey("Given a config without urls", t, func() {
+		_, err := NewBackend(&Config{NamingContext: "dc=legacy,dc=org"})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldEqual, errNoUrls)
+		})
+	})
+
+	Convey("Given a config with an unsupported url", t, func() {
+		_, err := NewBackend(&Config{NamingContext: "dc=legacy,dc=org", Urls: []string{"http://localhost"}})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a config with ldap urls", t, func() {
+		backend, err := NewBackend(&Config{
+			NamingContext: "dc=legacy,dc=org",
+			Urls:          []string{"ldap://legacy", "ldaps://legacy:1636/"},
+		})
+
+		Convey("Then the servers are parsed", func() {
+			So(err, ShouldBeNil)
+			So(backend.servers, ShouldHaveLength, 2)
+			So(backend.servers[0].Url, ShouldEqual, "ldap://legacy")
+			So(backend.servers[1].Url, ShouldEqual, "ldaps://legacy:1636")
+		})
+	})
+}
+
+func TestBackend_Referral(t *testing.T) {
+	Convey("Given a referral backend", t, func() {
+		backend, err := NewBackend(&Config{
+			NamingContext: "dc=legacy,dc=org",
+			Urls:          []string{"ldap://legacy:389"},
+		})
+		So(err, ShouldBeNil)
+
+		Convey("When a dn inside the naming context is referred", func() {
+			urls := backend.Referral("uid=Alice, ou=People, DC=legacy,dc=org")
+
+			Convey("Then the url contains the dn", func() {
+				So(urls, ShouldResemble, []string{"ldap://legacy:389/" + url.PathEscape("uid=Alice, ou=People, DC=legacy,dc=org")})
+			})
+		})
+
+		Convey("When a dn outside of the naming context is referred", func() {
+			urls := backend.Referral("uid=alice,ou=People,dc=example,dc=org")
+
+			Convey("Then there is no referral", func() {
+				So(urls, ShouldBeNil)
+			})
+		})
+
+		Convey("When a user authenticates", func() {
+			result := backend.Authenticate(context.Background(), "uid=alice,dc=legacy,dc=org", "secret")
+
+			Convey("Then the authentication fails without chaining", func() {
+				So(result, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the users are requested", func() {
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			Convey("Then no users are returned without chaining", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 0)
+			})
+		})
+	})
+}
+
+func TestBackend_Chain(t *testing.T) {
+	Convey("Given an upstream ldap server and a chaining backend", t, func() {
+		dirname, cleanupTmpDir := util.TmpDir(t)
+		defer cleanupTmpDir()
+
+		unixSocketPath := filepath.Join(dirname, "upstream.sock")
+
+		upstream := &upstreamBackend{Backend: ldap.DebugBackend}
+		server, err := ldap.NewServer(upstream, nil)
+		So(err, ShouldBeNil)
+		go server.Serve("unix", unixSocketPath)
+		So(server.WaitReady(1*time.Second), ShouldBeNil)
+		defer server.Close()
+
+		backend, err := NewBackend(&Config{
+			NamingContext: "dc=legacy,dc=org",
+			Urls:          []string{"ldapi://" + url.PathEscape(unixSocketPath)},
+			Chain:         true,
+		})
+		So(err, ShouldBeNil)
+
+		Convey("Then there are no referrals", func() {
+			So(backend.Referral("uid=legacy,ou=People,dc=legacy,dc=org"), ShouldBeNil)
+		})
+
+		Convey("When a legacy user authenticates with the correct password", func() {
+			result := backend.Authenticate(context.Background(), "uid=legacy,ou=People,dc=legacy,dc=org", "secret")
+
+			Convey("Then the authentication succeeds", func() {
+				So(result, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a legacy user authenticates with a wrong password", func() {
+			result := backend.Authenticate(context.Background(), "uid=legacy,ou=People,dc=legacy,dc=org", "wrong")
+
+			Convey("Then the authentication fails", func() {
+				So(result, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a legacy user authenticates without a password", func() {
+			result := backend.Authenticate(context.Background(), "uid=legacy,ou=People,dc=legacy,dc=org", "")
+
+			Convey("Then the authentication fails", func() {
+				So(result, ShouldBeFalse)
+			})
+		})
+
+		Convey("When a user outside of the naming context authenticates", func() {
+			result := backend.Authenticate(context.Background(), "uid=legacy,ou=People,dc=example,dc=org", "secret")
+
+			Convey("Then the authentication fails", func() {
+				So(result, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the users below the naming context are requested", func() {
+			ctx := context.Background()
+			users, err := backend.GetUsers(ctx, &ldap.EqualityMatch{Attribute: "uid", Value: []byte("legacy")})
+
+			Convey("Then the users of the upstream server are returned", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "uid=legacy,ou=People,dc=legacy,dc=org")
+				So(users[0].Attributes["uid"], ShouldResemble, []string{"legacy"})
+				So(upstream.lastSearch.BaseDN, ShouldEqual, "dc=legacy,dc=org")
+				So(upstream.lastSearch.Filter.String(), ShouldEqual, "(uid=legacy)")
+			})
+		})
+	})
+}
@@ -0,0 +1,251 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+var (
+	errNoAuthUrl = errors.New("http: no authUrl configured")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "http"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	AuthUrl   string            `json:"authUrl"`
+	SearchUrl string            `json:"searchUrl"`
+	Headers   map[string]string `json:"headers"`
+	TLS       util.TLSConfig    `json:"tls"`
+	Timeout   util.Duration     `json:"timeout"`
+}
+
+// The request body of the authentication endpoint
+type authRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// The request body of the search endpoint
+type searchRequest struct {
+	BaseDn string           `json:"baseDn"`
+	Filter *util.JSONFilter `json:"filter"`
+}
+
+// The response body of the search endpoint
+type searchResponse struct {
+	Users []struct {
+		DN         string                 `json:"dn"`
+		Attributes map[string]util.Values `json:"attributes"`
+	} `json:"users"`
+}
+
+// Backend delegates the authentication and the search to an http api.
+type Backend struct {
+	config *Config
+	client *http.Client
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.ErrorAuthenticator = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if config.AuthUrl == "" {
+		return nil, errNoAuthUrl
+	}
+
+	tlsConfig, err := config.TLS.Load("")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout.Or(10 * time.Second),
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+// Authenticate posts the credentials to the auth url. A 2xx status accepts the
+// credentials, every other status rejects them.
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	ok, err := backend.AuthenticateErr(ctx, username, password)
+	if err != nil {
+		log.Printf("http: %v", err)
+	}
+
+	return ok
+}
+
+// AuthenticateErr returns an error for failed requests and unexpected status
+// codes, 401, 403 and 404 reject the credentials.
+func (backend *Backend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	// an empty password is never sent, the api might treat it as anonymous
+	if password == "" {
+		return false, nil
+	}
+
+	res, err := backend.post(ctx, backend.config.AuthUrl, &authRequest{
+		Username: username,
+		Password: password,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer closeBody(res.Body)
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return true, nil
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("http: unexpected status %s from %s", res.Status, backend.config.AuthUrl)
+	}
+}
+
+// GetUsers posts the filter to the search url and returns the users of the
+// response. Without search url no users are returned.
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+
+	if backend.config.SearchUrl == "" {
+		return users, nil
+	}
+
+	res, err := backend.post(ctx, backend.config.SearchUrl, &searchRequest{
+		BaseDn: pkg.SearchBase(ctx),
+		Filter: util.NewJSONFilter(f),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("http: unexpected status %s from %s", res.Status, backend.config.SearchUrl)
+	}
+
+	body := searchResponse{}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range body.Users {
+		user := &pkg.User{
+			DN:         u.DN,
+			Attributes: map[string][]string{},
+		}
+		for attribute, values := range u.Attributes {
+			user.Attributes[attribute] = values
+		}
+
+		if user.DN == "" && backend.config.DNAttribute != "" && len(user.Attributes[backend.config.DNAttribute]) > 0 {
+			user.DN = user.Attributes[backend.config.DNAttribute][0]
+		}
+		if user.DN == "" {
+			log.Printf("http: skipping user without dn")
+			continue
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (backend *Backend) post(ctx context.Context, url string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for name, value := range backend.config.Headers {
+		req.Header.Set(name, value)
+	}
+
+	return backend.client.Do(req)
+}
+
+// closeBody drains the body to allow reusing the connection
+func closeBody(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+}
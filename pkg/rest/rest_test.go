@@ -0,0 +1,177 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// api is a stand in for the user api
+type api struct {
+	lastSearch searchRequest
+	auths      int
+	delay      time.Duration
+}
+
+func (a *api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(a.delay)
+
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/auth":
+		a.auths++
+		credentials := authRequest{}
+		json.NewDecoder(r.Body).Decode(&credentials)
+
+		if credentials.Username == "alice" && credentials.Password == "test123" {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	case "/search":
+		json.NewDecoder(r.Body).Decode(&a.lastSearch)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"users": [
+			{"dn": "uid=alice,ou=People,dc=example,dc=org", "attributes": {"uid": "alice", "mail": ["alice@example.org", "a.smith@example.org"]}},
+			{"attributes": {"uid": "bob"}}
+		]}`))
+	case "/broken":
+		w.WriteHeader(http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given a user api", t, func() {
+		a := &api{}
+		server := httptest.NewTLSServer(a)
+		defer server.Close()
+
+		config := &Config{
+			Config: pkg.Config{
+				DNAttribute: "uid",
+			},
+			AuthUrl:   server.URL + "/auth",
+			SearchUrl: server.URL + "/search",
+			Headers: map[string]string{
+				"Authorization": "Bearer token",
+			},
+			TLS: util.TLSConfig{
+				InsecureSkipVerify: true,
+			},
+		}
+
+		Convey("Given a backend", func() {
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then valid credentials are accepted", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			})
+
+			Convey("Then invalid credentials are rejected", func() {
+				So(backend.Authenticate(context.Background(), "alice", "wrong"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeFalse)
+			})
+
+			Convey("Then an empty password is rejected without asking the api", func() {
+				So(backend.Authenticate(context.Background(), "alice", ""), ShouldBeFalse)
+				So(a.auths, ShouldEqual, 0)
+			})
+
+			Convey("Then the users are searched with the filter", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")})
+
+				So(err, ShouldBeNil)
+				So(a.lastSearch.Filter.Type, ShouldEqual, "equality")
+				So(a.lastSearch.Filter.Attribute, ShouldEqual, "uid")
+				So(*a.lastSearch.Filter.Value, ShouldEqual, "alice")
+
+				So(users, ShouldHaveLength, 2)
+				So(users[0].DN, ShouldEqual, "uid=alice,ou=People,dc=example,dc=org")
+				So(users[0].Attributes["mail"], ShouldResemble, []string{"alice@example.org", "a.smith@example.org"})
+				So(users[1].DN, ShouldEqual, "bob")
+			})
+		})
+
+		Convey("Given a backend without the auth header", func() {
+			config.Headers = nil
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the authentication fails", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+
+			Convey("Then the search fails", func() {
+				_, err := backend.GetUsers(context.Background(), nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given a backend with a failing search url", func() {
+			config.SearchUrl = server.URL + "/broken"
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the search fails", func() {
+				_, err := backend.GetUsers(context.Background(), nil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("Given a backend with a short timeout", func() {
+			a.delay = 100 * time.Millisecond
+			config.Timeout = util.Duration{Duration: 10 * time.Millisecond}
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then slow requests fail", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Given a backend trusting the system certificates only", func() {
+			config.TLS = util.TLSConfig{}
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then the self signed certificate is rejected", func() {
+				So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,230 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/samuel/go-ldap/ldap"
+	"strings"
+)
+
+var (
+	errNoRules = errors.New("rewrite: no rules configured")
+)
+
+type wrapperFactory struct{}
+
+func NewWrapperFactory() pkg.WrapperFactory {
+	return &wrapperFactory{}
+}
+
+func (wrapperFactory) Name() string {
+	return "rewrite"
+}
+
+func (wrapperFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (wrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	rewriteConfig, ok := config.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	return NewBackend(backend, rewriteConfig)
+}
+
+type Config struct {
+	UsernameAttribute string `json:"usernameAttribute"`
+	Rules             []Rule `json:"rules"`
+}
+
+func (config *Config) usernameAttribute() string {
+	if config.UsernameAttribute == "" {
+		return "uid"
+	}
+
+	return config.UsernameAttribute
+}
+
+// LegacyConfig creates the rule of the former stripper configuration
+// <userRdnAttribute>=<name>,<peopleRdn>,<baseDn>.
+func LegacyConfig(baseDn string, peopleRdn string, userRdnAttribute string) *Config {
+	return &Config{
+		UsernameAttribute: userRdnAttribute,
+		Rules: []Rule{
+			{Template: fmt.Sprintf("%s={%s},%s,%s", userRdnAttribute, userRdnAttribute, peopleRdn, baseDn)},
+		},
+	}
+}
+
+// Backend maps the dns of the clients to the usernames of the delegate and
+// back. The first rule matching a bind dn determines the username. The dn of
+// a user is created with the first rule whose template can be filled with the
+// attributes of the user, so the following rules act as aliases for binds.
+type Backend struct {
+	delegate pkg.Backend
+	config   *Config
+	rules    []*rule
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Wrapper = &Backend{}
+
+func NewBackend(delegate pkg.Backend, config *Config) (*Backend, error) {
+	if len(config.Rules) == 0 {
+		return nil, errNoRules
+	}
+
+	backend := &Backend{
+		delegate: delegate,
+		config:   config,
+	}
+
+	for _, ruleConfig := range config.Rules {
+		r, err := compileRule(ruleConfig, config.usernameAttribute())
+		if err != nil {
+			return nil, err
+		}
+
+		backend.rules = append(backend.rules, r)
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() string {
+	return backend.delegate.Name()
+}
+
+func (backend *Backend) Unwrap() pkg.Backend {
+	return backend.delegate
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, dn string, password string) bool {
+	username, ok := backend.Username(dn)
+	if !ok {
+		return false // no rule matches the dn
+	}
+
+	log.Debugf("rewrite: %s is %s", dn, username)
+
+	return backend.delegate.Authenticate(ctx, username, password)
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	base, err := dn.Parse(pkg.SearchBase(ctx))
+	if err != nil || !backend.covers(base) {
+		return []*pkg.User{}, nil
+	}
+
+	// the rewritten dns don't exist in the delegate, so it searches all of its
+	// users and the base and the scope are applied to the rewritten dns
+	users, err := backend.delegate.GetUsers(pkg.WithSearch(ctx, "", ldap.ScopeWholeSubtree), f)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := pkg.SearchScope(ctx)
+	result := make([]*pkg.User, 0, len(users))
+	for _, user := range users {
+		userDn, ok := backend.Dn(user)
+		if !ok {
+			log.Printf("rewrite: no dn for user %s of %s", user.DN, backend.Name())
+			continue
+		}
+
+		if !inScope(userDn, base, scope) {
+			continue
+		}
+
+		result = append(result, &pkg.User{
+			DN:         userDn,
+			Attributes: user.Attributes,
+		})
+	}
+
+	return result, nil
+}
+
+// covers reports whether a rule may format dns within the base
+func (backend *Backend) covers(base dn.DN) bool {
+	for _, r := range backend.rules {
+		suffix, ok := r.suffix()
+		if ok && (base.IsWithin(suffix) || suffix.IsWithin(base)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inScope reports whether the dn is located within the scope of the base
+func inScope(s string, base dn.DN, scope ldap.Scope) bool {
+	parsed, err := dn.Parse(s)
+	if err != nil || !parsed.IsWithin(base) {
+		return false
+	}
+
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return len(parsed) == len(base)
+	case ldap.ScopeSingleLevel:
+		return len(parsed) == len(base)+1
+	case ldap.ScopeChildren:
+		return len(parsed) > len(base)
+	default:
+		return true
+	}
+}
+
+func (backend *Backend) Username(dn string) (string, bool) {
+	for _, r := range backend.rules {
+		values, ok := r.parse(dn)
+		if !ok {
+			continue
+		}
+
+		username, ok := values[strings.ToLower(backend.config.usernameAttribute())]
+		if ok && username != "" {
+			return username, true
+		}
+	}
+
+	return "", false
+}
+
+// Dn maps a user of the delegate to the dn of the clients
+func (backend *Backend) Dn(user *pkg.User) (string, bool) {
+	for _, r := range backend.rules {
+		if dn, ok := r.format(user.Attributes); ok {
+			return dn, true
+		}
+	}
+
+	return "", false
+}
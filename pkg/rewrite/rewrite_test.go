@@ -0,0 +1,260 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rewrite
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type testBackend struct {
+	lastUsername string
+	lastPassword string
+
+	result bool
+	users  []*pkg.User
+
+	searched  bool
+	lastBase  string
+	lastScope ldap.Scope
+}
+
+func (backend *testBackend) Authenticate(ctx context.Context, username string, password string) bool {
+	backend.lastUsername = username
+	backend.lastPassword = password
+
+	return backend.result
+}
+
+func (backend *testBackend) Name() (name string) {
+	return "test"
+}
+
+func (backend *testBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.searched = true
+	backend.lastBase = pkg.SearchBase(ctx)
+	backend.lastScope = pkg.SearchScope(ctx)
+
+	return backend.users, nil
+}
+
+func TestLegacyConfig_Authenticate(t *testing.T) {
+	Convey("Given a backend with the legacy stripper config", t, func() {
+		backend := &testBackend{
+			lastUsername: "none",
+			lastPassword: "nothing",
+			result:       true,
+		}
+
+		rewrite, err := NewBackend(backend, LegacyConfig("dc=example,dc=com", "ou=People", "uid"))
+		So(err, ShouldBeNil)
+
+		Convey("When the backend is invoced with a matching dn", func() {
+			result := rewrite.Authenticate(context.Background(), "uid=admin,ou=People,dc=example,dc=com", "password")
+
+			Convey("Then the user will be stripped, the other parameters will be passed correctly", func() {
+				So(result, ShouldBeTrue)
+				So(backend.lastUsername, ShouldEqual, "admin")
+				So(backend.lastPassword, ShouldEqual, "password")
+			})
+		})
+		Convey("When the backend is invoced with a differently formatted dn", func() {
+			result := rewrite.Authenticate(context.Background(), "UID=admin, ou=people, DC=example, dc=com", "password")
+
+			Convey("Then the dn is compared like a dn", func() {
+				So(result, ShouldBeTrue)
+				So(backend.lastUsername, ShouldEqual, "admin")
+			})
+		})
+		Convey("When the backend is invoced with an invalid prefix", func() {
+			result := rewrite.Authenticate(context.Background(), "cn=admin,ou=People,dc=example,dc=com", "password")
+
+			Convey("Then the backend will return false without calling the delegate", func() {
+				So(result, ShouldBeFalse)
+				So(backend.lastUsername, ShouldEqual, "none")
+				So(backend.lastPassword, ShouldEqual, "nothing")
+			})
+		})
+		Convey("When the backend is invoced with an invalid suffix", func() {
+			result := rewrite.Authenticate(context.Background(), "uid=admin,dc=com", "password")
+
+			Convey("Then the backend will return false without calling the delegate", func() {
+				So(result, ShouldBeFalse)
+				So(backend.lastUsername, ShouldEqual, "none")
+				So(backend.lastPassword, ShouldEqual, "nothing")
+			})
+		})
+	})
+}
+
+func TestLegacyConfig_GetUsers(t *testing.T) {
+	Convey("Given a backend with the legacy stripper config", t, func() {
+		backend := &testBackend{
+			users: []*pkg.User{
+				{Attributes: map[string][]string{"uid": {"user1"}}},
+				{Attributes: map[string][]string{"cn": {"without uid"}}},
+			},
+		}
+
+		rewrite, err := NewBackend(backend, LegacyConfig("dc=example,dc=com", "ou=People", "uid"))
+		So(err, ShouldBeNil)
+
+		Convey("When the users are requested", func() {
+			users, err := rewrite.GetUsers(context.Background(), nil)
+
+			Convey("Then the dn is wrapped with the pre and suffix", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "uid=user1,ou=People,dc=example,dc=com")
+			})
+		})
+	})
+}
+
+func TestBackend_SearchBase(t *testing.T) {
+	Convey("Given a backend with the legacy stripper config", t, func() {
+		backend := &testBackend{
+			users: []*pkg.User{
+				{DN: "uid=user1,ou=users,o=upstream", Attributes: map[string][]string{"uid": {"user1"}}},
+				{DN: "uid=user2,ou=users,o=upstream", Attributes: map[string][]string{"uid": {"user2"}}},
+			},
+		}
+
+		rewrite, err := NewBackend(backend, LegacyConfig("dc=example,dc=com", "ou=People", "uid"))
+		So(err, ShouldBeNil)
+
+		search := func(base string, scope ldap.Scope) []string {
+			backend.searched = false
+			users, err := rewrite.GetUsers(pkg.WithSearch(context.Background(), base, scope), nil)
+			So(err, ShouldBeNil)
+
+			dns := []string{}
+			for _, user := range users {
+				dns = append(dns, user.DN)
+			}
+			return dns
+		}
+
+		Convey("When the users below the people rdn are requested", func() {
+			dns := search("ou=People,dc=example,dc=com", ldap.ScopeSingleLevel)
+
+			Convey("Then the delegate searches all of its users", func() {
+				So(backend.searched, ShouldBeTrue)
+				So(backend.lastBase, ShouldEqual, "")
+				So(backend.lastScope, ShouldEqual, ldap.ScopeWholeSubtree)
+				So(dns, ShouldResemble, []string{"uid=user1,ou=People,dc=example,dc=com", "uid=user2,ou=People,dc=example,dc=com"})
+			})
+		})
+
+		Convey("When a single user is requested", func() {
+			dns := search("UID=user2, ou=people, dc=example, dc=com", ldap.ScopeBaseObject)
+
+			Convey("Then only the user is returned", func() {
+				So(dns, ShouldResemble, []string{"uid=user2,ou=People,dc=example,dc=com"})
+			})
+		})
+
+		Convey("When the base is above the people rdn", func() {
+			So(search("dc=com", ldap.ScopeWholeSubtree), ShouldHaveLength, 2)
+			So(search("dc=com", ldap.ScopeSingleLevel), ShouldBeEmpty)
+		})
+
+		Convey("When the base is outside of the rewritten dns", func() {
+			dns := search("ou=users,o=upstream", ldap.ScopeWholeSubtree)
+
+			Convey("Then the delegate isn't searched", func() {
+				So(dns, ShouldBeEmpty)
+				So(backend.searched, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given rules for multiple suffixes and aliases", t, func() {
+		backend := &testBackend{
+			result: true,
+			users: []*pkg.User{
+				{Attributes: map[string][]string{"uid": {"alice"}, "department": {"sales"}}},
+				{Attributes: map[string][]string{"uid": {"smith, bob"}}},
+			},
+		}
+
+		rewrite, err := NewBackend(backend, &Config{
+			Rules: []Rule{
+				{Template: "uid={uid},ou={department},ou=People,dc=example,dc=com"},
+				{Template: "uid={uid},ou=People,dc=example,dc=com"},
+				{Template: "cn={uid},ou=People,dc=example,dc=com"},
+				{Match: `(?i)^uid=(?P<uid>[^,]+),ou=users,dc=example,dc=(com|org)$`},
+			},
+		})
+		So(err, ShouldBeNil)
+
+		bind := func(dn string) string {
+			backend.lastUsername = ""
+			rewrite.Authenticate(context.Background(), dn, "password")
+			return backend.lastUsername
+		}
+
+		Convey("Then the usernames are extracted from all rules", func() {
+			So(bind("uid=alice,ou=sales,ou=People,dc=example,dc=com"), ShouldEqual, "alice")
+			So(bind("uid=alice,ou=People,dc=example,dc=com"), ShouldEqual, "alice")
+			So(bind("cn=alice,ou=People,dc=example,dc=com"), ShouldEqual, "alice")
+			So(bind("uid=alice,ou=users,dc=example,dc=org"), ShouldEqual, "alice")
+			So(bind("uid=alice,ou=groups,dc=example,dc=org"), ShouldEqual, "")
+		})
+
+		Convey("Then escaped values are unescaped", func() {
+			So(bind(`uid=smith\, bob,ou=People,dc=example,dc=com`), ShouldEqual, "smith, bob")
+			So(bind(`uid=smith\2c bob,ou=People,dc=example,dc=com`), ShouldEqual, "smith, bob")
+		})
+
+		Convey("Then the dns of the users are created with the first matching template", func() {
+			users, err := rewrite.GetUsers(context.Background(), nil)
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 2)
+			So(users[0].DN, ShouldEqual, "uid=alice,ou=sales,ou=People,dc=example,dc=com")
+			So(users[1].DN, ShouldEqual, `uid=smith\, bob,ou=People,dc=example,dc=com`)
+		})
+
+		Convey("Then the dns map back to the usernames", func() {
+			username, ok := rewrite.Username(`uid=smith\, bob,ou=People,dc=example,dc=com`)
+
+			So(ok, ShouldBeTrue)
+			So(username, ShouldEqual, "smith, bob")
+		})
+	})
+
+	Convey("Given invalid rules", t, func() {
+		_, err := NewBackend(&testBackend{}, &Config{})
+		So(err, ShouldEqual, errNoRules)
+
+		_, err = NewBackend(&testBackend{}, &Config{Rules: []Rule{{Template: "cn={cn},dc=example,dc=com"}}})
+		So(err, ShouldNotBeNil)
+
+		_, err = NewBackend(&testBackend{}, &Config{Rules: []Rule{{Match: "("}}})
+		So(err, ShouldNotBeNil)
+	})
+}
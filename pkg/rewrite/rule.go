@@ -0,0 +1,242 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package rewrite
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"regexp"
+	"strings"
+)
+
+var (
+	errNoRule     = errors.New("rewrite: a rule needs a template or a match")
+	errNoUsername = errors.New("rewrite: the rule doesn't contain the username attribute")
+
+	placeholderRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+)
+
+// Rule maps between the dns of the clients and the users of the backend. A
+// rule is either a template like uid={uid},ou=People,dc=example,dc=com which
+// is used in both directions or a regular expression with named groups which
+// matches incoming dns and an optional template for outgoing dns.
+type Rule struct {
+	Template string `json:"template"`
+	Match    string `json:"match"`
+	Dn       string `json:"dn"`
+}
+
+type rule struct {
+	match        *regexp.Regexp
+	placeholders []string
+	rdns         [][]*value
+	template     dn.DN
+}
+
+// value matches a single attribute value of a template
+type value struct {
+	attributeType string
+	match         *regexp.Regexp
+	placeholders  []string
+}
+
+func compileRule(config Rule, usernameAttribute string) (r *rule, err error) {
+	r = &rule{}
+
+	switch {
+	case config.Template != "":
+		r.template, err = dn.Parse(config.Template)
+		if err != nil {
+			return nil, err
+		}
+
+		r.compileTemplate()
+	case config.Match != "":
+		r.match, err = regexp.Compile(config.Match)
+		if err != nil {
+			return nil, err
+		}
+
+		r.placeholders = r.match.SubexpNames()
+
+		if config.Dn != "" {
+			r.template, err = dn.Parse(config.Dn)
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, errNoRule
+	}
+
+	for _, placeholder := range r.placeholders {
+		if strings.EqualFold(placeholder, usernameAttribute) {
+			return r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%v: %s", errNoUsername, usernameAttribute)
+}
+
+// compileTemplate creates a case insensitive regular expression for each
+// value of the template.
+func (r *rule) compileTemplate() {
+	for _, rdn := range r.template {
+		values := []*value{}
+
+		for _, ava := range rdn {
+			v := &value{attributeType: dn.NormalizeType(ava.Type)}
+
+			pattern := ""
+			last := 0
+			for _, match := range placeholderRegex.FindAllStringSubmatchIndex(ava.Value, -1) {
+				pattern += regexp.QuoteMeta(ava.Value[last:match[0]]) + "(.+)"
+				v.placeholders = append(v.placeholders, ava.Value[match[2]:match[3]])
+				last = match[1]
+			}
+			pattern += regexp.QuoteMeta(ava.Value[last:])
+
+			v.match = regexp.MustCompile("(?i)^" + pattern + "$")
+			r.placeholders = append(r.placeholders, v.placeholders...)
+			values = append(values, v)
+		}
+
+		r.rdns = append(r.rdns, values)
+	}
+}
+
+// parse matches the dn and returns the values of the placeholders
+func (r *rule) parse(s string) (map[string]string, bool) {
+	if r.match != nil {
+		return r.parseMatch(s)
+	}
+
+	parsed, err := dn.Parse(s)
+	if err != nil || len(parsed) != len(r.rdns) {
+		return nil, false
+	}
+
+	values := map[string]string{}
+	for i, rdn := range parsed {
+		if len(rdn) != len(r.rdns[i]) {
+			return nil, false
+		}
+
+		for _, v := range r.rdns[i] {
+			if !v.parse(rdn, values) {
+				return nil, false
+			}
+		}
+	}
+
+	return values, true
+}
+
+// parse matches the value against the value of the same type in the rdn
+func (v *value) parse(rdn dn.RDN, values map[string]string) bool {
+	for _, ava := range rdn {
+		if dn.NormalizeType(ava.Type) != v.attributeType {
+			continue
+		}
+
+		groups := v.match.FindStringSubmatch(ava.Value)
+		if groups == nil {
+			return false
+		}
+
+		for i, name := range v.placeholders {
+			values[strings.ToLower(name)] = groups[i+1]
+		}
+		return true
+	}
+
+	return false
+}
+
+func (r *rule) parseMatch(s string) (map[string]string, bool) {
+	groups := r.match.FindStringSubmatch(s)
+	if groups == nil {
+		return nil, false
+	}
+
+	values := map[string]string{}
+	for i, name := range r.placeholders {
+		if name == "" || i >= len(groups) {
+			continue
+		}
+
+		value, err := dn.UnescapeValue(groups[i])
+		if err != nil {
+			value = groups[i]
+		}
+		values[strings.ToLower(name)] = value
+	}
+
+	return values, true
+}
+
+// suffix returns the trailing rdns of the template without placeholders, the
+// formatted dns are located below it. Rules without template format no dns.
+func (r *rule) suffix() (dn.DN, bool) {
+	if r.template == nil {
+		return nil, false
+	}
+
+	i := len(r.template)
+	for i > 0 && !placeholderRegex.MatchString(r.template[i-1].String()) {
+		i--
+	}
+
+	return r.template[i:], true
+}
+
+// format fills the template with the first values of the attributes. It fails
+// if the rule has no template or an attribute is missing.
+func (r *rule) format(attributes map[string][]string) (string, bool) {
+	if r.template == nil {
+		return "", false
+	}
+
+	ok := true
+	result := make(dn.DN, len(r.template))
+	for i, rdn := range r.template {
+		result[i] = make(dn.RDN, len(rdn))
+		for j, ava := range rdn {
+			result[i][j] = dn.AttributeTypeAndValue{
+				Type: ava.Type,
+				Value: placeholderRegex.ReplaceAllStringFunc(ava.Value, func(placeholder string) string {
+					name := placeholder[1 : len(placeholder)-1]
+					for attribute, values := range attributes {
+						if strings.EqualFold(attribute, name) && len(values) > 0 {
+							return values[0]
+						}
+					}
+
+					ok = false
+					return ""
+				}),
+			}
+		}
+	}
+
+	return result.String(), ok
+}
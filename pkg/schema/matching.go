@@ -0,0 +1,184 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package schema
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var integerRegex = regexp.MustCompile(`^-?[0-9]+$`)
+
+// the layouts of generalized times, fractions of seconds are accepted by
+// time.Parse without being part of the layout
+const (
+	generalizedTimeLayout     = "20060102150405Z0700"
+	normalizedGeneralizedTime = "20060102150405.000000000Z"
+)
+
+// MatchingRule compares the normalized values of an attribute.
+type MatchingRule struct {
+	Name string
+	// Normalize returns the canonical form of the value or false if the value
+	// is invalid, the comparison is undefined then
+	Normalize func(value string) (string, bool)
+	// Compare orders two normalized values, only used by ordering rules
+	Compare func(a, b string) int
+}
+
+// the default matching rules of a syntax
+type syntax struct {
+	equality string
+	ordering string
+	substr   string
+}
+
+var syntaxes = map[string]syntax{
+	"directoryString": {"caseIgnoreMatch", "caseIgnoreOrderingMatch", "caseIgnoreSubstringsMatch"},
+	"ia5String":       {"caseExactMatch", "caseExactOrderingMatch", "caseExactSubstringsMatch"},
+	"integer":         {"integerMatch", "integerOrderingMatch", ""},
+	"telephoneNumber": {"telephoneNumberMatch", "", "telephoneNumberSubstringsMatch"},
+	"dn":              {"distinguishedNameMatch", "", ""},
+	"octetString":     {"octetStringMatch", "octetStringOrderingMatch", ""},
+	"generalizedTime": {"generalizedTimeMatch", "generalizedTimeOrderingMatch", ""},
+}
+
+// the dn package replaces the distinguishedNameMatch of the default schema
+// with one comparing the parsed dns
+var standardRules = []MatchingRule{
+	{Name: "caseIgnoreMatch", Normalize: caseIgnore},
+	{Name: "caseIgnoreOrderingMatch", Normalize: caseIgnore, Compare: strings.Compare},
+	{Name: "caseIgnoreSubstringsMatch", Normalize: lowerCase},
+	{Name: "caseExactMatch", Normalize: caseExact},
+	{Name: "caseExactOrderingMatch", Normalize: caseExact, Compare: strings.Compare},
+	{Name: "caseExactSubstringsMatch", Normalize: identity},
+	{Name: "integerMatch", Normalize: integer},
+	{Name: "integerOrderingMatch", Normalize: integer, Compare: compareIntegers},
+	{Name: "telephoneNumberMatch", Normalize: telephoneNumber},
+	{Name: "telephoneNumberSubstringsMatch", Normalize: telephoneNumber},
+	{Name: "distinguishedNameMatch", Normalize: caseIgnore},
+	{Name: "octetStringMatch", Normalize: identity},
+	{Name: "octetStringOrderingMatch", Normalize: identity, Compare: strings.Compare},
+	{Name: "generalizedTimeMatch", Normalize: generalizedTime},
+	{Name: "generalizedTimeOrderingMatch", Normalize: generalizedTime, Compare: strings.Compare},
+}
+
+func identity(value string) (string, bool) {
+	return value, true
+}
+
+func lowerCase(value string) (string, bool) {
+	return strings.ToLower(value), true
+}
+
+// caseExact removes leading and trailing spaces and folds inner spaces
+func caseExact(value string) (string, bool) {
+	return strings.Join(strings.Fields(value), " "), true
+}
+
+// caseIgnore additionally ignores the case
+func caseIgnore(value string) (string, bool) {
+	value, _ = caseExact(value)
+	return strings.ToLower(value), true
+}
+
+// integer removes leading zeros
+func integer(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if !integerRegex.MatchString(value) {
+		return "", false
+	}
+
+	sign := ""
+	if strings.HasPrefix(value, "-") {
+		sign, value = "-", value[1:]
+	}
+
+	value = strings.TrimLeft(value, "0")
+	if value == "" {
+		return "0", true
+	}
+
+	return sign + value, true
+}
+
+// generalizedTime converts the time to UTC with a fixed number of digits, so
+// the normalized values are ordered as strings
+func generalizedTime(value string) (string, bool) {
+	t, ok := ParseGeneralizedTime(value)
+	if !ok {
+		return "", false
+	}
+
+	return t.UTC().Format(normalizedGeneralizedTime), true
+}
+
+// ParseGeneralizedTime parses a generalized time like 20240102030405Z or
+// 20240102030405.5+0100
+func ParseGeneralizedTime(value string) (time.Time, bool) {
+	t, err := time.Parse(generalizedTimeLayout, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// telephoneNumber ignores spaces and hyphens
+func telephoneNumber(value string) (string, bool) {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, value), true
+}
+
+// compareIntegers orders normalized integers of any size
+func compareIntegers(a, b string) int {
+	negativeA, negativeB := strings.HasPrefix(a, "-"), strings.HasPrefix(b, "-")
+	if negativeA != negativeB {
+		if negativeA {
+			return -1
+		}
+		return 1
+	}
+
+	result := len(a) - len(b)
+	if result == 0 {
+		result = strings.Compare(a, b)
+	}
+
+	if negativeA {
+		result = -result
+	}
+
+	switch {
+	case result < 0:
+		return -1
+	case result > 0:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	errMissingName         = errors.New("schema: attribute type without name")
+	errUnknownSyntax       = errors.New("schema: unknown syntax")
+	errUnknownMatchingRule = errors.New("schema: unknown matching rule")
+)
+
+// the syntax of attribute types which aren't defined in the schema
+const defaultSyntax = "directoryString"
+
+// Default is the schema used by the filters and dns of all backends. It
+// contains the standard attribute types and can be extended on startup.
+var Default = Standard()
+
+// AttributeType describes how the values of an attribute are compared. The
+// matching rules default to the ones of the syntax.
+type AttributeType struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases"`
+	Syntax   string   `json:"syntax"`
+	Equality string   `json:"equality"`
+	Ordering string   `json:"ordering"`
+	Substr   string   `json:"substr"`
+}
+
+type Config struct {
+	AttributeTypes []AttributeType `json:"attributeTypes"`
+}
+
+type Schema struct {
+	lock sync.RWMutex
+
+	// the attribute types by their lower case names, aliases and oids
+	types map[string]*AttributeType
+	rules map[string]*MatchingRule
+}
+
+func New() *Schema {
+	return &Schema{
+		types: make(map[string]*AttributeType),
+		rules: make(map[string]*MatchingRule),
+	}
+}
+
+// Standard returns a new schema with the standard matching rules and
+// attribute types.
+func Standard() *Schema {
+	schema := New()
+
+	for _, rule := range standardRules {
+		schema.AddMatchingRule(rule)
+	}
+
+	for _, attributeType := range standardTypes {
+		err := schema.AddAttributeType(attributeType)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return schema
+}
+
+// AddMatchingRule adds or replaces the matching rule.
+func (schema *Schema) AddMatchingRule(rule MatchingRule) {
+	schema.lock.Lock()
+	defer schema.lock.Unlock()
+
+	schema.rules[strings.ToLower(rule.Name)] = &rule
+}
+
+// AddAttributeType adds the attribute type, existing types with the same names
+// are replaced.
+func (schema *Schema) AddAttributeType(attributeType AttributeType) error {
+	if attributeType.Name == "" {
+		return errMissingName
+	}
+
+	if attributeType.Syntax == "" {
+		attributeType.Syntax = defaultSyntax
+	}
+
+	syntax, ok := syntaxes[attributeType.Syntax]
+	if !ok {
+		return fmt.Errorf("%v: %s", errUnknownSyntax, attributeType.Syntax)
+	}
+
+	if attributeType.Equality == "" {
+		attributeType.Equality = syntax.equality
+	}
+	if attributeType.Ordering == "" {
+		attributeType.Ordering = syntax.ordering
+	}
+	if attributeType.Substr == "" {
+		attributeType.Substr = syntax.substr
+	}
+
+	schema.lock.Lock()
+	defer schema.lock.Unlock()
+
+	for _, rule := range []string{attributeType.Equality, attributeType.Ordering, attributeType.Substr} {
+		if _, ok := schema.rules[strings.ToLower(rule)]; rule != "" && !ok {
+			return fmt.Errorf("%v: %s", errUnknownMatchingRule, rule)
+		}
+	}
+
+	for _, name := range append([]string{attributeType.Name}, attributeType.Aliases...) {
+		schema.types[key(name)] = &attributeType
+	}
+
+	return nil
+}
+
+// Load adds the attribute types of the json configuration.
+func (schema *Schema) Load(reader io.Reader) error {
+	config := &Config{}
+	err := json.NewDecoder(reader).Decode(config)
+	if err != nil {
+		return err
+	}
+
+	for _, attributeType := range config.AttributeTypes {
+		err = schema.AddAttributeType(attributeType)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lookup returns the attribute type by its name, alias or oid. Unknown
+// attribute types have the default syntax.
+func (schema *Schema) Lookup(name string) *AttributeType {
+	schema.lock.RLock()
+	defer schema.lock.RUnlock()
+
+	if attributeType, ok := schema.types[key(name)]; ok {
+		return attributeType
+	}
+
+	syntax := syntaxes[defaultSyntax]
+	return &AttributeType{
+		Name:     key(name),
+		Syntax:   defaultSyntax,
+		Equality: syntax.equality,
+		Ordering: syntax.ordering,
+		Substr:   syntax.substr,
+	}
+}
+
+// Key returns the lower case name of the attribute type, all names of an
+// attribute type have the same key.
+func (schema *Schema) Key(name string) string {
+	return strings.ToLower(schema.Lookup(name).Name)
+}
+
+// Values returns the values of all attributes of the given type, regardless
+// of the name used for the attribute.
+func (schema *Schema) Values(attributes map[string][]string, name string) []string {
+	if values, ok := attributes[name]; ok {
+		return values
+	}
+
+	k := schema.Key(name)
+
+	var values []string
+	for attribute, v := range attributes {
+		if schema.Key(attribute) == k {
+			values = append(values, v...)
+		}
+	}
+
+	return values
+}
+
+// Normalize returns the value in the form used by the equality matching rule
+// of the attribute type. Invalid values are returned unchanged.
+func (schema *Schema) Normalize(name, value string) string {
+	rule := schema.rule(schema.Lookup(name).Equality)
+	if rule == nil {
+		return value
+	}
+
+	if normalized, ok := rule.Normalize(value); ok {
+		return normalized
+	}
+
+	return value
+}
+
+// Equal compares the values with the equality matching rule of the attribute
+// type. The result is false if a value is invalid or there is no rule.
+func (schema *Schema) Equal(name, a, b string) bool {
+	rule := schema.rule(schema.Lookup(name).Equality)
+	if rule == nil {
+		return false
+	}
+
+	normalizedA, okA := rule.Normalize(a)
+	normalizedB, okB := rule.Normalize(b)
+
+	return okA && okB && normalizedA == normalizedB
+}
+
+// Compare orders the values with the ordering matching rule of the attribute
+// type. The result is false if a value is invalid or there is no rule.
+func (schema *Schema) Compare(name, a, b string) (int, bool) {
+	rule := schema.rule(schema.Lookup(name).Ordering)
+	if rule == nil || rule.Compare == nil {
+		return 0, false
+	}
+
+	normalizedA, okA := rule.Normalize(a)
+	normalizedB, okB := rule.Normalize(b)
+	if !okA || !okB {
+		return 0, false
+	}
+
+	return rule.Compare(normalizedA, normalizedB), true
+}
+
+// MatchSubstrings matches the value with the substrings matching rule of the
+// attribute type.
+func (schema *Schema) MatchSubstrings(name, value, initial string, any []string, final string) bool {
+	rule := schema.rule(schema.Lookup(name).Substr)
+	if rule == nil {
+		return false
+	}
+
+	normalize := func(s string) string {
+		normalized, _ := rule.Normalize(s)
+		return normalized
+	}
+
+	value = normalize(value)
+
+	initial = normalize(initial)
+	if !strings.HasPrefix(value, initial) {
+		return false
+	}
+	value = value[len(initial):]
+
+	for _, a := range any {
+		a = normalize(a)
+
+		i := strings.Index(value, a)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(a):]
+	}
+
+	return strings.HasSuffix(value, normalize(final))
+}
+
+func (schema *Schema) rule(name string) *MatchingRule {
+	if name == "" {
+		return nil
+	}
+
+	schema.lock.RLock()
+	defer schema.lock.RUnlock()
+
+	return schema.rules[strings.ToLower(name)]
+}
+
+func key(name string) string {
+	name = strings.ToLower(name)
+	if strings.HasPrefix(name, "oid.") {
+		return name[len("oid."):]
+	}
+
+	return name
+}
@@ -0,0 +1,144 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package schema
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestSchema_Lookup(t *testing.T) {
+	Convey("Given the standard schema", t, func() {
+		schema := Standard()
+
+		Convey("Attribute types are found by name, alias and oid", func() {
+			for _, name := range []string{"cn", "CN", "commonName", "2.5.4.3", "OID.2.5.4.3"} {
+				So(schema.Lookup(name).Name, ShouldEqual, "cn")
+				So(schema.Key(name), ShouldEqual, "cn")
+			}
+		})
+
+		Convey("Unknown attribute types have the default syntax", func() {
+			attributeType := schema.Lookup("roomNumber")
+			So(attributeType.Name, ShouldEqual, "roomnumber")
+			So(attributeType.Syntax, ShouldEqual, defaultSyntax)
+			So(attributeType.Equality, ShouldEqual, "caseIgnoreMatch")
+		})
+
+		Convey("The values of all names of an attribute are returned", func() {
+			values := schema.Values(map[string][]string{"commonName": {"a"}, "CN": {"b"}, "sn": {"c"}}, "cn")
+			So(values, ShouldHaveLength, 2)
+			So(values, ShouldContain, "a")
+			So(values, ShouldContain, "b")
+		})
+	})
+}
+
+func TestSchema_Match(t *testing.T) {
+	Convey("Given the standard schema", t, func() {
+		schema := Standard()
+
+		Convey("Values are compared by the equality matching rule", func() {
+			So(schema.Equal("cn", " Alice  Smith", "alice smith"), ShouldBeTrue)
+			So(schema.Equal("loginShell", "/bin/bash", "/BIN/BASH"), ShouldBeFalse)
+			So(schema.Equal("uidNumber", "0042", "42"), ShouldBeTrue)
+			So(schema.Equal("uidNumber", "x", "x"), ShouldBeFalse)
+			So(schema.Equal("telephoneNumber", "+1 555-0100", "+15550100"), ShouldBeTrue)
+			So(schema.Equal("userPassword", "secret", "Secret"), ShouldBeFalse)
+		})
+
+		Convey("Values are ordered by the ordering matching rule", func() {
+			result, ok := schema.Compare("uidNumber", "1000", "999")
+			So(ok, ShouldBeTrue)
+			So(result, ShouldEqual, 1)
+
+			result, ok = schema.Compare("uidNumber", "-1000", "-999")
+			So(ok, ShouldBeTrue)
+			So(result, ShouldEqual, -1)
+
+			result, ok = schema.Compare("cn", "Bob", "alice")
+			So(ok, ShouldBeTrue)
+			So(result, ShouldEqual, 1)
+
+			result, ok = schema.Compare("createTimestamp", "20240102030405Z", "20240102030405.5+0100")
+			So(ok, ShouldBeTrue)
+			So(result, ShouldEqual, 1)
+
+			_, ok = schema.Compare("telephoneNumber", "1", "2")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Substrings are matched by the substrings matching rule", func() {
+			So(schema.MatchSubstrings("mail", "Alice@Example.com", "alice", []string{"@"}, ".COM"), ShouldBeTrue)
+			So(schema.MatchSubstrings("telephoneNumber", "+1 555-0100", "", []string{"55501"}, ""), ShouldBeTrue)
+			So(schema.MatchSubstrings("loginShell", "/bin/bash", "/BIN", nil, ""), ShouldBeFalse)
+			So(schema.MatchSubstrings("uidNumber", "1000", "1", nil, ""), ShouldBeFalse)
+		})
+	})
+}
+
+func TestSchema_Load(t *testing.T) {
+	Convey("Given the standard schema", t, func() {
+		schema := Standard()
+
+		Convey("When attribute types are loaded", func() {
+			err := schema.Load(strings.NewReader(`{"attributeTypes": [
+				{"name": "employeeNumber", "aliases": ["2.16.840.1.113730.3.1.3"], "syntax": "integer"},
+				{"name": "roomNumber", "equality": "caseExactMatch"}
+			]}`))
+
+			Convey("Then the attribute types are used", func() {
+				So(err, ShouldBeNil)
+				So(schema.Equal("2.16.840.1.113730.3.1.3", "007", "7"), ShouldBeTrue)
+				So(schema.Equal("roomNumber", "A1", "a1"), ShouldBeFalse)
+
+				result, ok := schema.Compare("roomNumber", "b", "a")
+				So(ok, ShouldBeTrue)
+				So(result, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When an attribute type references an unknown matching rule", func() {
+			err := schema.Load(strings.NewReader(`{"attributeTypes": [{"name": "roomNumber", "equality": "fuzzyMatch"}]}`))
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When an attribute type has an unknown syntax", func() {
+			err := schema.Load(strings.NewReader(`{"attributeTypes": [{"name": "roomNumber", "syntax": "boolean"}]}`))
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When an attribute type has no name", func() {
+			err := schema.Load(strings.NewReader(`{"attributeTypes": [{"syntax": "integer"}]}`))
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldEqual, errMissingName)
+			})
+		})
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package schema
+
+// the attribute types of the core, cosine, inetorgperson and nis schemas and
+// the operational timestamps
+var standardTypes = []AttributeType{
+	{Name: "objectClass", Aliases: []string{"2.5.4.0"}},
+	{Name: "cn", Aliases: []string{"commonName", "2.5.4.3"}},
+	{Name: "sn", Aliases: []string{"surname", "2.5.4.4"}},
+	{Name: "c", Aliases: []string{"countryName", "2.5.4.6"}},
+	{Name: "l", Aliases: []string{"localityName", "2.5.4.7"}},
+	{Name: "st", Aliases: []string{"stateOrProvinceName", "2.5.4.8"}},
+	{Name: "street", Aliases: []string{"streetAddress", "2.5.4.9"}},
+	{Name: "o", Aliases: []string{"organizationName", "2.5.4.10"}},
+	{Name: "ou", Aliases: []string{"organizationalUnitName", "2.5.4.11"}},
+	{Name: "title", Aliases: []string{"2.5.4.12"}},
+	{Name: "description", Aliases: []string{"2.5.4.13"}},
+	{Name: "telephoneNumber", Aliases: []string{"2.5.4.20"}, Syntax: "telephoneNumber"},
+	{Name: "facsimileTelephoneNumber", Aliases: []string{"fax", "2.5.4.23"}, Syntax: "telephoneNumber"},
+	{Name: "member", Aliases: []string{"2.5.4.31"}, Syntax: "dn"},
+	{Name: "owner", Aliases: []string{"2.5.4.32"}, Syntax: "dn"},
+	{Name: "seeAlso", Aliases: []string{"2.5.4.34"}, Syntax: "dn"},
+	{Name: "userPassword", Aliases: []string{"2.5.4.35"}, Syntax: "octetString"},
+	{Name: "givenName", Aliases: []string{"gn", "2.5.4.42"}},
+	{Name: "uid", Aliases: []string{"userid", "0.9.2342.19200300.100.1.1"}},
+	{Name: "mail", Aliases: []string{"rfc822Mailbox", "0.9.2342.19200300.100.1.3"}, Syntax: "ia5String", Equality: "caseIgnoreMatch", Substr: "caseIgnoreSubstringsMatch"},
+	{Name: "manager", Aliases: []string{"0.9.2342.19200300.100.1.10"}, Syntax: "dn"},
+	{Name: "dc", Aliases: []string{"domainComponent", "0.9.2342.19200300.100.1.25"}, Syntax: "ia5String", Equality: "caseIgnoreMatch", Substr: "caseIgnoreSubstringsMatch"},
+	{Name: "mobile", Aliases: []string{"mobileTelephoneNumber", "0.9.2342.19200300.100.1.41"}, Syntax: "telephoneNumber"},
+	{Name: "employeeNumber", Aliases: []string{"2.16.840.1.113730.3.1.3"}},
+	{Name: "displayName", Aliases: []string{"2.16.840.1.113730.3.1.241"}},
+	{Name: "uidNumber", Aliases: []string{"1.3.6.1.1.1.1.0"}, Syntax: "integer"},
+	{Name: "gidNumber", Aliases: []string{"1.3.6.1.1.1.1.1"}, Syntax: "integer"},
+	{Name: "homeDirectory", Aliases: []string{"1.3.6.1.1.1.1.3"}, Syntax: "ia5String"},
+	{Name: "loginShell", Aliases: []string{"1.3.6.1.1.1.1.4"}, Syntax: "ia5String"},
+	{Name: "memberUid", Aliases: []string{"1.3.6.1.1.1.1.12"}, Syntax: "ia5String"},
+	{Name: "memberOf", Aliases: []string{"1.2.840.113556.1.2.102"}, Syntax: "dn"},
+	{Name: "createTimestamp", Aliases: []string{"2.5.18.1"}, Syntax: "generalizedTime"},
+	{Name: "modifyTimestamp", Aliases: []string{"2.5.18.2"}, Syntax: "generalizedTime"},
+}
@@ -0,0 +1,270 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+	// the maximum size of the output of the search command
+	maxOutput = 16 << 20
+)
+
+var (
+	errNoAuthCommand  = errors.New("exec: no auth command configured")
+	errOutputTooLarge = errors.New("exec: output of the search command too large")
+)
+
+type backendFactory struct{}
+
+var _ pkg.BackendFactory = &backendFactory{}
+
+func NewFactory() (factory pkg.BackendFactory) {
+	return &backendFactory{}
+}
+
+func (backendFactory) Name() (name string) {
+	return "exec"
+}
+
+func (backendFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (backendFactory) New(untypedConfig interface{}) (bknd pkg.Backend, err error) {
+	config, ok := untypedConfig.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	bknd, err = NewBackend(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+type Config struct {
+	pkg.Config
+	AuthCommand   []string          `json:"authCommand"`
+	SearchCommand []string          `json:"searchCommand"`
+	Dir           string            `json:"dir"`
+	Env           map[string]string `json:"env"`
+	Timeout       util.Duration     `json:"timeout"`
+	MaxProcesses  int               `json:"maxProcesses"`
+}
+
+// The input of the search command
+type searchRequest struct {
+	BaseDn string           `json:"baseDn"`
+	Filter *util.JSONFilter `json:"filter"`
+}
+
+// The output of the search command
+type searchResponse struct {
+	Users []struct {
+		DN         string                 `json:"dn"`
+		Attributes map[string]util.Values `json:"attributes"`
+	} `json:"users"`
+}
+
+// Backend runs external commands. The commands are started without shell and
+// with an environment consisting of PATH and the configured variables only.
+type Backend struct {
+	config    *Config
+	env       []string
+	processes chan struct{}
+}
+
+var _ pkg.Backend = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if len(config.AuthCommand) == 0 {
+		return nil, errNoAuthCommand
+	}
+
+	maxProcesses := config.MaxProcesses
+	if maxProcesses <= 0 {
+		maxProcesses = 4
+	}
+
+	env := []string{}
+	if _, ok := config.Env["PATH"]; !ok {
+		env = append(env, "PATH="+defaultPath)
+	}
+	for name, value := range config.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+
+	return &Backend{
+		config:    config,
+		env:       env,
+		processes: make(chan struct{}, maxProcesses),
+	}, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+// Authenticate writes the username and the password, each terminated by a
+// null byte, to the stdin of the auth command. The exit code 0 accepts the
+// credentials, every other exit code rejects them.
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	stdin := strings.NewReader(username + "\x00" + password + "\x00")
+
+	err := backend.run(ctx, backend.config.AuthCommand, stdin, nil)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		log.Debugf("exec: authentication of %s failed: %v", username, exitErr)
+		return false
+	}
+	if err != nil {
+		log.Printf("exec: %v", err)
+		return false
+	}
+
+	return true
+}
+
+// GetUsers writes the base dn and the filter as JSON to the stdin of the
+// search command and reads the users as JSON from its stdout. Without search
+// command no users are returned.
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+
+	if len(backend.config.SearchCommand) == 0 {
+		return users, nil
+	}
+
+	input, err := json.Marshal(&searchRequest{
+		BaseDn: pkg.SearchBase(ctx),
+		Filter: util.NewJSONFilter(f),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := &limitedBuffer{limit: maxOutput}
+	err = backend.run(ctx, backend.config.SearchCommand, bytes.NewReader(input), stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	body := searchResponse{}
+	err = json.Unmarshal(stdout.Bytes(), &body)
+	if err != nil {
+		return nil, fmt.Errorf("exec: invalid output of the search command: %v", err)
+	}
+
+	for _, u := range body.Users {
+		user := &pkg.User{
+			DN:         u.DN,
+			Attributes: map[string][]string{},
+		}
+		for attribute, values := range u.Attributes {
+			user.Attributes[attribute] = values
+		}
+
+		if user.DN == "" && backend.config.DNAttribute != "" && len(user.Attributes[backend.config.DNAttribute]) > 0 {
+			user.DN = user.Attributes[backend.config.DNAttribute][0]
+		}
+		if user.DN == "" {
+			log.Printf("exec: skipping user without dn")
+			continue
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// run starts the command as soon as the process limit allows it and waits
+// until it exits. The process is killed if the context is done or the
+// timeout exceeded.
+func (backend *Backend) run(ctx context.Context, command []string, stdin io.Reader, stdout io.Writer) error {
+	select {
+	case backend.processes <- struct{}{}:
+		defer func() { <-backend.processes }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, backend.config.Timeout.Or(10*time.Second))
+	defer cancel()
+
+	stderr := &limitedBuffer{limit: 4096}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = backend.config.Dir
+	cmd.Env = backend.env
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		log.Debugf("exec: %s: %s", command[0], strings.TrimSpace(stderr.String()))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("exec: %s: %v", command[0], ctx.Err())
+	}
+	if stdout, ok := stdout.(*limitedBuffer); ok && stdout.exceeded {
+		return errOutputTooLarge
+	}
+
+	return err
+}
+
+// limitedBuffer discards everything written after the limit is reached
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+func (buffer *limitedBuffer) Write(p []byte) (int, error) {
+	if buffer.Len()+len(p) > buffer.limit {
+		buffer.exceeded = true
+		buffer.Buffer.Write(p[:buffer.limit-buffer.Len()])
+		return len(p), nil
+	}
+
+	return buffer.Buffer.Write(p)
+}
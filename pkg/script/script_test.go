@@ -0,0 +1,169 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package script
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const authScript = `#!/bin/sh
+IFS= read -r -d '' user
+IFS= read -r -d '' password
+[ "$user" = "alice" ] && [ "$password" = "test123" ]
+`
+
+const searchScript = `#!/bin/sh
+input=$(cat)
+case "$input" in
+*'"value":"alice"'*) echo '{"users": [{"dn": "alice", "attributes": {"uid": "alice", "mail": ["alice@example.com"], "home": "'$HOME'"}}]}' ;;
+*) echo '{"users": []}' ;;
+esac
+`
+
+func TestBackend(t *testing.T) {
+	dir, cleanup := util.TmpDir(t)
+	defer cleanup()
+
+	script := func(name string, content string) string {
+		file := filepath.Join(dir, name)
+		So(ioutil.WriteFile(file, []byte(content), 0700), ShouldBeNil)
+		return file
+	}
+
+	Convey("Given an exec backend", t, func() {
+		config := &Config{
+			AuthCommand:   []string{"/bin/bash", script("auth.sh", authScript)},
+			SearchCommand: []string{script("search.sh", searchScript)},
+		}
+		backend, err := NewBackend(config)
+		So(err, ShouldBeNil)
+
+		Convey("Then the exit code of the auth command is used", func() {
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "alice", "wrong"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "bob", "test123"), ShouldBeFalse)
+		})
+
+		Convey("Then the filter is passed to the search command", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")})
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "alice")
+			So(users[0].Attributes["mail"], ShouldResemble, []string{"alice@example.com"})
+
+			users, err = backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("bob")})
+
+			So(err, ShouldBeNil)
+			So(users, ShouldBeEmpty)
+		})
+
+		Convey("Then the environment isn't inherited", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")})
+
+			So(err, ShouldBeNil)
+			So(users[0].Attributes["home"], ShouldResemble, []string{""})
+		})
+
+		Convey("When variables are configured", func() {
+			config.Env = map[string]string{"HOME": "/srv"}
+			backend, err := NewBackend(config)
+			So(err, ShouldBeNil)
+
+			Convey("Then they are passed to the command", func() {
+				users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")})
+
+				So(err, ShouldBeNil)
+				So(users[0].Attributes["home"], ShouldResemble, []string{"/srv"})
+			})
+		})
+
+		Convey("When the search command fails", func() {
+			config.SearchCommand = []string{script("fail.sh", "#!/bin/sh\necho broken\nexit 3\n")}
+
+			Convey("Then an error should be returned", func() {
+				_, err := backend.GetUsers(context.Background(), nil)
+
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+
+	Convey("Given a slow auth command", t, func() {
+		config := &Config{
+			AuthCommand:  []string{script("slow.sh", "#!/bin/sh\nexec sleep 10\n")},
+			Timeout:      util.Duration{Duration: 50 * time.Millisecond},
+			MaxProcesses: 1,
+		}
+		backend, err := NewBackend(config)
+		So(err, ShouldBeNil)
+
+		Convey("Then the command is killed after the timeout", func() {
+			start := time.Now()
+
+			So(backend.Authenticate(context.Background(), "alice", "test123"), ShouldBeFalse)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+
+		Convey("Then the command is killed if the context is done", func() {
+			config.Timeout = util.Duration{Duration: time.Minute}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			start := time.Now()
+
+			So(backend.Authenticate(ctx, "alice", "test123"), ShouldBeFalse)
+			So(time.Since(start), ShouldBeLessThan, 5*time.Second)
+		})
+
+		Convey("Then the number of processes is limited", func() {
+			var wg sync.WaitGroup
+			start := time.Now()
+
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					backend.Authenticate(context.Background(), "alice", "test123")
+				}()
+			}
+			wg.Wait()
+
+			So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 150*time.Millisecond)
+		})
+	})
+
+	Convey("Given no auth command", t, func() {
+		_, err := NewBackend(&Config{})
+
+		Convey("Then an error should be returned", func() {
+			So(err, ShouldEqual, errNoAuthCommand)
+		})
+	})
+}
@@ -0,0 +1,90 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package totp
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateCode(t *testing.T) {
+	Convey("Given the key of RFC 6238", t, func() {
+		key := []byte("12345678901234567890")
+
+		Convey("Then the codes match the test vectors", func() {
+			So(generateCode(key, counter(time.Unix(59, 0))), ShouldEqual, "287082")
+			So(generateCode(key, counter(time.Unix(1111111109, 0))), ShouldEqual, "081804")
+			So(generateCode(key, counter(time.Unix(1234567890, 0))), ShouldEqual, "005924")
+		})
+	})
+}
+
+func TestVerifyCode(t *testing.T) {
+	Convey("Given a key and a code", t, func() {
+		key := []byte("12345678901234567890")
+		now := time.Unix(1111111109, 0)
+		code := generateCode(key, counter(now))
+
+		Convey("Then the code is valid within the skew", func() {
+			So(verifyCode(key, code, now, 1, 0), ShouldEqual, counter(now))
+			So(verifyCode(key, code, now.Add(period*time.Second), 1, 0), ShouldEqual, counter(now))
+			So(verifyCode(key, code, now.Add(2*period*time.Second), 1, 0), ShouldEqual, -1)
+		})
+
+		Convey("Then a used code is rejected", func() {
+			So(verifyCode(key, code, now, 1, counter(now)), ShouldEqual, -1)
+		})
+
+		Convey("Then a wrong code is rejected", func() {
+			So(verifyCode(key, "000000", now, 1, 0), ShouldEqual, -1)
+		})
+	})
+}
+
+func TestSecret(t *testing.T) {
+	Convey("Given a new secret", t, func() {
+		secret, err := newSecret()
+		So(err, ShouldBeNil)
+
+		Convey("Then it decodes to 160 bits", func() {
+			key, err := decodeSecret(strings.ToLower(secret))
+
+			So(err, ShouldBeNil)
+			So(key, ShouldHaveLength, 20)
+		})
+	})
+}
+
+func TestProvisioningUri(t *testing.T) {
+	Convey("Given an issuer and a user", t, func() {
+		uri := ProvisioningUri("Example VPN", "alice", "JBSWY3DPEHPK3PXP")
+
+		Convey("Then the uri contains the label and the parameters", func() {
+			So(uri, ShouldStartWith, "otpauth://totp/Example%20VPN:alice?")
+			So(uri, ShouldContainSubstring, "secret=JBSWY3DPEHPK3PXP")
+			So(uri, ShouldContainSubstring, "issuer=Example+VPN")
+			So(uri, ShouldContainSubstring, "digits=6")
+			So(uri, ShouldContainSubstring, "period=30")
+		})
+	})
+}
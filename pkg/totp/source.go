@@ -0,0 +1,287 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package totp
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	_ "github.com/lib/pq"
+	"github.com/samuel/go-ldap/ldap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrReadOnly = errors.New("totp: the secret source is read only")
+
+	errUnknownSource     = errors.New("totp: unknown secret source")
+	errInvalidIdentifier = errors.New("totp: invalid table or column name")
+
+	identifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+type SourceConfig struct {
+	Kind string `json:"kind"`
+
+	// attribute
+	Attribute     string `json:"attribute"`
+	UserAttribute string `json:"userAttribute"`
+
+	// file
+	File string `json:"file"`
+
+	// postgres
+	Url        string `json:"url"`
+	Table      string `json:"table"`
+	NameColumn string `json:"nameColumn"`
+	Column     string `json:"column"`
+}
+
+// A secretSource stores the base32 encoded secrets of the users. The secret is
+// empty for users without second factor. Users are passed by their normalized
+// names, see dn.Normalize.
+type secretSource interface {
+	Secret(ctx context.Context, username string) (string, error)
+	SetSecret(ctx context.Context, username string, secret string) error
+}
+
+// newSource creates the configured source. The delegate is only used for
+// reading secrets from attributes.
+func newSource(config *SourceConfig, delegate pkg.Backend) (secretSource, error) {
+	switch config.Kind {
+	case "attribute":
+		return &attributeSource{
+			delegate:      delegate,
+			attribute:     orDefault(config.Attribute, "totpSecret"),
+			userAttribute: orDefault(config.UserAttribute, "uid"),
+		}, nil
+	case "file":
+		return &fileSource{
+			file:    config.File,
+			watcher: util.NewFileWatcher(config.File),
+		}, nil
+	case "postgres":
+		db, err := sql.Open("postgres", config.Url)
+		if err != nil {
+			return nil, err
+		}
+		return newPostgresSource(config, db)
+	default:
+		return nil, errUnknownSource
+	}
+}
+
+func orDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+// attributeSource reads the secret from an attribute of the user
+type attributeSource struct {
+	delegate      pkg.Backend
+	attribute     string
+	userAttribute string
+}
+
+func (source *attributeSource) Secret(ctx context.Context, username string) (string, error) {
+	users, err := source.delegate.GetUsers(ctx, &ldap.EqualityMatch{
+		Attribute: source.userAttribute,
+		Value:     []byte(username),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(users) != 1 || len(users[0].Attributes[source.attribute]) == 0 {
+		return "", nil
+	}
+
+	return users[0].Attributes[source.attribute][0], nil
+}
+
+func (source *attributeSource) SetSecret(ctx context.Context, username string, secret string) error {
+	return ErrReadOnly
+}
+
+// fileSource reads the secrets from a file with lines of the form
+// <username>:<secret>
+type fileSource struct {
+	file    string
+	watcher *util.FileWatcher
+
+	mutex   sync.Mutex
+	secrets map[string]string
+}
+
+func (source *fileSource) Secret(ctx context.Context, username string) (string, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	changed, err := source.watcher.Changed()
+	if err != nil {
+		return "", err
+	}
+	if changed || source.secrets == nil {
+		secrets, err := source.read()
+		if err != nil {
+			return "", err
+		}
+		source.secrets = secrets
+	}
+
+	return source.secrets[username], nil
+}
+
+func (source *fileSource) SetSecret(ctx context.Context, username string, secret string) error {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	secrets, err := source.read()
+	if os.IsNotExist(err) {
+		secrets = map[string]string{}
+	} else if err != nil {
+		return err
+	}
+	secrets[username] = secret
+
+	lines := []string{}
+	for name, secret := range secrets {
+		lines = append(lines, name+":"+secret)
+	}
+
+	// replace the file atomically
+	tmp, err := ioutil.TempFile(filepath.Dir(source.file), ".totp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	source.secrets = nil
+	return os.Rename(tmp.Name(), source.file)
+}
+
+func (source *fileSource) read() (map[string]string, error) {
+	file, err := os.Open(source.file)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	secrets := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("totp: invalid entry in line %d", lineNumber)
+		}
+
+		secrets[dn.Normalize(line[:i])] = line[i+1:]
+	}
+
+	return secrets, scanner.Err()
+}
+
+// postgresSource reads the secrets from a column of the users table. It uses
+// the postgres driver and its $n placeholders, other databases aren't
+// supported.
+type postgresSource struct {
+	db          *sql.DB
+	selectQuery string
+	updateQuery string
+}
+
+func newPostgresSource(config *SourceConfig, db *sql.DB) (*postgresSource, error) {
+	table := orDefault(config.Table, "users")
+	nameColumn := orDefault(config.NameColumn, "name")
+	column := orDefault(config.Column, "totp_secret")
+
+	for _, identifier := range []string{table, nameColumn, column} {
+		if !identifierRegex.MatchString(identifier) {
+			return nil, errInvalidIdentifier
+		}
+	}
+
+	return &postgresSource{
+		db:          db,
+		selectQuery: fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1", column, table, nameColumn),
+		updateQuery: fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", table, column, nameColumn),
+	}, nil
+}
+
+func (source *postgresSource) Secret(ctx context.Context, username string) (string, error) {
+	var secret sql.NullString
+
+	err := source.db.QueryRowContext(ctx, source.selectQuery, username).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return secret.String, nil
+}
+
+func (source *postgresSource) SetSecret(ctx context.Context, username string, secret string) error {
+	res, err := source.db.ExecContext(ctx, source.updateQuery, secret, username)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("totp: user %s not found", username)
+	}
+
+	return nil
+}
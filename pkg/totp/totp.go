@@ -0,0 +1,293 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package totp
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"github.com/samuel/go-ldap/ldap"
+	"sync"
+	"time"
+)
+
+type wrapperFactory struct{}
+
+func NewWrapperFactory() pkg.WrapperFactory {
+	return &wrapperFactory{}
+}
+
+func (wrapperFactory) Name() string {
+	return "totp"
+}
+
+func (wrapperFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (wrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	totpConfig, ok := config.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	return NewBackend(backend, totpConfig)
+}
+
+type Config struct {
+	Issuer   string       `json:"issuer"`
+	Skew     *int         `json:"skew"`
+	Optional bool         `json:"optional"`
+	Source   SourceConfig `json:"source"`
+}
+
+// the number of time steps accepted before and after the current one
+func (config *Config) skew() int {
+	if config.Skew == nil {
+		return 1
+	}
+
+	return *config.Skew
+}
+
+// Backend requires a totp code appended to the password of the delegate
+type Backend struct {
+	delegate pkg.Backend
+	config   *Config
+	source   secretSource
+	now      func() time.Time
+
+	mutex    sync.Mutex
+	lastUsed map[string]int64
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Wrapper = &Backend{}
+
+func NewBackend(delegate pkg.Backend, config *Config) (*Backend, error) {
+	source, err := newSource(&config.Source, delegate)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBackend(delegate, config, source), nil
+}
+
+func newBackend(delegate pkg.Backend, config *Config, source secretSource) *Backend {
+	return &Backend{
+		delegate: delegate,
+		config:   config,
+		source:   source,
+		now:      time.Now,
+		lastUsed: map[string]int64{},
+	}
+}
+
+func (backend *Backend) Name() string {
+	return backend.delegate.Name()
+}
+
+func (backend *Backend) Unwrap() pkg.Backend {
+	return backend.delegate
+}
+
+// Authenticate checks the code with the secret of the user before the password
+// is verified by the delegate. The delegate compares normalized dns, so the
+// secret and the used codes are looked up by the normalized name too, otherwise
+// another spelling of the dn would skip the second factor.
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	name := dn.Normalize(username)
+
+	secret, err := backend.source.Secret(ctx, name)
+	if err != nil {
+		log.Printf("[totp] failed to read the secret of %s: %v", username, err)
+		return false
+	}
+
+	if secret == "" {
+		if backend.config.Optional {
+			return backend.delegate.Authenticate(ctx, username, password)
+		}
+
+		log.Debugf("[totp] no secret for %s", username)
+		return false
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		log.Printf("[totp] invalid secret of %s: %v", username, err)
+		return false
+	}
+
+	password, code, ok := splitCode(password)
+	if !ok {
+		log.Debugf("[totp] no code in the password of %s", username)
+		return false
+	}
+
+	backend.mutex.Lock()
+	lastUsed := backend.lastUsed[name]
+	backend.mutex.Unlock()
+
+	used := verifyCode(key, code, backend.now(), backend.config.skew(), lastUsed)
+	if used < 0 {
+		log.Debugf("[totp] invalid or reused code for %s", username)
+		return false
+	}
+
+	if !backend.delegate.Authenticate(ctx, username, password) {
+		return false
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	// a concurrent bind may have used the same code in the meantime
+	if used <= backend.lastUsed[name] {
+		log.Debugf("[totp] reused code for %s", username)
+		return false
+	}
+	backend.lastUsed[name] = used
+
+	return true
+}
+
+// GetUsers hides the secrets of an attribute source. Filters on the secret
+// attribute match nothing, otherwise substring filters would reveal the secret.
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	source, ok := backend.source.(*attributeSource)
+	if !ok {
+		return backend.delegate.GetUsers(ctx, f)
+	}
+
+	secretKey := schema.Default.Key(source.attribute)
+	if referencesAttribute(f, secretKey) {
+		return []*pkg.User{}, nil
+	}
+
+	users, err := backend.delegate.GetUsers(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	// copy the users as the delegate may return its own instances
+	result := make([]*pkg.User, len(users))
+	for i, user := range users {
+		attributes := make(map[string][]string, len(user.Attributes))
+		for name, values := range user.Attributes {
+			if schema.Default.Key(name) != secretKey {
+				attributes[name] = values
+			}
+		}
+
+		result[i] = &pkg.User{DN: user.DN, Attributes: attributes}
+	}
+
+	return result, nil
+}
+
+// referencesAttribute reports whether the filter has a condition on the
+// attribute with the schema key
+func referencesAttribute(f ldap.Filter, key string) bool {
+	var attribute string
+
+	switch f := f.(type) {
+	case *ldap.AND:
+		return anyReferencesAttribute(f.Filters, key)
+	case *ldap.OR:
+		return anyReferencesAttribute(f.Filters, key)
+	case *ldap.NOT:
+		return referencesAttribute(f.Filter, key)
+	case *ldap.EqualityMatch:
+		attribute = f.Attribute
+	case *ldap.ApproxMatch:
+		attribute = f.Attribute
+	case *ldap.GreaterOrEqual:
+		attribute = f.Attribute
+	case *ldap.LessOrEqual:
+		attribute = f.Attribute
+	case *ldap.Substrings:
+		attribute = f.Attribute
+	case *ldap.Present:
+		attribute = f.Attribute
+	default:
+		return false
+	}
+
+	return schema.Default.Key(attribute) == key
+}
+
+func anyReferencesAttribute(filters []ldap.Filter, key string) bool {
+	for _, f := range filters {
+		if referencesAttribute(f, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitCode splits the trailing code off the password
+func splitCode(password string) (string, string, bool) {
+	if len(password) < digits {
+		return "", "", false
+	}
+
+	code := password[len(password)-digits:]
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return "", "", false
+		}
+	}
+
+	return password[:len(password)-digits], code, true
+}
+
+// Enroll creates a new secret for the user and stores it in the configured
+// source. It returns the provisioning uri and the secret. Attribute sources are
+// read only, the uri and the secret are returned together with ErrReadOnly and
+// the secret must be stored in the directory by other means.
+func Enroll(ctx context.Context, config *Config, username string) (uri string, secret string, err error) {
+	source, err := newSource(&config.Source, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	return enroll(ctx, config, source, username)
+}
+
+func enroll(ctx context.Context, config *Config, source secretSource, username string) (string, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	uri := ProvisioningUri(config.Issuer, username, secret)
+
+	err = source.SetSecret(ctx, dn.Normalize(username), secret)
+	if err != nil && err != ErrReadOnly {
+		return "", "", err
+	}
+
+	return uri, secret, err
+}
@@ -0,0 +1,275 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package totp
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testBackend struct {
+	users map[string]*pkg.User
+}
+
+func (testBackend) Name() string {
+	return "test"
+}
+
+func (backend *testBackend) Authenticate(ctx context.Context, username string, password string) bool {
+	return password == "secret" && backend.users[dn.Normalize(username)] != nil
+}
+
+func (backend *testBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+	for _, user := range backend.users {
+		if util.MatchFilter(f, user.Attributes) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+type testSource map[string]string
+
+func (source testSource) Secret(ctx context.Context, username string) (string, error) {
+	return source[username], nil
+}
+
+func (source testSource) SetSecret(ctx context.Context, username string, secret string) error {
+	source[username] = secret
+	return nil
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given a backend with a totp secret for alice", t, func() {
+		delegate := &testBackend{users: map[string]*pkg.User{
+			"alice": {DN: "alice", Attributes: map[string][]string{"uid": {"alice"}}},
+			"bob":   {DN: "bob", Attributes: map[string][]string{"uid": {"bob"}}},
+		}}
+		source := testSource{"alice": "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}
+		now := time.Unix(1111111109, 0)
+
+		backend := newBackend(delegate, &Config{}, source)
+		backend.now = func() time.Time { return now }
+
+		Convey("Then the password with the current code is accepted once", func() {
+			So(backend.Authenticate(context.Background(), "alice", "secret081804"), ShouldBeTrue)
+			So(backend.Authenticate(context.Background(), "alice", "secret081804"), ShouldBeFalse)
+		})
+
+		Convey("Then a wrong password or code is rejected", func() {
+			So(backend.Authenticate(context.Background(), "alice", "wrong081804"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "alice", "secret123456"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "alice", "secret"), ShouldBeFalse)
+		})
+
+		Convey("Then a failed bind doesn't use the code", func() {
+			So(backend.Authenticate(context.Background(), "alice", "wrong081804"), ShouldBeFalse)
+			So(backend.Authenticate(context.Background(), "alice", "secret081804"), ShouldBeTrue)
+		})
+
+		Convey("Then users without secret are rejected", func() {
+			So(backend.Authenticate(context.Background(), "bob", "secret"), ShouldBeFalse)
+		})
+
+		Convey("When the second factor is optional", func() {
+			backend.config.Optional = true
+
+			Convey("Then users without secret authenticate with the password", func() {
+				So(backend.Authenticate(context.Background(), "bob", "secret"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "alice", "secret"), ShouldBeFalse)
+			})
+		})
+
+		Convey("Then the users are passed through", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("bob")})
+
+			So(err, ShouldBeNil)
+			So(users, ShouldHaveLength, 1)
+			So(backend.Name(), ShouldEqual, "test")
+		})
+
+		Convey("When alice binds with other spellings of her dn", func() {
+			delegate.users["uid=alice,dc=example"] = delegate.users["alice"]
+			source["uid=alice,dc=example"] = source["alice"]
+			backend.config.Optional = true
+
+			Convey("Then the second factor is required", func() {
+				So(backend.Authenticate(context.Background(), "UID=alice,dc=example", "secret"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "uid=alice, DC=example", "secret"), ShouldBeFalse)
+			})
+
+			Convey("Then the code is accepted once", func() {
+				So(backend.Authenticate(context.Background(), "UID=alice,dc=example", "secret081804"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "uid=alice, dc=example", "secret081804"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "uid=alice,dc=example", "secret081804"), ShouldBeFalse)
+			})
+		})
+
+		Convey("When a user is enrolled", func() {
+			uri, secret, err := enroll(context.Background(), &Config{Issuer: "VPN"}, source, "bob")
+
+			Convey("Then the secret is stored", func() {
+				So(err, ShouldBeNil)
+				So(source["bob"], ShouldEqual, secret)
+				So(uri, ShouldStartWith, "otpauth://totp/VPN:bob?")
+			})
+		})
+	})
+}
+
+func TestAttributeSource(t *testing.T) {
+	Convey("Given an attribute source", t, func() {
+		delegate := &testBackend{users: map[string]*pkg.User{
+			"alice": {DN: "alice", Attributes: map[string][]string{"uid": {"alice"}, "totpSecret": {"JBSWY3DPEHPK3PXP"}}},
+		}}
+		source, err := newSource(&SourceConfig{Kind: "attribute"}, delegate)
+		So(err, ShouldBeNil)
+
+		Convey("Then the secret is read from the attribute", func() {
+			secret, err := source.Secret(context.Background(), "alice")
+
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, "JBSWY3DPEHPK3PXP")
+		})
+
+		Convey("Then unknown users have no secret", func() {
+			secret, err := source.Secret(context.Background(), "bob")
+
+			So(err, ShouldBeNil)
+			So(secret, ShouldBeEmpty)
+		})
+
+		Convey("Then the source is read only", func() {
+			So(source.SetSecret(context.Background(), "alice", "X"), ShouldEqual, ErrReadOnly)
+		})
+
+		Convey("When the users are searched through the backend", func() {
+			backend := newBackend(delegate, &Config{}, source)
+
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")})
+
+			Convey("Then the secret attribute is absent", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 1)
+				So(users[0].Attributes, ShouldNotContainKey, "totpSecret")
+				So(users[0].Attributes["uid"], ShouldResemble, []string{"alice"})
+			})
+
+			Convey("Then the delegate's users are unchanged", func() {
+				So(delegate.users["alice"].Attributes, ShouldContainKey, "totpSecret")
+			})
+		})
+
+		Convey("When the users are filtered by the secret attribute", func() {
+			backend := newBackend(delegate, &Config{}, source)
+
+			users, err := backend.GetUsers(context.Background(), &ldap.Substrings{Attribute: "TOTPSECRET", Initial: "J"})
+
+			Convey("Then no users are returned", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 0)
+			})
+		})
+	})
+}
+
+func TestFileSource(t *testing.T) {
+	dir, cleanup := util.TmpDir(t)
+	defer cleanup()
+
+	Convey("Given a secrets file", t, func() {
+		file := filepath.Join(dir, "totp")
+		So(ioutil.WriteFile(file, []byte("# secrets\nalice:JBSWY3DPEHPK3PXP\n"), 0600), ShouldBeNil)
+
+		source, err := newSource(&SourceConfig{Kind: "file", File: file}, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Then the secret is read from the file", func() {
+			secret, err := source.Secret(context.Background(), "alice")
+
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, "JBSWY3DPEHPK3PXP")
+		})
+
+		Convey("When a secret is set", func() {
+			err := source.SetSecret(context.Background(), "bob", "GEZDGNBV")
+			So(err, ShouldBeNil)
+
+			Convey("Then all secrets are available", func() {
+				alice, _ := source.Secret(context.Background(), "alice")
+				bob, _ := source.Secret(context.Background(), "bob")
+
+				So(alice, ShouldEqual, "JBSWY3DPEHPK3PXP")
+				So(bob, ShouldEqual, "GEZDGNBV")
+			})
+		})
+	})
+}
+
+func TestPostgresSource(t *testing.T) {
+	Convey("Given a postgres source", t, func() {
+		db, mock, err := sqlmock.New()
+		So(err, ShouldBeNil)
+		defer db.Close()
+
+		source, err := newPostgresSource(&SourceConfig{Table: "accounts"}, db)
+		So(err, ShouldBeNil)
+
+		Convey("Then the secret is selected", func() {
+			mock.ExpectQuery("SELECT totp_secret FROM accounts WHERE name = \\$1").
+				WithArgs("alice").
+				WillReturnRows(sqlmock.NewRows([]string{"totp_secret"}).AddRow("JBSWY3DPEHPK3PXP"))
+
+			secret, err := source.Secret(context.Background(), "alice")
+
+			So(err, ShouldBeNil)
+			So(secret, ShouldEqual, "JBSWY3DPEHPK3PXP")
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+
+		Convey("Then the secret is updated", func() {
+			mock.ExpectExec("UPDATE accounts SET totp_secret = \\$1 WHERE name = \\$2").
+				WithArgs("JBSWY3DPEHPK3PXP", "alice").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			So(source.SetSecret(context.Background(), "alice", "JBSWY3DPEHPK3PXP"), ShouldBeNil)
+			So(mock.ExpectationsWereMet(), ShouldBeNil)
+		})
+	})
+
+	Convey("Given an invalid column name", t, func() {
+		_, err := newPostgresSource(&SourceConfig{Column: "secret; DROP TABLE users"}, nil)
+
+		Convey("Then an error should be returned", func() {
+			So(err, ShouldEqual, errInvalidIdentifier)
+		})
+	})
+}
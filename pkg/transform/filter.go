@@ -0,0 +1,161 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package transform
+
+import (
+	"github.com/samuel/go-ldap/ldap"
+	"strings"
+)
+
+// the results of a filter rewrite
+const (
+	// the rewritten filter matches exactly the same users
+	matchExact = iota
+	// the rewritten filter matches more users, the result must be filtered
+	matchRelaxed
+	// the filter matches no user
+	matchNone
+)
+
+// a partial rewrite result which may be constant
+type rewritten struct {
+	filter   ldap.Filter
+	constant *bool
+	relaxed  bool
+}
+
+func constant(value bool, relaxed bool) rewritten {
+	return rewritten{constant: &value, relaxed: relaxed}
+}
+
+// rewriteFilter converts the filter to the attributes of the delegate.
+// Conditions on computed attributes can't be converted, they are replaced by
+// a constant which includes all possible matches.
+func (backend *Backend) rewriteFilter(f ldap.Filter) (ldap.Filter, int) {
+	if f == nil {
+		return nil, matchExact
+	}
+
+	r := backend.rewrite(f, true)
+
+	result := matchExact
+	if r.relaxed {
+		result = matchRelaxed
+	}
+
+	if r.constant != nil {
+		if !*r.constant {
+			return nil, matchNone
+		}
+		return &ldap.Present{Attribute: "objectClass"}, result
+	}
+
+	return r.filter, result
+}
+
+// rewrite converts the filter. Positive is false inside of an odd number of
+// nots, the relaxed constants are inverted there.
+func (backend *Backend) rewrite(f ldap.Filter, positive bool) rewritten {
+	switch f := f.(type) {
+	case *ldap.AND:
+		return backend.rewriteList(f.Filters, positive, true)
+	case *ldap.OR:
+		return backend.rewriteList(f.Filters, positive, false)
+	case *ldap.NOT:
+		r := backend.rewrite(f.Filter, !positive)
+		if r.constant != nil {
+			return constant(!*r.constant, r.relaxed)
+		}
+		return rewritten{filter: &ldap.NOT{Filter: r.filter}, relaxed: r.relaxed}
+	case *ldap.EqualityMatch:
+		return backend.rewriteAttribute(f.Attribute, positive, func(attribute string) ldap.Filter {
+			return &ldap.EqualityMatch{Attribute: attribute, Value: f.Value}
+		})
+	case *ldap.ApproxMatch:
+		return backend.rewriteAttribute(f.Attribute, positive, func(attribute string) ldap.Filter {
+			return &ldap.ApproxMatch{Attribute: attribute, Value: f.Value}
+		})
+	case *ldap.GreaterOrEqual:
+		return backend.rewriteAttribute(f.Attribute, positive, func(attribute string) ldap.Filter {
+			return &ldap.GreaterOrEqual{Attribute: attribute, Value: f.Value}
+		})
+	case *ldap.LessOrEqual:
+		return backend.rewriteAttribute(f.Attribute, positive, func(attribute string) ldap.Filter {
+			return &ldap.LessOrEqual{Attribute: attribute, Value: f.Value}
+		})
+	case *ldap.Substrings:
+		return backend.rewriteAttribute(f.Attribute, positive, func(attribute string) ldap.Filter {
+			return &ldap.Substrings{Attribute: attribute, Initial: f.Initial, Any: f.Any, Final: f.Final}
+		})
+	case *ldap.Present:
+		return backend.rewriteAttribute(f.Attribute, positive, func(attribute string) ldap.Filter {
+			return &ldap.Present{Attribute: attribute}
+		})
+	default:
+		return rewritten{filter: f}
+	}
+}
+
+// rewriteList rewrites the filters of an and (all is true) or an or and
+// removes the constants.
+func (backend *Backend) rewriteList(filters []ldap.Filter, positive bool, all bool) rewritten {
+	result := []ldap.Filter{}
+	relaxed := false
+
+	for _, f := range filters {
+		r := backend.rewrite(f, positive)
+		relaxed = relaxed || r.relaxed
+
+		if r.constant == nil {
+			result = append(result, r.filter)
+		} else if *r.constant != all {
+			// false in an and, true in an or
+			return constant(!all, relaxed)
+		}
+	}
+
+	if len(result) == 0 {
+		return constant(all, relaxed)
+	}
+
+	if all {
+		return rewritten{filter: &ldap.AND{Filters: result}, relaxed: relaxed}
+	}
+	return rewritten{filter: &ldap.OR{Filters: result}, relaxed: relaxed}
+}
+
+func (backend *Backend) rewriteAttribute(attribute string, positive bool, newFilter func(attribute string) ldap.Filter) rewritten {
+	name := strings.ToLower(attribute)
+
+	switch {
+	case backend.drop[name] || backend.hidden[name]:
+		// the attribute isn't visible to the client
+		return constant(false, false)
+	case backend.computed[name]:
+		return constant(positive, true)
+	}
+
+	if from, ok := backend.reverse[name]; ok {
+		return rewritten{filter: newFilter(from)}
+	}
+
+	return rewritten{filter: newFilter(attribute)}
+}
@@ -0,0 +1,279 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package transform
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"hash"
+	"regexp"
+	"strings"
+)
+
+var (
+	errUnknownHash = errors.New("transform: unknown hash algorithm")
+
+	placeholderRegex = regexp.MustCompile(`\{([^{}]+)\}`)
+
+	hashes = map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+	}
+)
+
+type wrapperFactory struct{}
+
+func NewWrapperFactory() pkg.WrapperFactory {
+	return &wrapperFactory{}
+}
+
+func (wrapperFactory) Name() string {
+	return "transform"
+}
+
+func (wrapperFactory) NewConfig() interface{} {
+	return &Config{}
+}
+
+func (wrapperFactory) Wrap(backend pkg.Backend, config interface{}) (pkg.Backend, error) {
+	transformConfig, ok := config.(*Config)
+	if !ok {
+		return nil, pkg.ErrInvalidConfigType
+	}
+
+	return NewBackend(backend, transformConfig)
+}
+
+// Config of the transformation. Except for rename the attributes are named as
+// seen by the client i. e. after renaming.
+type Config struct {
+	Rename    map[string]string      `json:"rename"`
+	Templates map[string]string      `json:"templates"`
+	Constants map[string]util.Values `json:"constants"`
+	Lowercase []string               `json:"lowercase"`
+	Hash      map[string]string      `json:"hash"`
+	Drop      []string               `json:"drop"`
+}
+
+// Backend transforms the attributes of the users returned by the delegate in
+// the order rename, templates, constants, lowercase, hash and drop. The
+// filters of searches are rewritten to the attributes of the delegate.
+// Filters on computed attributes are checked after the transformation.
+type Backend struct {
+	delegate pkg.Backend
+	config   *Config
+
+	// the attribute names are lower case
+	rename    map[string]string
+	reverse   map[string]string
+	hidden    map[string]bool
+	computed  map[string]bool
+	templates map[string]string
+	lowercase map[string]bool
+	hash      map[string]func() hash.Hash
+	drop      map[string]bool
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.Wrapper = &Backend{}
+
+func NewBackend(delegate pkg.Backend, config *Config) (*Backend, error) {
+	backend := &Backend{
+		delegate:  delegate,
+		config:    config,
+		rename:    map[string]string{},
+		reverse:   map[string]string{},
+		hidden:    map[string]bool{},
+		computed:  map[string]bool{},
+		templates: map[string]string{},
+		lowercase: map[string]bool{},
+		hash:      map[string]func() hash.Hash{},
+		drop:      map[string]bool{},
+	}
+
+	for from, to := range config.Rename {
+		backend.rename[strings.ToLower(from)] = to
+		backend.reverse[strings.ToLower(to)] = from
+	}
+	for from := range backend.rename {
+		if _, ok := backend.reverse[from]; !ok {
+			// the original name isn't visible anymore
+			backend.hidden[from] = true
+		}
+	}
+
+	for name, template := range config.Templates {
+		backend.templates[name] = template
+		backend.computed[strings.ToLower(name)] = true
+	}
+	for name := range config.Constants {
+		backend.computed[strings.ToLower(name)] = true
+	}
+	for _, name := range config.Lowercase {
+		backend.lowercase[strings.ToLower(name)] = true
+		backend.computed[strings.ToLower(name)] = true
+	}
+	for name, algorithm := range config.Hash {
+		newHash, ok := hashes[strings.ToLower(algorithm)]
+		if !ok {
+			return nil, fmt.Errorf("%v: %s", errUnknownHash, algorithm)
+		}
+		backend.hash[strings.ToLower(name)] = newHash
+		backend.computed[strings.ToLower(name)] = true
+	}
+	for _, name := range config.Drop {
+		backend.drop[strings.ToLower(name)] = true
+	}
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() string {
+	return backend.delegate.Name()
+}
+
+func (backend *Backend) Unwrap() pkg.Backend {
+	return backend.delegate
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	return backend.delegate.Authenticate(ctx, username, password)
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	delegateFilter, result := backend.rewriteFilter(f)
+	if result == matchNone {
+		log.Debugf("transform: %v matches no user of %s", f, backend.Name())
+		return []*pkg.User{}, nil
+	}
+
+	users, err := backend.delegate.GetUsers(ctx, delegateFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []*pkg.User{}
+	for _, user := range users {
+		user = backend.transform(user)
+
+		if result == matchRelaxed && !util.MatchFilter(f, user.Attributes) {
+			continue
+		}
+		filtered = append(filtered, user)
+	}
+
+	return filtered, nil
+}
+
+// transform returns the transformed copy of the user
+func (backend *Backend) transform(user *pkg.User) *pkg.User {
+	attributes := map[string][]string{}
+	for name, values := range user.Attributes {
+		if to, ok := backend.rename[strings.ToLower(name)]; ok {
+			name = to
+		}
+		attributes[name] = append(attributes[name], values...)
+	}
+
+	for name, template := range backend.templates {
+		if value, ok := expand(template, attributes); ok {
+			attributes[name] = []string{value}
+		}
+	}
+
+	for name, values := range backend.config.Constants {
+		attributes[name] = appendMissing(attributes[name], values)
+	}
+
+	for name, values := range attributes {
+		lowerName := strings.ToLower(name)
+
+		if backend.drop[lowerName] {
+			delete(attributes, name)
+			continue
+		}
+
+		transformed := make([]string, len(values))
+		for i, value := range values {
+			if backend.lowercase[lowerName] {
+				value = strings.ToLower(value)
+			}
+			if newHash, ok := backend.hash[lowerName]; ok {
+				h := newHash()
+				h.Write([]byte(value))
+				value = hex.EncodeToString(h.Sum(nil))
+			}
+			transformed[i] = value
+		}
+		attributes[name] = transformed
+	}
+
+	return &pkg.User{
+		DN:         user.DN,
+		Attributes: attributes,
+	}
+}
+
+// expand replaces the placeholders with the first value of the attributes. It
+// fails if an attribute is missing.
+func expand(template string, attributes map[string][]string) (string, bool) {
+	ok := true
+	value := placeholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		for attribute, values := range attributes {
+			if strings.EqualFold(attribute, name) && len(values) > 0 {
+				return values[0]
+			}
+		}
+
+		ok = false
+		return ""
+	})
+
+	return value, ok
+}
+
+func appendMissing(values []string, additional []string) []string {
+	for _, value := range additional {
+		found := false
+		for _, existing := range values {
+			if strings.EqualFold(existing, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
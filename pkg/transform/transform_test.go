@@ -0,0 +1,171 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package transform
+
+import (
+	"context"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+type testBackend struct {
+	users  []*pkg.User
+	filter ldap.Filter
+	calls  int
+}
+
+func (testBackend) Name() string {
+	return "test"
+}
+
+func (backend *testBackend) Authenticate(ctx context.Context, username string, password string) bool {
+	return password == "test123"
+}
+
+func (backend *testBackend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	backend.filter = f
+	backend.calls++
+
+	users := []*pkg.User{}
+	for _, user := range backend.users {
+		if f == nil || util.MatchFilter(f, user.Attributes) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func TestBackend(t *testing.T) {
+	Convey("Given a transformed backend", t, func() {
+		delegate := &testBackend{users: []*pkg.User{
+			{DN: "alice", Attributes: map[string][]string{
+				"uid": {"alice"}, "firstname": {"Alice"}, "lastname": {"Smith"}, "email": {"Alice@Example.com"}, "password": {"x"},
+			}},
+			{DN: "bob", Attributes: map[string][]string{
+				"uid": {"bob"}, "firstname": {"Bob"}, "email": {"bob@example.com"},
+			}},
+		}}
+
+		backend, err := NewBackend(delegate, &Config{
+			Rename:    map[string]string{"email": "mail", "firstname": "givenName", "lastname": "sn"},
+			Templates: map[string]string{"displayName": "{givenName} {sn}"},
+			Constants: map[string]util.Values{"objectClass": {"top", "inetOrgPerson"}},
+			Lowercase: []string{"mail"},
+			Hash:      map[string]string{"employeeNumber": "sha256"},
+			Drop:      []string{"password"},
+		})
+		So(err, ShouldBeNil)
+
+		search := func(filter string) []*pkg.User {
+			f, err := ldap.ParseFilter(filter)
+			So(err, ShouldBeNil)
+
+			users, err := backend.GetUsers(context.Background(), f)
+			So(err, ShouldBeNil)
+			return users
+		}
+
+		Convey("Then the attributes are transformed", func() {
+			users := search("(uid=alice)")
+
+			So(users, ShouldHaveLength, 1)
+			So(users[0].Attributes["mail"], ShouldResemble, []string{"alice@example.com"})
+			So(users[0].Attributes["givenName"], ShouldResemble, []string{"Alice"})
+			So(users[0].Attributes["displayName"], ShouldResemble, []string{"Alice Smith"})
+			So(users[0].Attributes["objectClass"], ShouldResemble, []string{"top", "inetOrgPerson"})
+			So(users[0].Attributes, ShouldNotContainKey, "password")
+			So(users[0].Attributes, ShouldNotContainKey, "email")
+		})
+
+		Convey("Then the delegate users aren't modified", func() {
+			search("(uid=alice)")
+
+			So(delegate.users[0].Attributes, ShouldContainKey, "email")
+		})
+
+		Convey("Then templates with missing attributes are skipped", func() {
+			users := search("(uid=bob)")
+
+			So(users, ShouldHaveLength, 1)
+			So(users[0].Attributes, ShouldNotContainKey, "displayName")
+		})
+
+		Convey("Then renamed attributes are searched with the original name", func() {
+			users := search("(givenName=Bob)")
+
+			So(users, ShouldHaveLength, 1)
+			So(delegate.filter.(*ldap.EqualityMatch).Attribute, ShouldEqual, "firstname")
+		})
+
+		Convey("Then computed attributes are searched after the transformation", func() {
+			users := search("(&(objectClass=inetOrgPerson)(displayName=Alice Smith))")
+
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "alice")
+			So(delegate.filter, ShouldResemble, &ldap.Present{Attribute: "objectClass"})
+		})
+
+		Convey("Then negated computed attributes are searched after the transformation", func() {
+			users := search("(!(displayName=Alice Smith))")
+
+			So(users, ShouldHaveLength, 1)
+			So(users[0].DN, ShouldEqual, "bob")
+		})
+
+		Convey("Then dropped and hidden attributes match no user", func() {
+			So(search("(password=x)"), ShouldBeEmpty)
+			So(search("(email=bob@example.com)"), ShouldBeEmpty)
+			So(delegate.calls, ShouldEqual, 0)
+		})
+
+		Convey("Then dropped attributes are absent in negations", func() {
+			So(search("(!(password=*))"), ShouldHaveLength, 2)
+		})
+	})
+
+	Convey("Given an unknown hash algorithm", t, func() {
+		_, err := NewBackend(&testBackend{}, &Config{Hash: map[string]string{"uid": "crc32"}})
+
+		Convey("Then an error should be returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHash(t *testing.T) {
+	Convey("Given a hashed attribute", t, func() {
+		delegate := &testBackend{users: []*pkg.User{
+			{DN: "alice", Attributes: map[string][]string{"uid": {"alice"}}},
+		}}
+		backend, err := NewBackend(delegate, &Config{Hash: map[string]string{"uid": "sha1"}})
+		So(err, ShouldBeNil)
+
+		Convey("Then the values are hashed", func() {
+			users, err := backend.GetUsers(context.Background(), nil)
+
+			So(err, ShouldBeNil)
+			So(users[0].Attributes["uid"], ShouldResemble, []string{"522b276a356bdf39013dfabea2cd43e141ecc9e8"})
+		})
+	})
+}
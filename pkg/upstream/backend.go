@@ -0,0 +1,346 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package upstream
+
+import (
+	"context"
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/dn"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	"strings"
+)
+
+var (
+	errNoUrls        = errors.New("upstream: no urls configured")
+	errUserNotFound  = errors.New("upstream: user not found")
+	errUserNotUnique = errors.New("upstream: user not unique")
+	errNoPlaceholder = errors.New("upstream: userFilter or userDn without placeholder")
+)
+
+type PoolConfig struct {
+	Size                int           `json:"size"`
+	HealthCheckInterval util.Duration `json:"healthCheckInterval"`
+}
+
+type Config struct {
+	pkg.Config
+	Urls     []string       `json:"urls"`
+	StartTLS bool           `json:"startTls"`
+	TLS      util.TLSConfig `json:"tls"`
+
+	BindDn       string `json:"bindDn"`
+	BindPassword string `json:"bindPassword"`
+
+	SearchBase string   `json:"searchBase"`
+	Filter     string   `json:"filter"`
+	Attributes []string `json:"attributes"`
+
+	UserFilter string `json:"userFilter"`
+	UserDn     string `json:"userDn"`
+
+	Timeout util.Duration `json:"timeout"`
+	Pool    PoolConfig    `json:"pool"`
+}
+
+// Backend uses an existing ldap directory as data source. Users are
+// authenticated by binding upstream either directly or after searching the
+// user with the service account.
+type Backend struct {
+	config *Config
+	pool   *Pool
+
+	filter     ldap.Filter
+	userFilter ldap.Filter
+	attributes map[string]bool
+}
+
+var _ pkg.Backend = &Backend{}
+var _ pkg.ErrorAuthenticator = &Backend{}
+var _ pkg.Closer = &Backend{}
+
+func NewBackend(config *Config) (*Backend, error) {
+	if len(config.Urls) == 0 {
+		return nil, errNoUrls
+	}
+
+	backend := &Backend{
+		config: config,
+	}
+
+	var servers []*Server
+	for _, rawUrl := range config.Urls {
+		srv, err := ParseServer(rawUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		servers = append(servers, srv)
+	}
+
+	if config.Filter != "" {
+		filter, err := ldap.ParseFilter(config.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.filter = filter
+	}
+
+	if config.UserFilter != "" {
+		if !strings.Contains(config.UserFilter, "%s") {
+			return nil, errNoPlaceholder
+		}
+
+		userFilter, err := ldap.ParseFilter(config.UserFilter)
+		if err != nil {
+			return nil, err
+		}
+
+		backend.userFilter = userFilter
+	}
+	if config.UserDn != "" && !strings.Contains(config.UserDn, "%s") {
+		return nil, errNoPlaceholder
+	}
+
+	if len(config.Attributes) > 0 {
+		backend.attributes = make(map[string]bool)
+		for _, attr := range config.Attributes {
+			backend.attributes[strings.ToLower(attr)] = true
+		}
+	}
+
+	tlsConfig, err := config.TLS.Load("")
+	if err != nil {
+		return nil, err
+	}
+
+	backend.pool = NewPool(servers, PoolOptions{
+		StartTLS:            config.StartTLS,
+		TLS:                 tlsConfig,
+		BindDn:              config.BindDn,
+		BindPassword:        config.BindPassword,
+		Size:                config.Pool.Size,
+		Timeout:             config.Timeout.Duration,
+		HealthCheckInterval: config.Pool.HealthCheckInterval.Duration,
+	})
+
+	return backend, nil
+}
+
+func (backend *Backend) Name() (name string) {
+	return backend.config.Name
+}
+
+func (backend *Backend) Authenticate(ctx context.Context, username string, password string) bool {
+	ok, err := backend.AuthenticateErr(ctx, username, password)
+	if err != nil && err != pkg.ErrUnknownUser {
+		log.Printf("[upstream] bind of %s failed: %s", username, err)
+	}
+
+	return ok
+}
+
+// AuthenticateErr returns an error if the upstream server couldn't be asked or
+// is busy and pkg.ErrUnknownUser if the search for the user found nothing,
+// other ldap results like invalid credentials are rejections.
+func (backend *Backend) AuthenticateErr(ctx context.Context, username string, password string) (bool, error) {
+	// an empty password would result in an unauthenticated bind
+	if password == "" {
+		return false, nil
+	}
+
+	dn, err := backend.userDn(ctx, username)
+	if err == errUserNotFound {
+		log.Debugf("[upstream] no dn for %s: %s", username, err)
+		return false, pkg.ErrUnknownUser
+	}
+	if err == errUserNotUnique {
+		log.Debugf("[upstream] no dn for %s: %s", username, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = backend.pool.Bind(ctx, dn, password)
+	if res, ok := err.(*ldap.BaseResponse); ok && res.Code != ldap.ResultBusy && res.Code != ldap.ResultUnavailable {
+		log.Debugf("[upstream] bind of %s failed: %s", dn, err)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (backend *Backend) GetUsers(ctx context.Context, f ldap.Filter) ([]*pkg.User, error) {
+	users := []*pkg.User{}
+
+	baseDn := backend.searchBase(pkg.SearchBase(ctx))
+	if baseDn == "" {
+		return users, nil
+	}
+
+	filter := backend.combineFilter(f)
+	log.Debugf("[upstream] searching %s in %s", filter, baseDn)
+
+	results, err := backend.pool.Search(ctx, &ldap.SearchRequest{
+		BaseDN:     baseDn,
+		Scope:      ldap.ScopeWholeSubtree,
+		Filter:     filter,
+		Attributes: backend.attributes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		user := &pkg.User{
+			DN:         result.DN,
+			Attributes: map[string][]string{},
+		}
+
+		for attr, values := range result.Attributes {
+			for _, value := range values {
+				user.Attributes[attr] = append(user.Attributes[attr], string(value))
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (backend *Backend) Close() {
+	backend.pool.Close()
+}
+
+// userDn determines the dn to bind as for the given user name.
+func (backend *Backend) userDn(ctx context.Context, username string) (string, error) {
+	switch {
+	case backend.userFilter != nil:
+		results, err := backend.pool.Search(ctx, &ldap.SearchRequest{
+			BaseDN:     backend.config.SearchBase,
+			Scope:      ldap.ScopeWholeSubtree,
+			Filter:     substitute(backend.userFilter, username),
+			Attributes: map[string]bool{"1.1": true},
+		})
+		if err != nil {
+			return "", err
+		}
+
+		switch len(results) {
+		case 0:
+			return "", errUserNotFound
+		case 1:
+			return results[0].DN, nil
+		default:
+			return "", errUserNotUnique
+		}
+
+	case backend.config.UserDn != "":
+		return strings.Replace(backend.config.UserDn, "%s", dn.EscapeValue(username), -1), nil
+
+	default:
+		return username, nil
+	}
+}
+
+// searchBase determines the base dn of the upstream search. If the requested
+// base is outside of the configured search base no search is needed at all.
+func (backend *Backend) searchBase(requested string) string {
+	searchBase := backend.config.SearchBase
+
+	switch {
+	case searchBase == "":
+		return ""
+	case dn.IsWithin(requested, searchBase):
+		return requested
+	case requested == "" || dn.IsWithin(searchBase, requested):
+		return searchBase
+	default:
+		return ""
+	}
+}
+
+func (backend *Backend) combineFilter(f ldap.Filter) ldap.Filter {
+	switch {
+	case f == nil && backend.filter == nil:
+		return &ldap.Present{Attribute: "objectClass"}
+	case f == nil:
+		return backend.filter
+	case backend.filter == nil:
+		return f
+	default:
+		return &ldap.AND{Filters: []ldap.Filter{backend.filter, f}}
+	}
+}
+
+// substitute replaces the placeholder %s inside the assertion values of the
+// filter. The user name isn't put into the filter string, so it can't change
+// the structure of the filter.
+func substitute(f ldap.Filter, value string) ldap.Filter {
+	replace := func(s string) string {
+		return strings.Replace(s, "%s", value, -1)
+	}
+
+	switch f := f.(type) {
+	case *ldap.AND:
+		and := &ldap.AND{}
+		for _, filter := range f.Filters {
+			and.Filters = append(and.Filters, substitute(filter, value))
+		}
+		return and
+	case *ldap.OR:
+		or := &ldap.OR{}
+		for _, filter := range f.Filters {
+			or.Filters = append(or.Filters, substitute(filter, value))
+		}
+		return or
+	case *ldap.NOT:
+		return &ldap.NOT{Filter: substitute(f.Filter, value)}
+	case *ldap.EqualityMatch:
+		return &ldap.EqualityMatch{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.ApproxMatch:
+		return &ldap.ApproxMatch{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.GreaterOrEqual:
+		return &ldap.GreaterOrEqual{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.LessOrEqual:
+		return &ldap.LessOrEqual{Attribute: f.Attribute, Value: []byte(replace(string(f.Value)))}
+	case *ldap.Substrings:
+		substrings := &ldap.Substrings{
+			Attribute: f.Attribute,
+			Initial:   replace(f.Initial),
+			Final:     replace(f.Final),
+		}
+		for _, any := range f.Any {
+			substrings.Any = append(substrings.Any, replace(any))
+		}
+		return substrings
+	default:
+		return f
+	}
+}
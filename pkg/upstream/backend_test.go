@@ -0,0 +1,343 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package upstream
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/gopenguin/ldap-proxy/pkg"
+	"github.com/gopenguin/ldap-proxy/pkg/util"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var upstreamUsers = map[string]string{
+	"cn=proxy,dc=example,dc=org":            "service",
+	"uid=alice,ou=People,dc=example,dc=org": "alice-secret",
+	"uid=bob,ou=People,dc=example,dc=org":   "bob-secret",
+}
+
+// upstreamBackend is a minimal ldap server backend serving the upstreamUsers
+type upstreamBackend struct {
+	ldap.Backend
+
+	connects int32
+
+	mu         sync.Mutex
+	lastSearch *ldap.SearchRequest
+	searchedAs string
+}
+
+type upstreamSession struct {
+	dn string
+}
+
+func (backend *upstreamBackend) Connect(remoteAddr net.Addr) (ldap.Context, error) {
+	atomic.AddInt32(&backend.connects, 1)
+	return &upstreamSession{}, nil
+}
+
+func (*upstreamBackend) Disconnect(ctx ldap.Context) {
+}
+
+func (*upstreamBackend) Bind(ctx ldap.Context, req *ldap.BindRequest) (*ldap.BindResponse, error) {
+	sess := ctx.(*upstreamSession)
+	sess.dn = ""
+
+	res := &ldap.BindResponse{
+		BaseResponse: ldap.BaseResponse{
+			Code: ldap.ResultInvalidCredentials,
+		},
+	}
+
+	if password, ok := upstreamUsers[req.DN]; req.DN == "" || ok && password == string(req.Password) {
+		sess.dn = req.DN
+		res.BaseResponse.Code = ldap.ResultSuccess
+	}
+
+	return res, nil
+}
+
+func (backend *upstreamBackend) Search(ctx ldap.Context, req *ldap.SearchRequest) (*ldap.SearchResponse, error) {
+	backend.mu.Lock()
+	backend.lastSearch = req
+	backend.searchedAs = ctx.(*upstreamSession).dn
+	backend.mu.Unlock()
+
+	res := &ldap.SearchResponse{}
+	for dn := range upstreamUsers {
+		uid := strings.TrimPrefix(strings.Split(dn, ",")[0], "uid=")
+		if uid == strings.Split(dn, ",")[0] || !strings.HasSuffix(dn, req.BaseDN) {
+			continue
+		}
+
+		if strings.Contains(req.Filter.String(), "(uid=") && !strings.Contains(req.Filter.String(), "(uid="+uid+")") {
+			continue
+		}
+
+		res.Results = append(res.Results, &ldap.SearchResult{
+			DN: dn,
+			Attributes: map[string][][]byte{
+				"uid":         {[]byte(uid)},
+				"objectClass": {[]byte("top"), []byte("inetOrgPerson")},
+			},
+		})
+	}
+
+	return res, nil
+}
+
+func withUpstream(t *testing.T, tlsConfig *tls.Config, test func(upstream *upstreamBackend, url string)) func() {
+	return func() {
+		dirname, cleanupTmpDir := util.TmpDir(t)
+		defer cleanupTmpDir()
+
+		unixSocketPath := filepath.Join(dirname, "upstream.sock")
+
+		upstream := &upstreamBackend{Backend: ldap.DebugBackend}
+		server, err := ldap.NewServer(upstream, tlsConfig)
+		So(err, ShouldBeNil)
+		go server.Serve("unix", unixSocketPath)
+		So(server.WaitReady(1*time.Second), ShouldBeNil)
+		defer server.Close()
+
+		test(upstream, "ldapi://"+url.PathEscape(unixSocketPath))
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	Convey("Given a config without urls", t, func() {
+		_, err := NewBackend(&Config{})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldEqual, errNoUrls)
+		})
+	})
+
+	Convey("Given a config with an invalid filter", t, func() {
+		_, err := NewBackend(&Config{Urls: []string{"ldap://localhost"}, Filter: "(uid=a"})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a user filter without placeholder", t, func() {
+		_, err := NewBackend(&Config{Urls: []string{"ldap://localhost"}, UserFilter: "(uid=alice)"})
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldEqual, errNoPlaceholder)
+		})
+	})
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	Convey("Given an upstream ldap server", t, withUpstream(t, nil, func(upstream *upstreamBackend, url string) {
+		Convey("Given a backend binding directly", func() {
+			backend, err := NewBackend(&Config{
+				Urls:   []string{url},
+				UserDn: "uid=%s,ou=People,dc=example,dc=org",
+			})
+			So(err, ShouldBeNil)
+			defer backend.Close()
+
+			Convey("Then users authenticate with their password", func() {
+				So(backend.Authenticate(context.Background(), "alice", "alice-secret"), ShouldBeTrue)
+				So(backend.Authenticate(context.Background(), "alice", "bob-secret"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "alice", ""), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "carol", "alice-secret"), ShouldBeFalse)
+			})
+
+			Convey("Then the connection is reused", func() {
+				for i := 0; i < 5; i++ {
+					backend.Authenticate(context.Background(), "alice", "alice-secret")
+				}
+
+				So(atomic.LoadInt32(&upstream.connects), ShouldEqual, 1)
+			})
+		})
+
+		Convey("Given a backend using search then bind", func() {
+			backend, err := NewBackend(&Config{
+				Urls:         []string{url},
+				BindDn:       "cn=proxy,dc=example,dc=org",
+				BindPassword: "service",
+				SearchBase:   "ou=People,dc=example,dc=org",
+				UserFilter:   "(&(objectClass=inetOrgPerson)(uid=%s))",
+			})
+			So(err, ShouldBeNil)
+			defer backend.Close()
+
+			Convey("Then users authenticate with their password", func() {
+				So(backend.Authenticate(context.Background(), "bob", "bob-secret"), ShouldBeTrue)
+				So(upstream.searchedAs, ShouldEqual, "cn=proxy,dc=example,dc=org")
+				So(upstream.lastSearch.Filter.String(), ShouldEqual, "(&(objectClass=inetOrgPerson)(uid=bob))")
+
+				So(backend.Authenticate(context.Background(), "bob", "alice-secret"), ShouldBeFalse)
+				So(backend.Authenticate(context.Background(), "carol", "alice-secret"), ShouldBeFalse)
+			})
+
+			Convey("Then users without dn are reported as unknown", func() {
+				ok, err := backend.AuthenticateErr(context.Background(), "carol", "alice-secret")
+				So(ok, ShouldBeFalse)
+				So(err, ShouldEqual, pkg.ErrUnknownUser)
+			})
+
+			Convey("Then the user name is escaped inside the filter", func() {
+				So(backend.Authenticate(context.Background(), "*", "alice-secret"), ShouldBeFalse)
+				So(upstream.lastSearch.Filter.String(), ShouldEqual, `(&(objectClass=inetOrgPerson)(uid=\2a))`)
+			})
+
+			Convey("Then searches after a user bind are done as the service account", func() {
+				So(backend.Authenticate(context.Background(), "bob", "bob-secret"), ShouldBeTrue)
+
+				_, err := backend.GetUsers(context.Background(), nil)
+				So(err, ShouldBeNil)
+				So(upstream.searchedAs, ShouldEqual, "cn=proxy,dc=example,dc=org")
+			})
+		})
+	}))
+}
+
+func TestBackend_GetUsers(t *testing.T) {
+	Convey("Given an upstream ldap server and a backend", t, withUpstream(t, nil, func(upstream *upstreamBackend, url string) {
+		backend, err := NewBackend(&Config{
+			Urls:       []string{url},
+			SearchBase: "ou=People,dc=example,dc=org",
+			Filter:     "(objectClass=inetOrgPerson)",
+			Attributes: []string{"uid"},
+		})
+		So(err, ShouldBeNil)
+		defer backend.Close()
+
+		Convey("When the users are requested", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("alice")})
+
+			Convey("Then the filter is combined with the configured filter", func() {
+				So(err, ShouldBeNil)
+				So(upstream.lastSearch.BaseDN, ShouldEqual, "ou=People,dc=example,dc=org")
+				So(upstream.lastSearch.Filter.String(), ShouldEqual, "(&(objectClass=inetOrgPerson)(uid=alice))")
+				So(upstream.lastSearch.Attributes, ShouldResemble, map[string]bool{"uid": true})
+			})
+
+			Convey("Then the matching users are returned", func() {
+				So(users, ShouldHaveLength, 1)
+				So(users[0].DN, ShouldEqual, "uid=alice,ou=People,dc=example,dc=org")
+				So(users[0].Attributes["uid"], ShouldResemble, []string{"alice"})
+				So(users[0].Attributes["objectClass"], ShouldResemble, []string{"top", "inetOrgPerson"})
+			})
+		})
+
+		Convey("When the users of another subtree are requested", func() {
+			users, err := backend.GetUsers(context.Background(), &ldap.EqualityMatch{Attribute: "uid", Value: []byte("nobody")})
+
+			Convey("Then a missing base object results in no users", func() {
+				So(err, ShouldBeNil)
+				So(users, ShouldHaveLength, 0)
+			})
+		})
+	}))
+}
+
+func TestBackend_SearchBase(t *testing.T) {
+	Convey("Given a backend with a search base", t, func() {
+		backend := &Backend{config: &Config{SearchBase: "dc=legacy,dc=org"}}
+
+		Convey("Then searches inside the search base keep their base", func() {
+			So(backend.searchBase("ou=People,dc=legacy,dc=org"), ShouldEqual, "ou=People,dc=legacy,dc=org")
+		})
+
+		Convey("Then searches above the search base are narrowed", func() {
+			So(backend.searchBase(""), ShouldEqual, "dc=legacy,dc=org")
+			So(backend.searchBase("dc=org"), ShouldEqual, "dc=legacy,dc=org")
+		})
+
+		Convey("Then searches outside of the search base are skipped", func() {
+			So(backend.searchBase("dc=example,dc=org"), ShouldBeBlank)
+		})
+	})
+}
+
+func TestPlaceholders(t *testing.T) {
+	Convey("User names are substituted into the filter", t, func() {
+		filter, err := ldap.ParseFilter("(|(uid=%s)(mail=%s@*)(!(cn=%s)))")
+		So(err, ShouldBeNil)
+		So(substitute(filter, "a*(b)").String(), ShouldEqual, `(|(uid=a\2a\28b\29)(mail=a\2a\28b\29@*)(!(cn=a\2a\28b\29)))`)
+	})
+}
+
+func TestStartTLS(t *testing.T) {
+	Convey("Given an upstream ldap server supporting StartTLS", t, withUpstream(t, selfSignedTlsConfig(t), func(upstream *upstreamBackend, url string) {
+		backend, err := NewBackend(&Config{
+			Urls:     []string{url},
+			StartTLS: true,
+			TLS: util.TLSConfig{
+				InsecureSkipVerify: true,
+			},
+		})
+		So(err, ShouldBeNil)
+		defer backend.Close()
+
+		Convey("Then users authenticate over tls", func() {
+			So(backend.Authenticate(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeTrue)
+		})
+	}))
+}
+
+func selfSignedTlsConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: key}},
+	}
+}
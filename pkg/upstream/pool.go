@@ -0,0 +1,367 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/gopenguin/ldap-proxy/pkg/log"
+	"github.com/samuel/go-ldap/ldap"
+	"sync"
+	"time"
+)
+
+var (
+	errNoServerReachable = errors.New("upstream: no server reachable")
+)
+
+const (
+	defaultPoolSize            = 4
+	defaultTimeout             = 10 * time.Second
+	defaultHealthCheckInterval = 30 * time.Second
+)
+
+// PoolOptions configure the connections of a Pool.
+type PoolOptions struct {
+	StartTLS bool
+	TLS      *tls.Config
+
+	// The service account used for searches. An empty BindDn searches anonymously.
+	BindDn       string
+	BindPassword string
+
+	// The maximum number of open connections
+	Size int
+	// The timeout of a single ldap operation
+	Timeout time.Duration
+	// The interval in which idle connections are checked
+	HealthCheckInterval time.Duration
+}
+
+// Pool keeps a limited number of connections to the first reachable upstream
+// server. Idle connections are checked periodically and dropped if they are
+// broken.
+type Pool struct {
+	servers []*Server
+	options PoolOptions
+
+	slots     chan struct{}
+	idle      chan *conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type conn struct {
+	client  *ldap.Client
+	server  *Server
+	boundDn string
+	broken  bool
+}
+
+func NewPool(servers []*Server, options PoolOptions) *Pool {
+	if options.Size <= 0 {
+		options.Size = defaultPoolSize
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.HealthCheckInterval <= 0 {
+		options.HealthCheckInterval = defaultHealthCheckInterval
+	}
+
+	pool := &Pool{
+		servers: servers,
+		options: options,
+		slots:   make(chan struct{}, options.Size),
+		idle:    make(chan *conn, options.Size),
+		done:    make(chan struct{}),
+	}
+
+	go pool.healthCheck()
+
+	return pool
+}
+
+// Bind checks the credentials against the upstream server.
+func (pool *Pool) Bind(ctx context.Context, dn string, password string) error {
+	c, err := pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.put(c)
+
+	return pool.bind(ctx, c, dn, password)
+}
+
+// Search searches as the service account. A missing base object results in an
+// empty result.
+func (pool *Pool) Search(ctx context.Context, req *ldap.SearchRequest) ([]*ldap.SearchResult, error) {
+	c, err := pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer pool.put(c)
+
+	if c.boundDn != pool.options.BindDn {
+		if err := pool.bind(ctx, c, pool.options.BindDn, pool.options.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []*ldap.SearchResult
+	err = pool.do(ctx, c, func(client *ldap.Client) (err error) {
+		results, err = client.Search(req)
+		return
+	})
+	if res, ok := err.(*ldap.BaseResponse); ok && res.Code == ldap.ResultNoSuchObject {
+		return nil, nil
+	}
+
+	return results, err
+}
+
+// Close closes all idle connections and stops the health checks. The
+// connections in use are closed when they are released.
+func (pool *Pool) Close() {
+	pool.closeOnce.Do(func() {
+		close(pool.done)
+	})
+
+	pool.drain()
+}
+
+// drain closes the idle connections
+func (pool *Pool) drain() {
+	for {
+		select {
+		case c := <-pool.idle:
+			c.client.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (pool *Pool) bind(ctx context.Context, c *conn, dn string, password string) error {
+	err := pool.do(ctx, c, func(client *ldap.Client) error {
+		return client.Bind(dn, []byte(password))
+	})
+	if err != nil {
+		// a failed bind leaves the connection anonymous
+		c.boundDn = ""
+		return err
+	}
+
+	c.boundDn = dn
+	return nil
+}
+
+// do runs the operation with the configured timeout. Connections with network
+// errors or timeouts are marked as broken.
+func (pool *Pool) do(ctx context.Context, c *conn, operation func(client *ldap.Client) error) error {
+	ctx, cancel := context.WithTimeout(ctx, pool.options.Timeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func(client *ldap.Client) {
+		result <- operation(client)
+	}(c.client)
+
+	select {
+	case err := <-result:
+		if _, ok := err.(*ldap.BaseResponse); err != nil && !ok {
+			c.broken = true
+		}
+		return err
+	case <-ctx.Done():
+		c.broken = true
+		c.client.Close()
+		return ctx.Err()
+	}
+}
+
+func (pool *Pool) get(ctx context.Context) (*conn, error) {
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case c := <-pool.idle:
+		return c, nil
+	default:
+	}
+
+	c, err := pool.dial(ctx)
+	if err != nil {
+		<-pool.slots
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (pool *Pool) put(c *conn) {
+	pool.release(c)
+	<-pool.slots
+}
+
+// release returns the connection to the idle connections or closes it if it
+// is broken or the pool is closed.
+func (pool *Pool) release(c *conn) {
+	if c.broken {
+		log.Debugf("[upstream] dropping broken connection to %s", c.server.Url)
+		c.client.Close()
+		return
+	}
+
+	if pool.closed() {
+		c.client.Close()
+		return
+	}
+
+	select {
+	case pool.idle <- c:
+	default:
+		c.client.Close()
+	}
+
+	// the pool may have been closed and drained in the meantime
+	if pool.closed() {
+		pool.drain()
+	}
+}
+
+func (pool *Pool) closed() bool {
+	select {
+	case <-pool.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dial connects to the first reachable server
+func (pool *Pool) dial(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, pool.options.Timeout)
+	defer cancel()
+
+	for _, srv := range pool.servers {
+		client, err := srv.dial(ctx, pool.options.StartTLS, pool.options.TLS)
+		if err == nil {
+			return &conn{
+				client: client,
+				server: srv,
+			}, nil
+		}
+
+		log.Debugf("[upstream] %s not reachable: %s", srv.Url, err)
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, errNoServerReachable
+}
+
+func (pool *Pool) healthCheck() {
+	ticker := time.NewTicker(pool.options.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+			pool.checkIdle()
+		}
+	}
+}
+
+// checkIdle reads the root dse with every idle connection. Without idle
+// connections a new one is dialed and checked, so an unreachable server is
+// noticed before the next request. A checked connection takes a slot like a
+// request, so requests don't dial more connections than the pool size in the
+// meantime. The check stops if all slots are in use.
+func (pool *Pool) checkIdle() {
+	checked := false
+	for i := len(pool.idle); i > 0; i-- {
+		select {
+		case pool.slots <- struct{}{}:
+		default:
+			return
+		}
+
+		var c *conn
+		select {
+		case c = <-pool.idle:
+		default:
+			<-pool.slots
+			return
+		}
+
+		pool.check(c)
+		pool.put(c)
+		checked = true
+	}
+
+	if !checked {
+		pool.checkNew()
+	}
+}
+
+// checkNew dials and checks a new connection if none is in use and keeps it
+// idle
+func (pool *Pool) checkNew() {
+	select {
+	case pool.slots <- struct{}{}:
+	default:
+		return
+	}
+	defer func() { <-pool.slots }()
+
+	if len(pool.slots) > 1 {
+		return // the connections in use are checked by their requests
+	}
+
+	c, err := pool.dial(context.Background())
+	if err != nil {
+		log.Printf("[upstream] health check failed: %s", err)
+		return
+	}
+
+	pool.check(c)
+	pool.release(c)
+}
+
+// check reads the root dse, failures mark the connection as broken
+func (pool *Pool) check(c *conn) {
+	pool.do(context.Background(), c, func(client *ldap.Client) error {
+		_, err := client.Search(&ldap.SearchRequest{
+			Scope:  ldap.ScopeBaseObject,
+			Filter: &ldap.Present{Attribute: "objectClass"},
+		})
+		return err
+	})
+}
@@ -0,0 +1,142 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package upstream
+
+import (
+	"context"
+	. "github.com/smartystreets/goconvey/convey"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	Convey("Given an upstream ldap server", t, withUpstream(t, nil, func(upstream *upstreamBackend, url string) {
+		srv, err := ParseServer(url)
+		So(err, ShouldBeNil)
+
+		Convey("Given a pool with an unreachable server and the upstream server", func() {
+			unreachable, err := ParseServer("ldapi://%2Fnonexistent%2Fldap.sock")
+			So(err, ShouldBeNil)
+
+			pool := NewPool([]*Server{unreachable, srv}, PoolOptions{})
+			defer pool.Close()
+
+			Convey("Then the pool fails over to the upstream server", func() {
+				So(pool.Bind(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeNil)
+			})
+		})
+
+		Convey("Given a pool with a single connection", func() {
+			pool := NewPool([]*Server{srv}, PoolOptions{Size: 1})
+			defer pool.Close()
+
+			Convey("When the connection is in use", func() {
+				c, err := pool.get(context.Background())
+				So(err, ShouldBeNil)
+
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+
+				Convey("Then further requests wait for it", func() {
+					So(pool.Bind(ctx, "uid=alice,ou=People,dc=example,dc=org", "alice-secret") == context.DeadlineExceeded, ShouldBeTrue)
+
+					pool.put(c)
+					So(pool.Bind(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeNil)
+					So(atomic.LoadInt32(&upstream.connects), ShouldEqual, 1)
+				})
+			})
+
+			Convey("When an idle connection breaks", func() {
+				So(pool.Bind(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeNil)
+				So(pool.idle, ShouldHaveLength, 1)
+
+				c := <-pool.idle
+				c.client.Close()
+				pool.idle <- c
+
+				Convey("Then the health check drops it", func() {
+					pool.checkIdle()
+					So(pool.idle, ShouldHaveLength, 0)
+
+					So(pool.Bind(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeNil)
+					So(atomic.LoadInt32(&upstream.connects), ShouldEqual, 2)
+				})
+			})
+
+			Convey("When there is no connection", func() {
+				pool.checkIdle()
+
+				Convey("Then the health check dials and keeps one", func() {
+					So(pool.idle, ShouldHaveLength, 1)
+					So(atomic.LoadInt32(&upstream.connects), ShouldEqual, 1)
+				})
+			})
+
+			Convey("When the connection is in use during the health check", func() {
+				c, err := pool.get(context.Background())
+				So(err, ShouldBeNil)
+
+				pool.checkIdle()
+				defer pool.put(c)
+
+				Convey("Then no connection is dialed", func() {
+					So(pool.idle, ShouldHaveLength, 0)
+				})
+			})
+
+			Convey("When all slots are in use during the health check", func() {
+				So(pool.Bind(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeNil)
+				pool.slots <- struct{}{}
+
+				pool.checkIdle()
+
+				Convey("Then the idle connection isn't taken", func() {
+					So(pool.idle, ShouldHaveLength, 1)
+					So(pool.slots, ShouldHaveLength, 1)
+					<-pool.slots
+				})
+			})
+
+			Convey("When the pool is closed while a connection is in use", func() {
+				c, err := pool.get(context.Background())
+				So(err, ShouldBeNil)
+
+				pool.Close()
+				pool.put(c)
+
+				Convey("Then the connection is closed when it is released", func() {
+					So(pool.idle, ShouldHaveLength, 0)
+					So(c.client.Bind("uid=alice,ou=People,dc=example,dc=org", []byte("alice-secret")), ShouldNotBeNil)
+				})
+			})
+
+			Convey("When an idle connection is healthy", func() {
+				So(pool.Bind(context.Background(), "uid=alice,ou=People,dc=example,dc=org", "alice-secret"), ShouldBeNil)
+
+				Convey("Then the health check keeps it", func() {
+					pool.checkIdle()
+					So(pool.idle, ShouldHaveLength, 1)
+				})
+			})
+		})
+	}))
+}
@@ -0,0 +1,133 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/samuel/go-ldap/ldap"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Server is a remote ldap server reachable by an ldap url.
+type Server struct {
+	// Url is the url of the server without dn, e. g. ldap://ldap.example.com:389
+	Url string
+
+	network string
+	address string
+	ldaps   bool
+	host    string
+}
+
+// ParseServer parses an ldap url like ldap://host:389, ldaps://host or
+// ldapi://%2Fvar%2Frun%2Fldap.sock
+func ParseServer(rawUrl string) (*Server, error) {
+	scheme := strings.ToLower(strings.SplitN(rawUrl, "://", 2)[0])
+
+	if scheme == "ldapi" {
+		socket, err := url.PathUnescape(strings.SplitN(strings.TrimPrefix(rawUrl[len(scheme):], "://"), "/", 2)[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return &Server{
+			Url:     strings.TrimSuffix(rawUrl, "/"),
+			network: "unix",
+			address: socket,
+		}, nil
+	}
+
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		Url:     fmt.Sprintf("%s://%s", scheme, parsedUrl.Host),
+		network: "tcp",
+		address: parsedUrl.Host,
+		host:    parsedUrl.Hostname(),
+	}
+
+	switch scheme {
+	case "ldap":
+		if parsedUrl.Port() == "" {
+			srv.address = net.JoinHostPort(parsedUrl.Hostname(), "389")
+		}
+	case "ldaps":
+		if parsedUrl.Port() == "" {
+			srv.address = net.JoinHostPort(parsedUrl.Hostname(), "636")
+		}
+		srv.ldaps = true
+	default:
+		return nil, fmt.Errorf("upstream: unsupported url scheme '%s'", scheme)
+	}
+
+	return srv, nil
+}
+
+// dial connects to the server. The tls config is used for ldaps and StartTLS.
+func (srv *Server) dial(ctx context.Context, startTLS bool, tlsConfig *tls.Config) (*ldap.Client, error) {
+	dialer := &net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, srv.network, srv.address)
+	if err != nil {
+		return nil, err
+	}
+
+	if srv.ldaps {
+		tlsConn := tls.Client(conn, srv.tlsConfig(tlsConfig))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return ldap.NewClient(tlsConn, true), nil
+	}
+
+	client := ldap.NewClient(conn, false)
+
+	if startTLS {
+		if err := client.StartTLS(srv.tlsConfig(tlsConfig)); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (srv *Server) tlsConfig(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		return &tls.Config{ServerName: srv.host}
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = srv.host
+	}
+
+	return tlsConfig
+}
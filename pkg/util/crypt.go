@@ -0,0 +1,164 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"strings"
+)
+
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5CryptScheme verifies the md5 based $1$ crypt and its $apr1$ variant of
+// apache. The scheme is deprecated, it can't create hashes.
+type md5CryptScheme struct {
+	name  string
+	magic string
+}
+
+func (scheme md5CryptScheme) Name() string {
+	return scheme.name
+}
+
+func (scheme md5CryptScheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, scheme.magic)
+}
+
+func (scheme md5CryptScheme) Verify(hash string, password string) bool {
+	return constantTimeEqual(hash, md5Crypt(scheme.magic, hash, password))
+}
+
+func (md5CryptScheme) Hash(password string, cost int) (string, error) {
+	return "", errHashUnsupported
+}
+
+// desCryptScheme verifies the traditional des crypt. The scheme is
+// deprecated, it can't create hashes.
+type desCryptScheme struct{}
+
+func (desCryptScheme) Name() string {
+	return "des-crypt"
+}
+
+func (desCryptScheme) Match(hash string) bool {
+	if len(hash) != 13 {
+		return false
+	}
+
+	for _, c := range hash {
+		if !strings.ContainsRune(itoa64, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (desCryptScheme) Verify(hash string, password string) bool {
+	return constantTimeEqual(hash, desCrypt(hash[:2], password))
+}
+
+func (desCryptScheme) Hash(password string, cost int) (string, error) {
+	return "", errHashUnsupported
+}
+
+func constantTimeEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// md5Crypt implements the md5 based crypt of FreeBSD and its Apache variant.
+func md5Crypt(magic, hash, password string) string {
+	salt := strings.TrimPrefix(hash, magic)
+	if i := strings.IndexByte(salt, '$'); i >= 0 {
+		salt = salt[:i]
+	}
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	pw := []byte(password)
+
+	alternate := md5.New()
+	alternate.Write(pw)
+	alternate.Write([]byte(salt))
+	alternate.Write(pw)
+	final := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic))
+	ctx.Write([]byte(salt))
+	for pl := len(pw); pl > 0; pl -= 16 {
+		if pl > 16 {
+			ctx.Write(final)
+		} else {
+			ctx.Write(final[:pl])
+		}
+	}
+	for i := len(pw); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final = ctx.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	result := []byte(magic + salt + "$")
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint(final[group[0]])<<16 | uint(final[group[1]])<<8 | uint(final[group[2]])
+		result = appendBase64(result, v, 4)
+	}
+	result = appendBase64(result, uint(final[11]), 2)
+
+	return string(result)
+}
+
+func appendBase64(dst []byte, v uint, n int) []byte {
+	for ; n > 0; n-- {
+		dst = append(dst, itoa64[v&0x3f])
+		v >>= 6
+	}
+
+	return dst
+}
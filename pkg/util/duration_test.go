@@ -0,0 +1,63 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestDuration(t *testing.T) {
+	Convey("Given a json duration", t, func() {
+		var config struct {
+			Timeout Duration `json:"timeout"`
+		}
+
+		Convey("When it is valid", func() {
+			err := json.Unmarshal([]byte(`{"timeout": "1m30s"}`), &config)
+
+			Convey("Then it is parsed", func() {
+				So(err, ShouldBeNil)
+				So(config.Timeout.Duration, ShouldEqual, 90*time.Second)
+				So(config.Timeout.Or(time.Second), ShouldEqual, 90*time.Second)
+			})
+		})
+
+		Convey("When it is invalid", func() {
+			err := json.Unmarshal([]byte(`{"timeout": "soon"}`), &config)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("When it is missing", func() {
+			err := json.Unmarshal([]byte(`{}`), &config)
+
+			Convey("Then the fallback is used", func() {
+				So(err, ShouldBeNil)
+				So(config.Timeout.Or(time.Second), ShouldEqual, time.Second)
+			})
+		})
+	})
+}
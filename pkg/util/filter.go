@@ -0,0 +1,98 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"github.com/gopenguin/ldap-proxy/pkg/schema"
+	"github.com/samuel/go-ldap/ldap"
+)
+
+// MatchFilter evaluates the filter against the attributes of a single entry.
+// Attribute names and values are compared by the matching rules of the default
+// schema, the objectClass attribute is always present.
+func MatchFilter(f ldap.Filter, attributes map[string][]string) bool {
+	switch f := f.(type) {
+	case *ldap.AND:
+		for _, filter := range f.Filters {
+			if !MatchFilter(filter, attributes) {
+				return false
+			}
+		}
+		return true
+
+	case *ldap.OR:
+		for _, filter := range f.Filters {
+			if MatchFilter(filter, attributes) {
+				return true
+			}
+		}
+		return false
+
+	case *ldap.NOT:
+		return !MatchFilter(f.Filter, attributes)
+
+	case *ldap.EqualityMatch:
+		return matchValues(attributes, f.Attribute, func(value string) bool {
+			return schema.Default.Equal(f.Attribute, value, string(f.Value))
+		})
+
+	case *ldap.ApproxMatch:
+		return matchValues(attributes, f.Attribute, func(value string) bool {
+			return schema.Default.Equal(f.Attribute, value, string(f.Value))
+		})
+
+	case *ldap.GreaterOrEqual:
+		return matchValues(attributes, f.Attribute, func(value string) bool {
+			result, ok := schema.Default.Compare(f.Attribute, value, string(f.Value))
+			return ok && result >= 0
+		})
+
+	case *ldap.LessOrEqual:
+		return matchValues(attributes, f.Attribute, func(value string) bool {
+			result, ok := schema.Default.Compare(f.Attribute, value, string(f.Value))
+			return ok && result <= 0
+		})
+
+	case *ldap.Substrings:
+		return matchValues(attributes, f.Attribute, func(value string) bool {
+			return schema.Default.MatchSubstrings(f.Attribute, value, f.Initial, f.Any, f.Final)
+		})
+
+	case *ldap.Present:
+		if schema.Default.Key(f.Attribute) == "objectclass" {
+			return true
+		}
+
+		return len(schema.Default.Values(attributes, f.Attribute)) > 0
+	}
+
+	return false
+}
+
+func matchValues(attributes map[string][]string, name string, match func(value string) bool) bool {
+	for _, value := range schema.Default.Values(attributes, name) {
+		if match(value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMatchFilter(t *testing.T) {
+	Convey("Given the attributes of an entry", t, func() {
+		attributes := map[string][]string{
+			"cn":        {"Alice"},
+			"mail":      {"alice@example.com", "a.smith@example.com"},
+			"uidNumber": {"1000"},
+
+			"telephoneNumber": {"+1 555-0100"},
+			"loginShell":      {"/bin/bash"},
+		}
+
+		match := func(filter string) bool {
+			f, err := ldap.ParseFilter(filter)
+			So(err, ShouldBeNil)
+
+			return MatchFilter(f, attributes)
+		}
+
+		Convey("Then equality matches any value ignoring the case", func() {
+			So(match("(cn=alice)"), ShouldBeTrue)
+			So(match("(CN=Alice)"), ShouldBeTrue)
+			So(match("(mail=a.smith@example.com)"), ShouldBeTrue)
+			So(match("(cn=bob)"), ShouldBeFalse)
+			So(match("(sn=alice)"), ShouldBeFalse)
+		})
+
+		Convey("Then presence is evaluated", func() {
+			So(match("(mail=*)"), ShouldBeTrue)
+			So(match("(objectClass=*)"), ShouldBeTrue)
+			So(match("(sn=*)"), ShouldBeFalse)
+		})
+
+		Convey("Then substrings are matched", func() {
+			So(match("(mail=a*)"), ShouldBeTrue)
+			So(match("(mail=*@example.com)"), ShouldBeTrue)
+			So(match("(mail=a*smith*.com)"), ShouldBeTrue)
+			So(match("(mail=*bob*)"), ShouldBeFalse)
+		})
+
+		Convey("Then integers are ordered numerical", func() {
+			So(match("(uidNumber>=999)"), ShouldBeTrue)
+			So(match("(uidNumber<=999)"), ShouldBeFalse)
+			So(match("(uidNumber<=1000)"), ShouldBeTrue)
+		})
+
+		Convey("Then the names and matching rules of the schema are used", func() {
+			So(match("(commonName=alice)"), ShouldBeTrue)
+			So(match("(2.5.4.3=alice)"), ShouldBeTrue)
+			So(match("(telephoneNumber=+15550100)"), ShouldBeTrue)
+			So(match("(telephoneNumber=*555 01*)"), ShouldBeTrue)
+			So(match("(loginShell=/bin/bash)"), ShouldBeTrue)
+			So(match("(loginShell=/BIN/BASH)"), ShouldBeFalse)
+			So(match("(uidNumber=01000)"), ShouldBeTrue)
+			So(match("(uidNumber>=abc)"), ShouldBeFalse)
+		})
+
+		Convey("Then boolean combinations are evaluated", func() {
+			So(match("(&(cn=alice)(mail=*))"), ShouldBeTrue)
+			So(match("(&(cn=alice)(sn=*))"), ShouldBeFalse)
+			So(match("(|(cn=bob)(mail=alice@example.com))"), ShouldBeTrue)
+			So(match("(!(cn=bob))"), ShouldBeTrue)
+			So(match("(!(cn=alice))"), ShouldBeFalse)
+		})
+	})
+}
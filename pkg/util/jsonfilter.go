@@ -0,0 +1,82 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"github.com/samuel/go-ldap/ldap"
+)
+
+// A JSONFilter is the representation of an ldap filter passed to external
+// services. The type is one of and, or, not, equality, approx,
+// greaterOrEqual, lessOrEqual, substrings and present.
+type JSONFilter struct {
+	Type      string        `json:"type"`
+	Filters   []*JSONFilter `json:"filters,omitempty"`
+	Filter    *JSONFilter   `json:"filter,omitempty"`
+	Attribute string        `json:"attribute,omitempty"`
+	Value     *string       `json:"value,omitempty"`
+	Initial   string        `json:"initial,omitempty"`
+	Any       []string      `json:"any,omitempty"`
+	Final     string        `json:"final,omitempty"`
+}
+
+// NewJSONFilter converts the filter. Unknown filter types are converted to
+// their type only.
+func NewJSONFilter(f ldap.Filter) *JSONFilter {
+	value := func(v []byte) *string {
+		s := string(v)
+		return &s
+	}
+
+	switch f := f.(type) {
+	case nil:
+		return nil
+	case *ldap.AND:
+		return &JSONFilter{Type: "and", Filters: newJSONFilters(f.Filters)}
+	case *ldap.OR:
+		return &JSONFilter{Type: "or", Filters: newJSONFilters(f.Filters)}
+	case *ldap.NOT:
+		return &JSONFilter{Type: "not", Filter: NewJSONFilter(f.Filter)}
+	case *ldap.EqualityMatch:
+		return &JSONFilter{Type: "equality", Attribute: f.Attribute, Value: value(f.Value)}
+	case *ldap.ApproxMatch:
+		return &JSONFilter{Type: "approx", Attribute: f.Attribute, Value: value(f.Value)}
+	case *ldap.GreaterOrEqual:
+		return &JSONFilter{Type: "greaterOrEqual", Attribute: f.Attribute, Value: value(f.Value)}
+	case *ldap.LessOrEqual:
+		return &JSONFilter{Type: "lessOrEqual", Attribute: f.Attribute, Value: value(f.Value)}
+	case *ldap.Substrings:
+		return &JSONFilter{Type: "substrings", Attribute: f.Attribute, Initial: f.Initial, Any: f.Any, Final: f.Final}
+	case *ldap.Present:
+		return &JSONFilter{Type: "present", Attribute: f.Attribute}
+	default:
+		return &JSONFilter{Type: "unknown"}
+	}
+}
+
+func newJSONFilters(filters []ldap.Filter) []*JSONFilter {
+	result := make([]*JSONFilter, len(filters))
+	for i, f := range filters {
+		result[i] = NewJSONFilter(f)
+	}
+
+	return result
+}
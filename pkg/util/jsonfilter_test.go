@@ -0,0 +1,77 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"github.com/samuel/go-ldap/ldap"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestNewJSONFilter(t *testing.T) {
+	Convey("Given a filter", t, func() {
+		f, err := ldap.ParseFilter("(&(objectClass=*)(|(uid=alice)(mail=a*@*.org))(!(uidNumber<=100)))")
+		So(err, ShouldBeNil)
+
+		Convey("Then it is converted to JSON", func() {
+			data, err := json.Marshal(NewJSONFilter(f))
+
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{"type":"and","filters":[`+
+				`{"type":"present","attribute":"objectClass"},`+
+				`{"type":"or","filters":[{"type":"equality","attribute":"uid","value":"alice"},{"type":"substrings","attribute":"mail","initial":"a","any":["@"],"final":".org"}]},`+
+				`{"type":"not","filter":{"type":"lessOrEqual","attribute":"uidNumber","value":"100"}}]}`)
+		})
+	})
+
+	Convey("Given no filter", t, func() {
+		Convey("Then null is returned", func() {
+			data, err := json.Marshal(NewJSONFilter(nil))
+
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "null")
+		})
+	})
+}
+
+func TestValues(t *testing.T) {
+	Convey("Given attribute values in JSON", t, func() {
+		var attributes map[string]Values
+		err := json.Unmarshal([]byte(`{"cn": "alice", "mail": ["a@example.org", "b@example.org"], "uidNumber": 1000}`), &attributes)
+
+		Convey("Then single values and lists are accepted", func() {
+			So(err, ShouldBeNil)
+			So(attributes["cn"], ShouldResemble, Values{"alice"})
+			So(attributes["mail"], ShouldResemble, Values{"a@example.org", "b@example.org"})
+			So(attributes["uidNumber"], ShouldResemble, Values{"1000"})
+		})
+	})
+
+	Convey("Given nested attribute values", t, func() {
+		var attributes map[string]Values
+		err := json.Unmarshal([]byte(`{"cn": {"a": "b"}}`), &attributes)
+
+		Convey("Then an error is returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
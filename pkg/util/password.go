@@ -0,0 +1,260 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errUnknownPasswordScheme = errors.New("password: unknown scheme")
+	errHashUnsupported       = errors.New("password: scheme can't create hashes")
+)
+
+// A PasswordScheme verifies and creates the password hashes of one format.
+// The cost is specific to the scheme, 0 selects its default.
+type PasswordScheme interface {
+	Name() string
+	// Match reports whether the hash has the format of the scheme
+	Match(hash string) bool
+	Verify(hash string, password string) bool
+	Hash(password string, cost int) (string, error)
+}
+
+// A PasswordCoster is a scheme with a work factor. Hashes with a lower cost
+// than the one of new hashes are rehashed.
+type PasswordCoster interface {
+	Cost(hash string) (cost int, ok bool)
+}
+
+// the prefixes of rfc 2307 userPassword values which wrap crypt(3) hashes
+var cryptPrefixes = []string{"{CRYPT}", "{BCRYPT}", "{ARGON2}"}
+
+var passwordSchemes = struct {
+	sync.RWMutex
+	schemes []PasswordScheme
+
+	defaultScheme string
+	defaultCost   int
+}{
+	schemes: []PasswordScheme{
+		bcryptScheme{},
+		argon2Scheme{},
+		scryptScheme{},
+		pbkdf2Schemes["pbkdf2"],
+		pbkdf2Schemes["pbkdf2-sha256"],
+		pbkdf2Schemes["pbkdf2-sha512"],
+		shaCryptSchemes["sha256-crypt"],
+		shaCryptSchemes["sha512-crypt"],
+		md5CryptScheme{name: "md5-crypt", magic: "$1$"},
+		md5CryptScheme{name: "apr1", magic: "$apr1$"},
+		shaSchemes["sha"],
+		shaSchemes["ssha"],
+		shaSchemes["sha256"],
+		shaSchemes["ssha256"],
+		shaSchemes["sha512"],
+		shaSchemes["ssha512"],
+		desCryptScheme{},
+	},
+
+	defaultScheme: "bcrypt",
+	defaultCost:   12,
+}
+
+// RegisterPasswordScheme adds the scheme, schemes registered later are
+// detected first.
+func RegisterPasswordScheme(scheme PasswordScheme) {
+	passwordSchemes.Lock()
+	defer passwordSchemes.Unlock()
+
+	passwordSchemes.schemes = append([]PasswordScheme{scheme}, passwordSchemes.schemes...)
+}
+
+// AllowCleartextPasswords accepts passwords stored as {CLEARTEXT}password,
+// which is only meant for development.
+func AllowCleartextPasswords() {
+	RegisterPasswordScheme(cleartextScheme{})
+}
+
+// LookupPasswordScheme returns the scheme with the case insensitive name.
+func LookupPasswordScheme(name string) (PasswordScheme, bool) {
+	passwordSchemes.RLock()
+	defer passwordSchemes.RUnlock()
+
+	for _, scheme := range passwordSchemes.schemes {
+		if strings.EqualFold(scheme.Name(), name) {
+			return scheme, true
+		}
+	}
+
+	return nil, false
+}
+
+// DetectPasswordScheme returns the scheme of the hash and the hash without
+// the crypt prefix of userPassword values.
+func DetectPasswordScheme(hash string) (PasswordScheme, string, bool) {
+	for _, prefix := range cryptPrefixes {
+		if len(hash) > len(prefix) && strings.EqualFold(hash[:len(prefix)], prefix) {
+			hash = hash[len(prefix):]
+			break
+		}
+	}
+
+	passwordSchemes.RLock()
+	defer passwordSchemes.RUnlock()
+
+	for _, scheme := range passwordSchemes.schemes {
+		if scheme.Match(hash) {
+			return scheme, hash, true
+		}
+	}
+
+	return nil, hash, false
+}
+
+// SetDefaultPasswordScheme sets the scheme and cost of new hashes.
+func SetDefaultPasswordScheme(name string, cost int) error {
+	scheme, ok := LookupPasswordScheme(name)
+	if !ok {
+		return fmt.Errorf("%v: %s", errUnknownPasswordScheme, name)
+	}
+
+	// creating a hash validates the cost and resolves the default of the scheme
+	hash, err := scheme.Hash("", cost)
+	if err != nil {
+		return err
+	}
+	if coster, ok := scheme.(PasswordCoster); ok {
+		cost, _ = coster.Cost(hash)
+	}
+
+	passwordSchemes.Lock()
+	defer passwordSchemes.Unlock()
+
+	passwordSchemes.defaultScheme = scheme.Name()
+	passwordSchemes.defaultCost = cost
+
+	return nil
+}
+
+// PasswordHashInfo returns the scheme and the cost of the hash, the cost is 0
+// for schemes without work factor.
+func PasswordHashInfo(hash string) (name string, cost int, ok bool) {
+	scheme, hash, ok := DetectPasswordScheme(hash)
+	if !ok {
+		return "", 0, false
+	}
+
+	if coster, isCoster := scheme.(PasswordCoster); isCoster {
+		cost, _ = coster.Cost(hash)
+	}
+
+	return scheme.Name(), cost, true
+}
+
+// NeedsRehash reports whether the hash should be replaced because it doesn't
+// use the default scheme or a lower cost.
+func NeedsRehash(hash string) bool {
+	name, cost, ok := PasswordHashInfo(hash)
+	if !ok {
+		return false
+	}
+
+	return outdated(name, cost)
+}
+
+func outdated(name string, cost int) bool {
+	passwordSchemes.RLock()
+	defer passwordSchemes.RUnlock()
+
+	return name != passwordSchemes.defaultScheme || cost < passwordSchemes.defaultCost
+}
+
+// PasswordHashCount is the number of hashes with a scheme and cost. Hashes of
+// unknown schemes are counted as the scheme "unknown".
+type PasswordHashCount struct {
+	Scheme   string
+	Cost     int
+	Count    int
+	Outdated bool
+}
+
+// CountPasswordHashes counts the hashes by scheme and cost, sorted by both.
+func CountPasswordHashes(hashes []string) []PasswordHashCount {
+	counts := map[PasswordHashCount]int{}
+	for _, hash := range hashes {
+		name, cost, ok := PasswordHashInfo(hash)
+		if !ok {
+			name = "unknown"
+		}
+
+		counts[PasswordHashCount{Scheme: name, Cost: cost, Outdated: ok && outdated(name, cost)}]++
+	}
+
+	result := []PasswordHashCount{}
+	for count, n := range counts {
+		count.Count = n
+		result = append(result, count)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Scheme != result[j].Scheme {
+			return result[i].Scheme < result[j].Scheme
+		}
+		return result[i].Cost < result[j].Cost
+	})
+
+	return result
+}
+
+// VerifyPassword checks the password against the hash with the detected
+// scheme.
+func VerifyPassword(encrypted string, plain string) bool {
+	scheme, hash, ok := DetectPasswordScheme(encrypted)
+	if !ok {
+		return false
+	}
+
+	return scheme.Verify(hash, plain)
+}
+
+// HashPassword hashes the password with the default scheme and cost.
+func HashPassword(plain string) (string, error) {
+	passwordSchemes.RLock()
+	name, cost := passwordSchemes.defaultScheme, passwordSchemes.defaultCost
+	passwordSchemes.RUnlock()
+
+	return HashPasswordWith(name, cost, plain)
+}
+
+// HashPasswordWith hashes the password with the scheme and cost.
+func HashPasswordWith(name string, cost int, plain string) (string, error) {
+	scheme, ok := LookupPasswordScheme(name)
+	if !ok {
+		return "", fmt.Errorf("%v: %s", errUnknownPasswordScheme, name)
+	}
+
+	return scheme.Hash(plain, cost)
+}
@@ -0,0 +1,201 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestVerifyPassword_Schemes(t *testing.T) {
+	hashes := map[string]string{
+		"sha256-crypt":        "$5$saltstring$O7oDIcUlbNNSXwFDkfo3OgUHWouWNc50xsLb4oDEec8",
+		"sha512-crypt":        "$6$saltstring$W4OJbbRWemb6GNnTMa9RlcSozfJ18FNF6ikNnfZxheFZjw2qm0x2U9jSLH1bCqRSluDgFHJWXyl3.cA1TCX/a.",
+		"sha512-crypt rounds": "$6$rounds=10000$saltstringsaltst$JpS66qC.gzOwLPVGtrIKCPaHsRp8jfIjAn69HjIOb58imfqj7nbL1V31EQAlqCNxMExVgymjTw2PqdXMjUhDr/",
+		"pbkdf2":              "$pbkdf2$1000$MDEyMzQ1Njc4OWFiY2RlZg$8l7RPnePpL6H.hMBazSei1BV/lA",
+		"pbkdf2-sha256":       "$pbkdf2-sha256$1000$MDEyMzQ1Njc4OWFiY2RlZg$mDxudWMvC5bmtzVzV0qog6f8nvKhgvwn64CR7PFsa4g",
+		"pbkdf2-sha512":       "$pbkdf2-sha512$1000$MDEyMzQ1Njc4OWFiY2RlZg$bliuGfHG1D3aoRl5O.ot6Y81wh5jmtTId59pP6TSt9nWkW2vVqb1lhh10TlVsEGS1KboLLWiAK6MQxckgh39og",
+		"scrypt":              "$scrypt$ln=4,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$Bo0bPdw37.k.NUq5zGpDozes95Z2j.r9IEqbV31yzbw",
+		"sha":                 "{SHA}cojt0Pw//L6ToM8G41aOKFIWh7w=",
+		"ssha":                "{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==",
+		"ssha512":             "{SSHA512}47XHNGZ4hy2OfHW6FwdEVD3cJq/JavrdeTYo6NO53t8QmAY6K/7mF6v9aCaj/agGbIwsB8i815z+LrTz+9RIn3NhbHRzYWx0",
+	}
+
+	for scheme, hash := range hashes {
+		Convey("Given a "+scheme+" hash", t, func() {
+			Convey("Then the correct password is accepted", func() {
+				So(VerifyPassword(hash, "test123"), ShouldBeTrue)
+			})
+
+			Convey("Then a wrong password is rejected", func() {
+				So(VerifyPassword(hash, "test124"), ShouldBeFalse)
+			})
+		})
+	}
+
+	Convey("Given a hash of an unknown scheme", t, func() {
+		So(VerifyPassword("{MD4}abc", "abc"), ShouldBeFalse)
+		So(VerifyPassword("test123", "test123"), ShouldBeFalse)
+	})
+}
+
+func TestHashPassword(t *testing.T) {
+	costs := map[string]int{
+		"bcrypt":        4,
+		"argon2id":      1,
+		"scrypt":        4,
+		"pbkdf2-sha256": 1000,
+		"sha512-crypt":  1000,
+		"ssha":          0,
+		"ssha512":       0,
+	}
+
+	for name, cost := range costs {
+		Convey("Given a "+name+" hash", t, func() {
+			hash, err := HashPasswordWith(name, cost, "test123")
+			So(err, ShouldBeNil)
+
+			Convey("Then the scheme is detected", func() {
+				scheme, _, ok := DetectPasswordScheme(hash)
+				So(ok, ShouldBeTrue)
+				So(scheme.Name(), ShouldEqual, name)
+			})
+
+			Convey("Then the password is verified", func() {
+				So(VerifyPassword(hash, "test123"), ShouldBeTrue)
+				So(VerifyPassword(hash, "test124"), ShouldBeFalse)
+			})
+		})
+	}
+
+	Convey("Given a deprecated scheme", t, func() {
+		_, err := HashPasswordWith("md5-crypt", 0, "test123")
+
+		Convey("Then no hash is created", func() {
+			So(err, ShouldEqual, errHashUnsupported)
+		})
+	})
+
+	Convey("Given a default scheme", t, func() {
+		So(SetDefaultPasswordScheme("sha512-crypt", 2000), ShouldBeNil)
+		defer SetDefaultPasswordScheme("bcrypt", 0)
+
+		hash, err := HashPassword("test123")
+
+		Convey("Then new hashes use the scheme and cost", func() {
+			So(err, ShouldBeNil)
+			So(hash, ShouldStartWith, "$6$rounds=2000$")
+		})
+	})
+
+	Convey("Given an invalid default scheme or cost", t, func() {
+		So(SetDefaultPasswordScheme("rot13", 0), ShouldNotBeNil)
+		So(SetDefaultPasswordScheme("bcrypt", 99), ShouldNotBeNil)
+	})
+}
+
+func TestNeedsRehash(t *testing.T) {
+	Convey("Given the default scheme bcrypt with cost 5", t, func() {
+		So(SetDefaultPasswordScheme("bcrypt", 5), ShouldBeNil)
+		defer SetDefaultPasswordScheme("bcrypt", 0)
+
+		Convey("Then the costs of the hashes are reported", func() {
+			name, cost, ok := PasswordHashInfo("{CRYPT}$6$rounds=10000$saltstringsaltst$JpS66qC.gzOwLPVGtrIKCPaHsRp8jfIjAn69HjIOb58imfqj7nbL1V31EQAlqCNxMExVgymjTw2PqdXMjUhDr/")
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "sha512-crypt")
+			So(cost, ShouldEqual, 10000)
+
+			name, cost, ok = PasswordHashInfo("{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==")
+			So(ok, ShouldBeTrue)
+			So(name, ShouldEqual, "ssha")
+			So(cost, ShouldEqual, 0)
+
+			_, _, ok = PasswordHashInfo("test123")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Then hashes of other schemes are rehashed", func() {
+			So(NeedsRehash("{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA=="), ShouldBeTrue)
+			So(NeedsRehash("$scrypt$ln=4,r=8,p=1$MDEyMzQ1Njc4OWFiY2RlZg$Bo0bPdw37.k.NUq5zGpDozes95Z2j.r9IEqbV31yzbw"), ShouldBeTrue)
+		})
+
+		Convey("Then hashes with a lower cost are rehashed", func() {
+			So(NeedsRehash(bcryptHashWithCost(4)), ShouldBeTrue)
+			So(NeedsRehash(bcryptHashWithCost(5)), ShouldBeFalse)
+			So(NeedsRehash(bcryptHashWithCost(6)), ShouldBeFalse)
+		})
+
+		Convey("Then unknown hashes are kept", func() {
+			So(NeedsRehash("test123"), ShouldBeFalse)
+		})
+	})
+
+	Convey("Given the default scheme bcrypt without cost", t, func() {
+		Convey("Then the default cost of the scheme is the target", func() {
+			So(NeedsRehash(bcryptHashWithCost(11)), ShouldBeTrue)
+		})
+	})
+}
+
+func TestCountPasswordHashes(t *testing.T) {
+	Convey("Given hashes of several schemes and costs", t, func() {
+		So(SetDefaultPasswordScheme("bcrypt", 5), ShouldBeNil)
+		defer SetDefaultPasswordScheme("bcrypt", 0)
+
+		hashes := []string{
+			bcryptHashWithCost(5),
+			bcryptHashWithCost(4),
+			bcryptHashWithCost(5),
+			"{SSHA}31zaErJMBpi0O4UJg6LaSV4B8TRzYWx0c2FsdA==",
+			"test123",
+		}
+
+		Convey("Then they are counted by scheme and cost", func() {
+			So(CountPasswordHashes(hashes), ShouldResemble, []PasswordHashCount{
+				{Scheme: "bcrypt", Cost: 4, Count: 1, Outdated: true},
+				{Scheme: "bcrypt", Cost: 5, Count: 2},
+				{Scheme: "ssha", Count: 1, Outdated: true},
+				{Scheme: "unknown", Count: 1},
+			})
+		})
+	})
+}
+
+func TestAllowCleartextPasswords(t *testing.T) {
+	Convey("Given cleartext passwords", t, func() {
+		So(VerifyPassword("{CLEARTEXT}test123", "test123"), ShouldBeFalse)
+
+		Convey("When cleartext passwords are allowed", func() {
+			AllowCleartextPasswords()
+
+			Convey("Then they are compared", func() {
+				So(VerifyPassword("{CLEARTEXT}test123", "test123"), ShouldBeTrue)
+				So(VerifyPassword("{CLEARTEXT}test123", "test124"), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func bcryptHashWithCost(cost int) string {
+	hash, err := HashPasswordWith("bcrypt", cost, "test123")
+	So(err, ShouldBeNil)
+	return hash
+}
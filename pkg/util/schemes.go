@@ -0,0 +1,375 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCost = errors.New("password: invalid cost")
+
+// the length of generated salts in bytes
+const saltLength = 16
+
+type bcryptScheme struct{}
+
+func (bcryptScheme) Name() string {
+	return "bcrypt"
+}
+
+func (bcryptScheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$2")
+}
+
+func (bcryptScheme) Verify(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (bcryptScheme) Hash(password string, cost int) (string, error) {
+	if cost == 0 {
+		cost = 12
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	return string(hash), err
+}
+
+func (bcryptScheme) Cost(hash string) (int, bool) {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return cost, err == nil
+}
+
+// argon2Scheme creates argon2id hashes in the phc string format
+// $argon2id$v=19$m=65536,t=3,p=4$salt$hash, the cost is the number of passes.
+type argon2Scheme struct{}
+
+func (argon2Scheme) Name() string {
+	return "argon2id"
+}
+
+func (argon2Scheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (argon2Scheme) Verify(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[2] != "v=19" {
+		return false
+	}
+
+	var memory, time uint32
+	var threads uint8
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil || time == 0 || threads == 0 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	digest, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(digest) == 0 {
+		return false
+	}
+
+	return constantTimeEqual(string(digest), string(argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(digest)))))
+}
+
+func (argon2Scheme) Hash(password string, cost int) (string, error) {
+	if cost == 0 {
+		cost = 3
+	}
+	if cost < 1 || cost > 100 {
+		return "", errInvalidCost
+	}
+
+	const memory, threads = 64 * 1024, 4
+
+	salt, err := randomBytes(saltLength)
+	if err != nil {
+		return "", err
+	}
+
+	digest := argon2.IDKey([]byte(password), salt, uint32(cost), memory, threads, 32)
+
+	return fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s", memory, cost, threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(digest)), nil
+}
+
+func (argon2Scheme) Cost(hash string) (int, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return 0, false
+	}
+
+	var memory, time, threads int
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	return time, err == nil
+}
+
+// scryptScheme creates hashes in the format of passlib
+// $scrypt$ln=15,r=8,p=1$salt$hash, the cost is the binary logarithm of N.
+type scryptScheme struct{}
+
+func (scryptScheme) Name() string {
+	return "scrypt"
+}
+
+func (scryptScheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$scrypt$")
+}
+
+func (scryptScheme) Verify(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return false
+	}
+
+	var ln, r, p int
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &p)
+	if err != nil || ln < 1 || ln > 30 {
+		return false
+	}
+
+	salt, err := decodeAdaptedBase64(parts[3])
+	if err != nil {
+		return false
+	}
+	digest, err := decodeAdaptedBase64(parts[4])
+	if err != nil || len(digest) == 0 {
+		return false
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<uint(ln), r, p, len(digest))
+	return err == nil && constantTimeEqual(string(digest), string(key))
+}
+
+func (scryptScheme) Hash(password string, cost int) (string, error) {
+	if cost == 0 {
+		cost = 15
+	}
+	if cost < 1 || cost > 30 {
+		return "", errInvalidCost
+	}
+
+	salt, err := randomBytes(saltLength)
+	if err != nil {
+		return "", err
+	}
+
+	digest, err := scrypt.Key([]byte(password), salt, 1<<uint(cost), 8, 1, 32)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$scrypt$ln=%d,r=8,p=1$%s$%s", cost, encodeAdaptedBase64(salt), encodeAdaptedBase64(digest)), nil
+}
+
+func (scryptScheme) Cost(hash string) (int, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return 0, false
+	}
+
+	var ln, r, p int
+	_, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &p)
+	return ln, err == nil
+}
+
+// pbkdf2Scheme creates hashes in the format of passlib
+// $pbkdf2-sha256$iterations$salt$hash, the cost is the number of iterations.
+type pbkdf2Scheme struct {
+	name        string
+	newHash     func() hash.Hash
+	size        int
+	defaultCost int
+}
+
+var pbkdf2Schemes = map[string]*pbkdf2Scheme{
+	"pbkdf2":        {name: "pbkdf2", newHash: sha1.New, size: sha1.Size, defaultCost: 1300000},
+	"pbkdf2-sha256": {name: "pbkdf2-sha256", newHash: sha256.New, size: sha256.Size, defaultCost: 600000},
+	"pbkdf2-sha512": {name: "pbkdf2-sha512", newHash: sha512.New, size: sha512.Size, defaultCost: 210000},
+}
+
+func (scheme *pbkdf2Scheme) Name() string {
+	return scheme.name
+}
+
+func (scheme *pbkdf2Scheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, "$"+scheme.name+"$")
+}
+
+func (scheme *pbkdf2Scheme) Verify(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return false
+	}
+
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil || iterations < 1 {
+		return false
+	}
+
+	salt, err := decodeAdaptedBase64(parts[3])
+	if err != nil {
+		return false
+	}
+	digest, err := decodeAdaptedBase64(parts[4])
+	if err != nil || len(digest) == 0 {
+		return false
+	}
+
+	return constantTimeEqual(string(digest), string(pbkdf2.Key([]byte(password), salt, iterations, len(digest), scheme.newHash)))
+}
+
+func (scheme *pbkdf2Scheme) Hash(password string, cost int) (string, error) {
+	if cost == 0 {
+		cost = scheme.defaultCost
+	}
+	if cost < 1 {
+		return "", errInvalidCost
+	}
+
+	salt, err := randomBytes(saltLength)
+	if err != nil {
+		return "", err
+	}
+
+	digest := pbkdf2.Key([]byte(password), salt, cost, scheme.size, scheme.newHash)
+
+	return fmt.Sprintf("$%s$%d$%s$%s", scheme.name, cost, encodeAdaptedBase64(salt), encodeAdaptedBase64(digest)), nil
+}
+
+func (scheme *pbkdf2Scheme) Cost(hash string) (int, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 {
+		return 0, false
+	}
+
+	iterations, err := strconv.Atoi(parts[2])
+	return iterations, err == nil
+}
+
+// shaScheme handles the rfc 2307 schemes {SHA} and {SSHA} and their sha-2
+// variants. The salted digests are followed by the salt.
+type shaScheme struct {
+	prefix  string
+	newHash func() hash.Hash
+	size    int
+	salted  bool
+}
+
+var shaSchemes = map[string]*shaScheme{
+	"sha":     {prefix: "{SHA}", newHash: sha1.New, size: sha1.Size},
+	"ssha":    {prefix: "{SSHA}", newHash: sha1.New, size: sha1.Size, salted: true},
+	"sha256":  {prefix: "{SHA256}", newHash: sha256.New, size: sha256.Size},
+	"ssha256": {prefix: "{SSHA256}", newHash: sha256.New, size: sha256.Size, salted: true},
+	"sha512":  {prefix: "{SHA512}", newHash: sha512.New, size: sha512.Size},
+	"ssha512": {prefix: "{SSHA512}", newHash: sha512.New, size: sha512.Size, salted: true},
+}
+
+func (scheme *shaScheme) Name() string {
+	return strings.ToLower(strings.Trim(scheme.prefix, "{}"))
+}
+
+func (scheme *shaScheme) Match(hash string) bool {
+	return len(hash) > len(scheme.prefix) && strings.EqualFold(hash[:len(scheme.prefix)], scheme.prefix)
+}
+
+func (scheme *shaScheme) Verify(hash string, password string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(hash[len(scheme.prefix):])
+	if err != nil || len(decoded) < scheme.size || !scheme.salted && len(decoded) != scheme.size {
+		return false
+	}
+
+	digest, salt := decoded[:scheme.size], decoded[scheme.size:]
+
+	return constantTimeEqual(string(digest), string(scheme.digest(password, salt)))
+}
+
+func (scheme *shaScheme) Hash(password string, cost int) (string, error) {
+	var salt []byte
+	if scheme.salted {
+		var err error
+		salt, err = randomBytes(8)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return scheme.prefix + base64.StdEncoding.EncodeToString(append(scheme.digest(password, salt), salt...)), nil
+}
+
+func (scheme *shaScheme) digest(password string, salt []byte) []byte {
+	h := scheme.newHash()
+	h.Write([]byte(password))
+	h.Write(salt)
+	return h.Sum(nil)
+}
+
+// cleartextScheme compares {CLEARTEXT} passwords, it is only registered for
+// development.
+type cleartextScheme struct{}
+
+func (cleartextScheme) Name() string {
+	return "cleartext"
+}
+
+func (cleartextScheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, "{CLEARTEXT}")
+}
+
+func (cleartextScheme) Verify(hash string, password string) bool {
+	return constantTimeEqual(hash[len("{CLEARTEXT}"):], password)
+}
+
+func (cleartextScheme) Hash(password string, cost int) (string, error) {
+	return "{CLEARTEXT}" + password, nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// the base64 variant of passlib using . instead of + and no padding
+func encodeAdaptedBase64(b []byte) string {
+	return strings.Replace(base64.RawStdEncoding.EncodeToString(b), "+", ".", -1)
+}
+
+func decodeAdaptedBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.Replace(s, ".", "+", -1))
+}
@@ -0,0 +1,240 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+)
+
+// shaCryptScheme implements the sha-256 and sha-512 based crypt of glibc,
+// $5$ and $6$. The cost is the number of rounds.
+type shaCryptScheme struct {
+	name    string
+	magic   string
+	newHash func() hash.Hash
+	// the order of the digest bytes in the encoded hash
+	order [][3]int
+	// the bytes encoded last and the number of characters
+	last     []int
+	lastSize int
+}
+
+var shaCryptSchemes = map[string]*shaCryptScheme{
+	"sha256-crypt": {
+		name:    "sha256-crypt",
+		magic:   "$5$",
+		newHash: sha256.New,
+		order: [][3]int{
+			{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+			{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+		},
+		last:     []int{31, 30},
+		lastSize: 3,
+	},
+	"sha512-crypt": {
+		name:    "sha512-crypt",
+		magic:   "$6$",
+		newHash: sha512.New,
+		order: [][3]int{
+			{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+			{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+			{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+			{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+			{62, 20, 41},
+		},
+		last:     []int{63},
+		lastSize: 2,
+	},
+}
+
+func (scheme *shaCryptScheme) Name() string {
+	return scheme.name
+}
+
+func (scheme *shaCryptScheme) Match(hash string) bool {
+	return strings.HasPrefix(hash, scheme.magic)
+}
+
+func (scheme *shaCryptScheme) Verify(hash string, password string) bool {
+	rounds, custom, salt := parseShaCrypt(strings.TrimPrefix(hash, scheme.magic))
+
+	return constantTimeEqual(hash, scheme.crypt(password, salt, rounds, custom))
+}
+
+func (scheme *shaCryptScheme) Hash(password string, cost int) (string, error) {
+	if cost == 0 {
+		cost = shaCryptDefaultRounds
+	}
+	if cost < shaCryptMinRounds || cost > shaCryptMaxRounds {
+		return "", errInvalidCost
+	}
+
+	b, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, len(b))
+	for i := range b {
+		salt[i] = itoa64[b[i]&0x3f]
+	}
+
+	return scheme.crypt(password, string(salt), cost, cost != shaCryptDefaultRounds), nil
+}
+
+func (scheme *shaCryptScheme) Cost(hash string) (int, bool) {
+	rounds, _, _ := parseShaCrypt(strings.TrimPrefix(hash, scheme.magic))
+	return rounds, true
+}
+
+// parseShaCrypt returns the rounds and the salt of the hash without magic
+func parseShaCrypt(hash string) (rounds int, custom bool, salt string) {
+	rounds = shaCryptDefaultRounds
+
+	if strings.HasPrefix(hash, "rounds=") {
+		end := strings.IndexByte(hash, '$')
+		if end > 0 {
+			if r, err := strconv.Atoi(hash[len("rounds="):end]); err == nil {
+				rounds, custom, hash = r, true, hash[end+1:]
+			}
+		}
+	}
+
+	if i := strings.IndexByte(hash, '$'); i >= 0 {
+		hash = hash[:i]
+	}
+
+	return clampRounds(rounds), custom, hash
+}
+
+func clampRounds(rounds int) int {
+	switch {
+	case rounds < shaCryptMinRounds:
+		return shaCryptMinRounds
+	case rounds > shaCryptMaxRounds:
+		return shaCryptMaxRounds
+	default:
+		return rounds
+	}
+}
+
+func (scheme *shaCryptScheme) crypt(password, salt string, rounds int, custom bool) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+
+	pw, s := []byte(password), []byte(salt)
+
+	alternate := scheme.newHash()
+	alternate.Write(pw)
+	alternate.Write(s)
+	alternate.Write(pw)
+	b := alternate.Sum(nil)
+
+	a := scheme.newHash()
+	a.Write(pw)
+	a.Write(s)
+	a.Write(repeat(b, len(pw)))
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			a.Write(b)
+		} else {
+			a.Write(pw)
+		}
+	}
+	digest := a.Sum(nil)
+
+	dp := scheme.newHash()
+	for range pw {
+		dp.Write(pw)
+	}
+	p := repeat(dp.Sum(nil), len(pw))
+
+	ds := scheme.newHash()
+	for i := 0; i < 16+int(digest[0]); i++ {
+		ds.Write(s)
+	}
+	s = repeat(ds.Sum(nil), len(s))
+
+	for i := 0; i < rounds; i++ {
+		c := scheme.newHash()
+		if i&1 != 0 {
+			c.Write(p)
+		} else {
+			c.Write(digest)
+		}
+		if i%3 != 0 {
+			c.Write(s)
+		}
+		if i%7 != 0 {
+			c.Write(p)
+		}
+		if i&1 != 0 {
+			c.Write(digest)
+		} else {
+			c.Write(p)
+		}
+		digest = c.Sum(nil)
+	}
+
+	result := []byte(scheme.magic)
+	if custom {
+		result = append(result, "rounds="+strconv.Itoa(rounds)+"$"...)
+	}
+	result = append(result, salt+"$"...)
+
+	for _, group := range scheme.order {
+		v := uint(digest[group[0]])<<16 | uint(digest[group[1]])<<8 | uint(digest[group[2]])
+		result = appendBase64(result, v, 4)
+	}
+
+	var v uint
+	for _, i := range scheme.last {
+		v = v<<8 | uint(digest[i])
+	}
+	result = appendBase64(result, v, scheme.lastSize)
+
+	return string(result)
+}
+
+// repeat repeats the bytes up to the length
+func repeat(b []byte, length int) []byte {
+	result := make([]byte, 0, length)
+	for len(result) < length {
+		n := length - len(result)
+		if n > len(b) {
+			n = len(b)
+		}
+		result = append(result, b[:n]...)
+	}
+
+	return result
+}
@@ -22,13 +22,8 @@ package util
 
 import (
 	"context"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func VerifyPassword(encrypted string, plain string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(encrypted), []byte(plain)) == nil
-}
-
 func VerifyPasswordCtx(ctx context.Context, encrypted string, plain string) bool {
 	rChan := make(chan bool)
 
@@ -43,8 +38,3 @@ func VerifyPasswordCtx(ctx context.Context, encrypted string, plain string) bool
 		return false
 	}
 }
-
-func HashPassword(plain string, cost int) string {
-	encrypted, _ := bcrypt.GenerateFromPassword([]byte(plain), cost)
-	return string(encrypted)
-}
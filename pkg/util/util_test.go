@@ -27,22 +27,28 @@ import (
 
 func TestPasswordHelper(t *testing.T) {
 	Convey("Verify the password ''", t, func() {
-		So(VerifyPassword(HashPassword("", 4), ""), ShouldBeTrue)
+		So(VerifyPassword(bcryptHash(""), ""), ShouldBeTrue)
 		So(VerifyPassword("$2y$04$cKpQ30fsvP7wBJ//mZzEB.tQaLOIvw5y0Jt4xpMaF6cVbqXkXltaq", ""), ShouldBeTrue)
 	})
 
 	Convey("Verify the password 'a'", t, func() {
-		So(VerifyPassword(HashPassword("a", 4), "a"), ShouldBeTrue)
+		So(VerifyPassword(bcryptHash("a"), "a"), ShouldBeTrue)
 		So(VerifyPassword("$2a$04$wIvmqg9WXCUKrr/kI6AOgOeKR5gLTWAPfn8fqJVrIvA0r03oNOYb6", "a"), ShouldBeTrue)
 	})
 
 	Convey("Verify the password 'abcdefg'", t, func() {
-		So(VerifyPassword(HashPassword("abcdefg", 4), "abcdefg"), ShouldBeTrue)
+		So(VerifyPassword(bcryptHash("abcdefg"), "abcdefg"), ShouldBeTrue)
 		So(VerifyPassword("$2a$04$h0PYJJ8cVWJuRW7OrLGGLuunLymVAhFZhotHM2Gz3nvOiJTGoZzWa", "abcdefg"), ShouldBeTrue)
 	})
 
 	Convey("Test wrong password", t, func() {
-		So(VerifyPassword(HashPassword("a", 4), "b"), ShouldBeFalse)
-		So(VerifyPassword(HashPassword("b", 4), "a"), ShouldBeFalse)
+		So(VerifyPassword(bcryptHash("a"), "b"), ShouldBeFalse)
+		So(VerifyPassword(bcryptHash("b"), "a"), ShouldBeFalse)
 	})
 }
+
+func bcryptHash(password string) string {
+	hash, err := HashPasswordWith("bcrypt", 4, password)
+	So(err, ShouldBeNil)
+	return hash
+}
@@ -0,0 +1,77 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	errNotValue = errors.New("attribute values must be a value or a list of values")
+)
+
+// Values are attribute values which are given as a single value or as a list
+// of values in JSON or YAML.
+type Values []string
+
+func (v *Values) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	*v, err = toValues(raw)
+	return err
+}
+
+func (v *Values) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	*v, err = toValues(raw)
+	return err
+}
+
+func toValues(raw interface{}) (Values, error) {
+	switch raw := raw.(type) {
+	case []interface{}:
+		result := Values{}
+		for _, item := range raw {
+			value, err := toValues(item)
+			if err != nil || len(value) != 1 {
+				return nil, errNotValue
+			}
+
+			result = append(result, value[0])
+		}
+		return result, nil
+	case string, bool, int, int64, float64:
+		return Values{fmt.Sprint(raw)}, nil
+	default:
+		return nil, errNotValue
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// A FileWatcher detects changes of a file by its modification time and size.
+// It doesn't receive events of the file system but stats the file on every call
+// of Changed, so a change keeping both the size and the modification time (e.g.
+// within the resolution of the file system) isn't detected.
+type FileWatcher struct {
+	path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+func NewFileWatcher(path string) *FileWatcher {
+	return &FileWatcher{
+		path: path,
+		size: -1,
+	}
+}
+
+// Changed reports whether the file changed since the last call. The first call
+// always reports a change. The modification time and size are remembered even
+// if the caller fails to load the changed file, so a broken file is read again
+// only after it changed again.
+func (watcher *FileWatcher) Changed() (bool, error) {
+	info, err := os.Stat(watcher.path)
+	if err != nil {
+		return false, err
+	}
+
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	if info.ModTime().Equal(watcher.modTime) && info.Size() == watcher.size {
+		return false, nil
+	}
+
+	watcher.modTime = info.ModTime()
+	watcher.size = info.Size()
+
+	return true, nil
+}
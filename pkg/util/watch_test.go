@@ -0,0 +1,71 @@
+// Copyright © 2017 Stefan Kollmann
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatcher(t *testing.T) {
+	dir, cleanup := TmpDir(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "watched")
+
+	Convey("Given a watched file", t, func() {
+		So(ioutil.WriteFile(file, []byte("a"), 0600), ShouldBeNil)
+		watcher := NewFileWatcher(file)
+
+		Convey("Then the first call reports a change", func() {
+			changed, err := watcher.Changed()
+			So(err, ShouldBeNil)
+			So(changed, ShouldBeTrue)
+
+			Convey("Then an unchanged file isn't reported", func() {
+				changed, err := watcher.Changed()
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeFalse)
+			})
+
+			Convey("Then a modified file is reported", func() {
+				So(ioutil.WriteFile(file, []byte("b"), 0600), ShouldBeNil)
+				modTime := time.Now().Add(time.Minute)
+				So(os.Chtimes(file, modTime, modTime), ShouldBeNil)
+
+				changed, err := watcher.Changed()
+				So(err, ShouldBeNil)
+				So(changed, ShouldBeTrue)
+			})
+		})
+
+		Convey("Then a missing file is an error", func() {
+			So(os.Remove(file), ShouldBeNil)
+
+			_, err := watcher.Changed()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}